@@ -10,11 +10,18 @@ import (
 
 // Config is main application configuration structure.
 type config struct {
-	Listen   string        `env:"LISTEN" envDefault:"localhost:9000"`
-	Timeout  time.Duration `env:"TIMEOUT" envDefault:"10ms"`
-	LogLevel string        `env:"LOG_LEVEL" envDefault:"info"`
-	LogFmt   string        `env:"LOG_FMT" envDefault:"console"`
-	DbFile   string        `env:"DB_FILE" envDefault:"./tmp/nutsdb"`
+	Listen            string        `env:"LISTEN" envDefault:"localhost:9000"`
+	Timeout           time.Duration `env:"TIMEOUT" envDefault:"1s"`
+	WriteTimeout      time.Duration `env:"WRITE_TIMEOUT" envDefault:"1m"`
+	LogLevel          string        `env:"LOG_LEVEL" envDefault:"info"`
+	LogFmt            string        `env:"LOG_FMT" envDefault:"console"`
+	DbFile            string        `env:"DB_FILE" envDefault:"./tmp/nutsdb"`
+	Storage           string        `env:"STORAGE" envDefault:"nutsdb"`
+	IndexWorkers      int           `env:"INDEX_WORKERS"`
+	IndexChunkSize    int           `env:"INDEX_CHUNK_SIZE" envDefault:"1000"`
+	IdempotencyWindow time.Duration `env:"IDEMPOTENCY_WINDOW" envDefault:"24h"`
+	SweepInterval     time.Duration `env:"SWEEP_INTERVAL" envDefault:"1m"`
+	FilterCacheSize   int           `env:"FILTER_CACHE_SIZE" envDefault:"1000"`
 }
 
 func load() (*config, error) {
@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/polyse/database/internal/api"
+	"github.com/polyse/database/internal/idempotency"
+	"github.com/polyse/database/internal/jobs"
+	"github.com/polyse/database/internal/storage"
 	"github.com/polyse/database/pkg/filters"
 	"github.com/rs/zerolog"
 	"github.com/xujiajun/nutsdb"
@@ -56,13 +60,30 @@ func main() {
 	closer.Bind(cancel)
 
 	log.Debug().Msg("starting db")
-	var connCLoser func()
-	a.Manager, connCLoser, err = initProcessorManager(cfg, "default")
+	db, connCLoser, err := initDatabase(cfg, "default")
 	if err != nil {
-		log.Err(err).Msg("can not init proc manager")
+		log.Err(err).Msg("can not init database")
 		return
 	}
 	closer.Bind(connCLoser)
+	a.Manager, a.Queue, a.Idempotency = db.manager, db.queue, db.idempotency
+
+	// Jobs and the sweeper must be stopped before the connection is closed,
+	// closer runs functions in reverse order.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		db.queue.Run(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		db.manager.RunSweeper(ctx, cfg.SweepInterval)
+	}()
+	closer.Bind(func() {
+		cancelCtx()
+		wg.Wait()
+	})
 
 	log.Debug().Msg("starting web application")
 	if err = a.Run(); err != nil {
@@ -70,6 +91,27 @@ func main() {
 	}
 }
 
+// database contains collections, the queue of ingestion jobs and recorded responses sharing the same storage.
+type database struct {
+	manager     *collection.Manager
+	queue       *jobs.Queue
+	idempotency *idempotency.Store
+}
+
+func newDatabase(manager *collection.Manager, queue *jobs.Queue, records *idempotency.Store) *database {
+	return &database{manager: manager, queue: queue, idempotency: records}
+}
+
+func initIdempotency(store storage.Storage, c *config) *idempotency.Store {
+	log.Debug().Dur("window", c.IdempotencyWindow).Msg("initialize idempotency store")
+	return idempotency.NewStore(store, c.IdempotencyWindow)
+}
+
+func initQueue(store storage.Storage, manager *collection.Manager, procCfg collection.ProcessorConfig) *jobs.Queue {
+	log.Debug().Msg("initialize job queue")
+	return jobs.NewQueue(store, manager, procCfg.ChunkSize)
+}
+
 func initTokenizer() filters.Tokenizer {
 	log.Debug().Msg("initialize tokenizer")
 	return filters.FilterText
@@ -80,9 +122,33 @@ func initFilters() []filters.Filter {
 	return []filters.Filter{filters.StemmAndToLower, filters.StopWords}
 }
 
-func initConnection(cfg collection.Config) (*nutsdb.DB, func(), error) {
+func initProcessor(
+	store storage.Storage,
+	procCfg collection.ProcessorConfig,
+	colName collection.Name,
+	tokenizer filters.Tokenizer,
+	textFilters ...filters.Filter,
+) (*collection.SimpleProcessor, error) {
+	log.Debug().Str("collection", string(colName)).Msg("initialize processor")
+	proc := collection.NewSimpleProcessor(store, procCfg, colName, tokenizer, textFilters...)
+	if err := proc.Migrate(); err != nil {
+		return nil, err
+	}
+	return proc, nil
+}
+
+func initConnection(cfg collection.Config) (storage.Storage, func(), error) {
 	log.Debug().Interface("configuration", cfg).Msg("opening new connection to database")
 
+	switch cfg.Storage {
+	case "memory":
+		log.Info().Msg("using in-memory storage, data will be lost on shutdown")
+		return storage.NewMemory(), func() {}, nil
+	case "nutsdb":
+	default:
+		return nil, nil, fmt.Errorf("unknown storage %s", cfg.Storage)
+	}
+
 	opt := nutsdb.DefaultOptions
 	opt.Dir = cfg.File
 	nutsDb, err := nutsdb.Open(opt)
@@ -90,7 +156,7 @@ func initConnection(cfg collection.Config) (*nutsdb.DB, func(), error) {
 		return nil, nil, err
 	}
 	log.Info().Msg("connection opened")
-	return nutsDb, func() {
+	return storage.NewNuts(nutsDb), func() {
 		log.Info().Msg("start closing database connection")
 		if err = nutsDb.Merge(); err != nil {
 			log.Err(err).Msg("can not merge database")
@@ -102,11 +168,24 @@ func initConnection(cfg collection.Config) (*nutsdb.DB, func(), error) {
 }
 
 func initDbConfig(c *config) collection.Config {
-	return collection.Config{File: c.DbFile}
+	return collection.Config{File: c.DbFile, Storage: c.Storage}
+}
+
+func initProcessorConfig(c *config) collection.ProcessorConfig {
+	return collection.ProcessorConfig{
+		Workers:         c.IndexWorkers,
+		ChunkSize:       c.IndexChunkSize,
+		FilterCacheSize: c.FilterCacheSize,
+	}
 }
 
 func initWebAppCfg(c *config) (api.AppConfig, error) {
-	return api.AppConfig{Timeout: c.Timeout, NetInterface: c.Listen}, nil
+	return api.AppConfig{
+		Timeout:       c.Timeout,
+		WriteTimeout:  c.WriteTimeout,
+		NetInterface:  c.Listen,
+		BulkChunkSize: c.IndexChunkSize,
+	}, nil
 }
 
 func initLogger(c *config) error {
@@ -14,10 +14,11 @@ import (
 var (
 	procSetter = wire.NewSet(
 		initDbConfig,
+		initProcessorConfig,
 		initConnection,
 		initTokenizer,
 		initFilters,
-		collection.NewSimpleProcessor,
+		initProcessor,
 	)
 
 	dbSetter = wire.NewSet(
@@ -27,6 +28,9 @@ var (
 			new(*collection.SimpleProcessor),
 		),
 		collection.NewManagerWithProc,
+		initQueue,
+		initIdempotency,
+		newDatabase,
 	)
 )
 
@@ -35,10 +39,10 @@ func initWebApp(ctx context.Context, c *config) (*api.API, func(), error) {
 	return nil, nil, nil
 }
 
-func initProcessorManager(
+func initDatabase(
 	c *config,
 	collName collection.Name,
-) (*collection.Manager, func(), error) {
+) (*database, func(), error) {
 	wire.Build(dbSetter)
 	return nil, nil, nil
 }
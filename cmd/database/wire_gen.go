@@ -28,17 +28,25 @@ func initWebApp(ctx context.Context, c *config) (*api.API, func(), error) {
 	}, nil
 }
 
-func initProcessorManager(c *config, collName collection.Name) (*collection.Manager, func(), error) {
+func initDatabase(c *config, collName collection.Name) (*database, func(), error) {
 	collectionConfig := initDbConfig(c)
-	db, cleanup, err := initConnection(collectionConfig)
+	storageStorage, cleanup, err := initConnection(collectionConfig)
 	if err != nil {
 		return nil, nil, err
 	}
+	processorConfig := initProcessorConfig(c)
 	tokenizer := initTokenizer()
 	v := initFilters()
-	simpleProcessor := collection.NewSimpleProcessor(db, collName, tokenizer, v...)
+	simpleProcessor, err := initProcessor(storageStorage, processorConfig, collName, tokenizer, v...)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
 	manager := collection.NewManagerWithProc(simpleProcessor)
-	return manager, func() {
+	queue := initQueue(storageStorage, manager, processorConfig)
+	store := initIdempotency(storageStorage, c)
+	mainDatabase := newDatabase(manager, queue, store)
+	return mainDatabase, func() {
 		cleanup()
 	}, nil
 }
@@ -4,12 +4,17 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-playground/validator"
 	"github.com/labstack/echo"
 	"github.com/polyse/database/internal/collection"
+	"github.com/polyse/database/internal/idempotency"
+	"github.com/polyse/database/internal/jobs"
 	"github.com/rs/zerolog/log"
 )
 
@@ -21,15 +26,23 @@ type Context struct {
 
 // API structure containing the necessary server settings and responsible for starting and stopping it.
 type API struct {
-	e    *echo.Echo
-	addr string
+	e             *echo.Echo
+	addr          string
+	timeout       time.Duration
+	writeTimeout  time.Duration
+	bulkChunkSize int
 	*collection.Manager
+	Queue       *jobs.Queue
+	Idempotency *idempotency.Store
 }
 
 // AppConfig structure containing the server settings necessary for its operation.
 type AppConfig struct {
 	NetInterface string
 	Timeout      time.Duration
+	// WriteTimeout limits requests writing documents, writes are not limited if it is negative.
+	WriteTimeout  time.Duration
+	BulkChunkSize int
 }
 
 func (ac *AppConfig) checkConfig() {
@@ -39,7 +52,13 @@ func (ac *AppConfig) checkConfig() {
 		ac.NetInterface = "localhost:9000"
 	}
 	if ac.Timeout <= 0 {
-		ac.Timeout = 10 * time.Millisecond
+		ac.Timeout = time.Second
+	}
+	if ac.WriteTimeout == 0 {
+		ac.WriteTimeout = time.Minute
+	}
+	if ac.BulkChunkSize <= 0 {
+		ac.BulkChunkSize = 1000
 	}
 }
 
@@ -48,11 +67,56 @@ type Documents struct {
 	Documents []collection.RawData `json:"documents" validate:"required,dive"`
 }
 
+// ItemResult describes the result of saving a single document of a batch or of a single bulk action.
+// Version is the version of the document saved or deleted by a bulk action.
+type ItemResult struct {
+	Index   int    `json:"index"`
+	Action  string `json:"action,omitempty"`
+	Url     string `json:"url"`
+	Status  int    `json:"status"`
+	Version uint64 `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BatchResult is the response to adding a batch of documents, it contains a result for every document.
+type BatchResult struct {
+	Errors bool         `json:"errors"`
+	Items  []ItemResult `json:"items"`
+}
+
+// DeleteResult is the response to deleting documents, on error it contains the number of already deleted documents.
+type DeleteResult struct {
+	Deleted int    `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
 // SearchRequest is strust for storage and validate query param.
+// The query can be omitted if filters are given, then all documents matching the filters are found,
+// the most recent first.
 type SearchRequest struct {
-	Query  string `validate:"required" query:"q"`
+	Query  string `validate:"required_without=Filters" query:"q"`
 	Limit  int    `validate:"gte=0" query:"limit"`
 	Offset int    `validate:"gte=0" query:"offset"`
+	// Sort is comma separated names of numeric or date fields to order by, - prefix sorts in descending order.
+	Sort string `query:"sort"`
+	// Filters are conditions of the form name:value on keyword fields or the url host (_host),
+	// they restrict found documents without changing the relevance.
+	Filters []string `query:"filter"`
+}
+
+// MultiGetRequest is struct to Bind urls of documents to get at once.
+type MultiGetRequest struct {
+	Urls []string `json:"urls" validate:"required,min=1,max=1000,dive,required"`
+}
+
+// MultiGetResult is the response to getting documents at once, it contains a result for every url in order.
+type MultiGetResult struct {
+	Docs []collection.GetResult `json:"docs"`
+}
+
+// AnalyzeRequest is struct to Bind text for analyzing.
+type AnalyzeRequest struct {
+	Text string `json:"text" validate:"required"`
 }
 
 // Validator - to add custom validator in echo.
@@ -74,8 +138,11 @@ func NewApp(ctx context.Context, appCfg AppConfig) (*API, error) {
 	e := echo.New()
 
 	a := &API{
-		e:    e,
-		addr: appCfg.NetInterface,
+		e:             e,
+		addr:          appCfg.NetInterface,
+		timeout:       appCfg.Timeout,
+		writeTimeout:  appCfg.WriteTimeout,
+		bulkChunkSize: appCfg.BulkChunkSize,
 	}
 
 	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
@@ -94,7 +161,20 @@ func NewApp(ctx context.Context, appCfg AppConfig) (*API, error) {
 
 	g := e.Group("/api")
 	g.GET("/:collection/documents", a.handleSearch)
-	g.POST("/:collection/documents", a.handleAddDocuments)
+	g.POST("/:collection/_search", a.handleStructuredSearch)
+	g.POST("/_msearch", a.handleMultiSearch)
+	g.POST("/:collection/documents", a.handleAddDocuments, a.idempotent)
+	g.GET("/:collection/documents/_doc", a.handleGetDocument)
+	g.GET("/:collection/documents/_all", a.handleListDocuments)
+	g.GET("/:collection/documents/_scroll", a.handleScroll)
+	g.DELETE("/:collection/documents/_scroll", a.handleClearScroll)
+	g.POST("/:collection/_mget", a.handleMultiGet)
+	g.POST("/:collection/_delete_by_query", a.handleDeleteByQuery)
+	g.POST("/:collection/_bulk", a.handleBulk)
+	g.GET("/jobs/:id", a.handleGetJob)
+	g.POST("/:collection/_analyze", a.handleAnalyze)
+	g.GET("/:collection/_schema", a.handleGetSchema)
+	g.PUT("/:collection/_schema", a.handleSetSchema)
 
 	log.Debug().Msg("endpoints registered")
 
@@ -125,6 +205,8 @@ func (a *API) handleSearch(c echo.Context) error {
 		Str("collection", collectionName).
 		Str("q", request.Query).
 		Int("limit", request.Limit).
+		Str("sort", request.Sort).
+		Strs("filters", request.Filters).
 		Msg("handleSearch run")
 
 	if err = c.Validate(request); err != nil {
@@ -132,9 +214,29 @@ func (a *API) handleSearch(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest)
 	}
 
-	r, err := proc.ProcessAndGet(request.Query, request.Limit, request.Offset)
+	ctx, cancel := a.requestContext(c)
+	defer cancel()
 
+	q := collection.ParseQuery(request.Query)
+	q.Sort = collection.ParseSort(request.Sort)
+	q.Limit, q.Offset = request.Limit, request.Offset
+	for _, s := range request.Filters {
+		f, ok := collection.ParseFilter(s)
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("wrong filter %q", s))
+		}
+		q.Filters = append(q.Filters, f)
+	}
+	r, err := proc.Search(ctx, q)
 	if err != nil {
+		if httpErr := contextError(err); httpErr != nil {
+			log.Debug().Err(err).Msg("handleSearch Search context err")
+			return httpErr
+		}
+		if errors.Is(err, collection.ErrInvalidField) {
+			log.Debug().Err(err).Msg("handleSearch Search err")
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
 		log.Err(err).Msg("saving error")
 		return echo.NewHTTPError(http.StatusInternalServerError)
 	}
@@ -142,6 +244,40 @@ func (a *API) handleSearch(c echo.Context) error {
 	return c.JSON(http.StatusOK, r)
 }
 
+// handleStructuredSearch finds documents by the structured query, see collection.StructuredQuery.
+func (a *API) handleStructuredSearch(c echo.Context) error {
+	collectionName := c.Param("collection")
+	proc, err := a.Manager.GetProcessor(collectionName)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleStructuredSearch GetProcessor err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	query := collection.StructuredQuery{}
+	if err = c.Bind(&query); err != nil {
+		log.Debug().Err(err).Msg("handleStructuredSearch Bind err")
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+	if err = c.Validate(&query); err != nil {
+		log.Debug().Err(err).Msg("handleStructuredSearch Validate err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := a.requestContext(c)
+	defer cancel()
+
+	res, err := proc.SearchStructured(ctx, query)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleStructuredSearch SearchStructured err")
+		status := searchStatus(err)
+		if status == http.StatusBadRequest {
+			return echo.NewHTTPError(status, err.Error())
+		}
+		return echo.NewHTTPError(status)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
 func (a *API) handleAddDocuments(c echo.Context) error {
 	collectionName := c.Param("collection")
 
@@ -155,6 +291,18 @@ func (a *API) handleAddDocuments(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
+	bestEffort, err := strconv.ParseBool(c.QueryParam("best_effort"))
+	if err != nil && c.QueryParam("best_effort") != "" {
+		log.Debug().Err(err).Msg("handleAddDocuments best_effort err")
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	async, err := strconv.ParseBool(c.QueryParam("async"))
+	if err != nil && c.QueryParam("async") != "" {
+		log.Debug().Err(err).Msg("handleAddDocuments async err")
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
 	docs := &Documents{}
 	if err = c.Bind(docs); err != nil {
 		log.Debug().Err(err).Msg("handleAddDocuments Bind err")
@@ -162,21 +310,285 @@ func (a *API) handleAddDocuments(c echo.Context) error {
 	}
 
 	log.Debug().Interface("docs", docs).Msg("before validating")
+	res := newBatchResult(docs.Documents)
 	if len(docs.Documents) == 0 {
-		return c.JSON(http.StatusOK, docs)
+		return c.JSON(http.StatusOK, res)
 	}
 
-	if err = c.Validate(docs); err != nil {
-		log.Debug().Err(err).Msg("handleAddDocuments Validate err")
-		return echo.NewHTTPError(http.StatusBadRequest)
+	// valid contains documents passed validation, indexes maps their indexes to indexes in the request.
+	valid := make([]collection.RawData, 0, len(docs.Documents))
+	indexes := make([]int, 0, len(docs.Documents))
+	for i := range docs.Documents {
+		if err = c.Validate(&docs.Documents[i]); err != nil {
+			log.Debug().Err(err).Int("index", i).Msg("handleAddDocuments Validate err")
+			res.set(i, http.StatusBadRequest, err)
+			continue
+		}
+		valid = append(valid, docs.Documents[i])
+		indexes = append(indexes, i)
 	}
+	// Async jobs are submitted only if all documents are valid.
+	if (!bestEffort || async) && res.Errors {
+		res.setRemaining(http.StatusFailedDependency, errBatchFailed)
+		return c.JSON(http.StatusBadRequest, res)
+	}
+
+	if async {
+		job, err := a.Queue.Submit(collectionName, bestEffort, valid)
+		if err != nil {
+			log.Err(err).Msg("handleAddDocuments Submit err")
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusAccepted, job)
+	}
+
+	ctx, cancel := a.writeContext(c)
+	defer cancel()
 
-	if err = proc.ProcessAndInsertString(docs.Documents); err != nil {
+	var rejected []collection.DocumentError
+	if bestEffort {
+		rejected, err = proc.ProcessAndInsertBestEffort(ctx, valid)
+		if chunkErr, ok := err.(*collection.ChunkError); ok {
+			log.Debug().Err(err).Msg("handleAddDocuments ProcessAndInsertBestEffort err")
+			for _, r := range rejected {
+				res.set(indexes[r.Index], rejectedStatus(r.Err), r.Err)
+			}
+			for i := 0; i < chunkErr.Processed; i++ {
+				if res.Items[indexes[i]].Status == 0 {
+					res.set(indexes[i], http.StatusCreated, nil)
+				}
+			}
+			status := errorStatus(chunkErr.Err)
+			res.setRemaining(status, chunkErr.Err)
+			return c.JSON(status, res)
+		}
+	} else {
+		err = proc.ProcessAndInsertString(ctx, valid)
+		if batchErr, ok := err.(*collection.BatchError); ok {
+			rejected, err = batchErr.Rejected, nil
+		}
+	}
+	if err != nil {
 		log.Debug().Err(err).Msg("handleAddDocuments ProcessAndInsertString err")
-		return echo.NewHTTPError(http.StatusUnprocessableEntity)
+		if httpErr := contextError(err); httpErr != nil {
+			return httpErr
+		}
+		res.setRemaining(http.StatusInternalServerError, err)
+		return c.JSON(http.StatusInternalServerError, res)
 	}
 
-	return c.JSON(http.StatusCreated, docs)
+	// Any version conflict makes the whole request a conflict.
+	status := http.StatusUnprocessableEntity
+	for _, r := range rejected {
+		res.set(indexes[r.Index], rejectedStatus(r.Err), r.Err)
+		if rejectedStatus(r.Err) == http.StatusConflict {
+			status = http.StatusConflict
+		}
+	}
+	if !bestEffort && res.Errors {
+		res.setRemaining(http.StatusFailedDependency, errBatchFailed)
+		return c.JSON(status, res)
+	}
+	res.setRemaining(http.StatusCreated, nil)
+	if len(valid) == len(rejected) {
+		return c.JSON(status, res)
+	}
+	return c.JSON(http.StatusCreated, res)
+}
+
+func (a *API) handleGetDocument(c echo.Context) error {
+	collectionName := c.Param("collection")
+	proc, err := a.Manager.GetProcessor(collectionName)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleGetDocument GetProcessor err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	url := c.QueryParam("url")
+	if url == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, collection.ErrEmptyURL.Error())
+	}
+
+	ctx, cancel := a.requestContext(c)
+	defer cancel()
+
+	doc, err := proc.Get(ctx, url)
+	if err != nil {
+		if err == collection.ErrDocumentNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		if httpErr := contextError(err); httpErr != nil {
+			return httpErr
+		}
+		log.Err(err).Msg("handleGetDocument Get err")
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, doc)
+}
+
+// handleMultiGet returns stored sources of documents with the given urls, missing documents are marked as not found.
+//
+// Input format:
+//    {"urls": ["source1", "source2"]}
+func (a *API) handleMultiGet(c echo.Context) error {
+	collectionName := c.Param("collection")
+	proc, err := a.Manager.GetProcessor(collectionName)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleMultiGet GetProcessor err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	request := &MultiGetRequest{}
+	if err = c.Bind(request); err != nil {
+		log.Debug().Err(err).Msg("handleMultiGet Bind err")
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+	if err = c.Validate(request); err != nil {
+		log.Debug().Err(err).Msg("handleMultiGet Validate err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := a.requestContext(c)
+	defer cancel()
+
+	docs, err := proc.MultiGet(ctx, request.Urls)
+	if err != nil {
+		if httpErr := contextError(err); httpErr != nil {
+			return httpErr
+		}
+		log.Err(err).Msg("handleMultiGet MultiGet err")
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, &MultiGetResult{Docs: docs})
+}
+
+func (a *API) handleDeleteByQuery(c echo.Context) error {
+	collectionName := c.Param("collection")
+	proc, err := a.Manager.GetProcessor(collectionName)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleDeleteByQuery GetProcessor err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	query := collection.DeleteQuery{}
+	if err = c.Bind(&query); err != nil {
+		log.Debug().Err(err).Msg("handleDeleteByQuery Bind err")
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	ctx, cancel := a.writeContext(c)
+	defer cancel()
+
+	deleted, err := proc.DeleteByQuery(ctx, query)
+	res := &DeleteResult{Deleted: deleted}
+	if err != nil {
+		log.Debug().Err(err).Int("deleted", deleted).Msg("handleDeleteByQuery DeleteByQuery err")
+		if err == collection.ErrEmptyDeleteQuery || errors.Is(err, collection.ErrInvalidField) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		res.Error = err.Error()
+		return c.JSON(errorStatus(err), res)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+func (a *API) handleAnalyze(c echo.Context) error {
+	collectionName := c.Param("collection")
+	proc, err := a.Manager.GetProcessor(collectionName)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleAnalyze GetProcessor err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	request := &AnalyzeRequest{}
+	if err = c.Bind(request); err != nil {
+		log.Debug().Err(err).Msg("handleAnalyze Bind err")
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	if err = c.Validate(request); err != nil {
+		log.Debug().Err(err).Msg("handleAnalyze Validate err")
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	return c.JSON(http.StatusOK, proc.Analyze(request.Text))
+}
+
+func (a *API) handleGetSchema(c echo.Context) error {
+	collectionName := c.Param("collection")
+	proc, err := a.Manager.GetProcessor(collectionName)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleGetSchema GetProcessor err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := a.requestContext(c)
+	defer cancel()
+
+	schema, err := proc.Schema(ctx)
+	if err != nil {
+		log.Err(err).Msg("handleGetSchema Schema err")
+		return echo.NewHTTPError(errorStatus(err))
+	}
+	return c.JSON(http.StatusOK, schema)
+}
+
+// handleSetSchema replaces the schema of the collection, saved documents are not changed.
+//
+// Input format:
+//    {
+//      "strict": false,
+//      "fields": {
+//        "title":  {"type": "text", "boost": 3},
+//        "author": {"type": "text", "boost": 1.5},
+//        "lang":   {"type": "keyword"},
+//        "words":  {"type": "integer", "store": false},
+//        "cover":  {"type": "keyword", "index": false}
+//      }
+//    }
+func (a *API) handleSetSchema(c echo.Context) error {
+	collectionName := c.Param("collection")
+	proc, err := a.Manager.GetProcessor(collectionName)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleSetSchema GetProcessor err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	schema := collection.Schema{}
+	if err = c.Bind(&schema); err != nil {
+		log.Debug().Err(err).Msg("handleSetSchema Bind err")
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	ctx, cancel := a.writeContext(c)
+	defer cancel()
+
+	if err = proc.SetSchema(ctx, schema); err != nil {
+		log.Debug().Err(err).Msg("handleSetSchema SetSchema err")
+		if errors.Is(err, collection.ErrInvalidSchema) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(errorStatus(err))
+	}
+	return c.JSON(http.StatusOK, schema)
+}
+
+func (a *API) handleGetJob(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleGetJob ParseUint err")
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+
+	job, err := a.Queue.Get(id)
+	if err != nil {
+		if err == jobs.ErrJobNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		log.Err(err).Msg("handleGetJob Get err")
+		return echo.NewHTTPError(http.StatusInternalServerError)
+	}
+	return c.JSON(http.StatusOK, job)
 }
 
 // Run start the server.
@@ -190,6 +602,105 @@ func (a *API) Close() error {
 	return a.e.Close()
 }
 
+// requestContext returns the context for reading data limited by the configured timeout.
+func (a *API) requestContext(c echo.Context) (context.Context, context.CancelFunc) {
+	return a.withTimeout(c, a.timeout)
+}
+
+// writeContext returns the context for writing data limited by the configured write timeout.
+func (a *API) writeContext(c echo.Context) (context.Context, context.CancelFunc) {
+	return a.withTimeout(c, a.writeTimeout)
+}
+
+// withTimeout returns the context of the request limited by the timeout, a negative timeout means no limit.
+// The context is canceled if the client goes away or the application is shutting down.
+func (a *API) withTimeout(c echo.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	reqCtx, cancel := context.WithCancel(c.Request().Context())
+	if cc, ok := c.(*Context); ok && cc.Ctx != nil {
+		go func() {
+			select {
+			case <-cc.Ctx.Done():
+				cancel()
+			case <-reqCtx.Done():
+			}
+		}()
+	}
+	if timeout < 0 {
+		return reqCtx, cancel
+	}
+	ctx, cancelTimeout := context.WithTimeout(reqCtx, timeout)
+	return ctx, func() {
+		cancelTimeout()
+		cancel()
+	}
+}
+
+// contextError converts errors of a done context to http errors, returns nil for other errors.
+func contextError(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return echo.NewHTTPError(http.StatusGatewayTimeout, "request timeout exceeded")
+	case errors.Is(err, context.Canceled):
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "request canceled")
+	}
+	return nil
+}
+
+// rejectedStatus returns the http status of a rejected document.
+func rejectedStatus(err error) int {
+	switch {
+	case errors.Is(err, collection.ErrVersionConflict):
+		return http.StatusConflict
+	case errors.Is(err, collection.ErrDocumentNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// searchStatus returns the http status of the error of a structured search.
+func searchStatus(err error) int {
+	if errors.Is(err, collection.ErrInvalidQuery) || errors.Is(err, collection.ErrInvalidField) {
+		return http.StatusBadRequest
+	}
+	return errorStatus(err)
+}
+
+// errorStatus returns the http status of the error that stopped processing.
+func errorStatus(err error) int {
+	if httpErr, ok := contextError(err).(*echo.HTTPError); ok {
+		return httpErr.Code
+	}
+	return http.StatusInternalServerError
+}
+
+var errBatchFailed = errors.New("batch is not saved because of other documents")
+
+func newBatchResult(docs []collection.RawData) *BatchResult {
+	res := &BatchResult{Items: make([]ItemResult, len(docs))}
+	for i := range docs {
+		res.Items[i] = ItemResult{Index: i, Url: docs[i].Url}
+	}
+	return res
+}
+
+// set sets the result of the document.
+func (r *BatchResult) set(i, status int, err error) {
+	r.Items[i].Status = status
+	if err != nil {
+		r.Items[i].Error = err.Error()
+		r.Errors = true
+	}
+}
+
+// setRemaining sets the result of all documents without a result yet.
+func (r *BatchResult) setRemaining(status int, err error) {
+	for i := range r.Items {
+		if r.Items[i].Status == 0 {
+			r.set(i, status, err)
+		}
+	}
+}
+
 func ok(c echo.Context) error {
 	return c.JSON(http.StatusOK, http.StatusText(http.StatusOK))
 }
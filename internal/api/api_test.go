@@ -0,0 +1,360 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/polyse/database/internal/collection"
+	"github.com/polyse/database/internal/idempotency"
+	"github.com/polyse/database/internal/jobs"
+	"github.com/polyse/database/internal/storage"
+	"github.com/polyse/database/pkg/filters"
+	"github.com/stretchr/testify/suite"
+)
+
+type apiTestSuite struct {
+	suite.Suite
+	store storage.Storage
+	proc  collection.Processor
+	api   *API
+}
+
+func TestApiSuite(t *testing.T) {
+	suite.Run(t, new(apiTestSuite))
+}
+
+func (ats *apiTestSuite) SetupTest() {
+	ats.store = storage.NewMemory()
+	ats.proc = collection.NewSimpleProcessor(
+		ats.store,
+		collection.ProcessorConfig{},
+		"test",
+		filters.FilterText,
+		filters.StemmAndToLower,
+	)
+	var err error
+	ats.api, err = NewApp(context.Background(), AppConfig{BulkChunkSize: 2})
+	ats.Require().NoError(err)
+	ats.api.Manager = collection.NewManagerWithProc(ats.proc)
+	ats.api.Queue = jobs.NewQueue(ats.store, ats.api.Manager, 2)
+	ats.api.Idempotency = idempotency.NewStore(ats.store, time.Hour)
+}
+
+// blockingProcessor is a processor of the collection "slow" which blocks reads and writes until
+// the context is done.
+type blockingProcessor struct {
+	collection.Processor
+}
+
+func (p *blockingProcessor) GetCollectionName() string {
+	return "slow"
+}
+
+func (p *blockingProcessor) Search(ctx context.Context, _ collection.SearchQuery) ([]collection.ResponseData, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (p *blockingProcessor) SearchStructured(ctx context.Context, _ collection.StructuredQuery) (collection.SearchResult, error) {
+	<-ctx.Done()
+	return collection.SearchResult{}, ctx.Err()
+}
+
+func (p *blockingProcessor) ApplyActions(ctx context.Context, _ []collection.Action) ([]collection.ActionResult, error) {
+	<-ctx.Done()
+	return nil, &collection.ChunkError{Err: ctx.Err()}
+}
+
+func (p *blockingProcessor) List(ctx context.Context, _ string, _ int) (collection.DocumentPage, error) {
+	<-ctx.Done()
+	return collection.DocumentPage{}, ctx.Err()
+}
+
+// withBlockingProcessor adds the blocking processor and makes requests time out quickly.
+func (ats *apiTestSuite) withBlockingProcessor() {
+	ats.api.timeout = 10 * time.Millisecond
+	ats.api.writeTimeout = 10 * time.Millisecond
+	ats.api.Manager.AddProcessor(&blockingProcessor{})
+}
+
+// request serves the request with the body and returns the recorded response.
+func (ats *apiTestSuite) request(method, target, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	ats.api.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode decodes the json body of the response.
+func (ats *apiTestSuite) decode(rec *httptest.ResponseRecorder, v interface{}) {
+	ats.Require().NoError(json.NewDecoder(rec.Body).Decode(v), rec.Body.String())
+}
+
+// document returns the json of a valid document with the url.
+func document(url, title, data string) string {
+	return fmt.Sprintf(`{"url":%q,"source":{"date":"2020-05-12T00:00:00Z","title":%q},"data":%q}`, url, title, data)
+}
+
+// addDocuments saves the documents through the api.
+func (ats *apiTestSuite) addDocuments(docs ...string) {
+	rec := ats.request(http.MethodPost, "/api/test/documents", `{"documents":[`+strings.Join(docs, ",")+`]}`)
+	ats.Require().Equal(http.StatusCreated, rec.Code, rec.Body.String())
+}
+
+func (ats *apiTestSuite) TestAddDocumentsAsync() {
+	rec := ats.request(
+		http.MethodPost,
+		"/api/test/documents?async=true",
+		`{"documents":[`+document("http://example.com/1", "first", "golang")+`]}`,
+	)
+	ats.Equal(http.StatusAccepted, rec.Code, rec.Body.String())
+	var job jobs.Job
+	ats.decode(rec, &job)
+	ats.Equal(jobs.StatusQueued, job.Status)
+	ats.Equal(1, job.Total)
+
+	rec = ats.request(http.MethodGet, fmt.Sprintf("/api/jobs/%d", job.ID), "")
+	ats.Equal(http.StatusOK, rec.Code)
+	var got jobs.Job
+	ats.decode(rec, &got)
+	ats.Equal(job.ID, got.ID)
+	ats.Equal("test", got.Collection)
+}
+
+func (ats *apiTestSuite) TestAddDocumentsAsyncInvalid() {
+	rec := ats.request(
+		http.MethodPost,
+		"/api/test/documents?async=true&best_effort=true",
+		`{"documents":[`+document("http://example.com/1", "first", "golang")+`,{"url":"bad"}]}`,
+	)
+	ats.Equal(http.StatusBadRequest, rec.Code)
+	var res BatchResult
+	ats.decode(rec, &res)
+	ats.True(res.Errors)
+	ats.Equal(http.StatusFailedDependency, res.Items[0].Status)
+	ats.Equal(http.StatusBadRequest, res.Items[1].Status)
+}
+
+func (ats *apiTestSuite) TestGetJob() {
+	ats.Equal(http.StatusNotFound, ats.request(http.MethodGet, "/api/jobs/42", "").Code)
+	ats.Equal(http.StatusBadRequest, ats.request(http.MethodGet, "/api/jobs/abc", "").Code)
+}
+
+func (ats *apiTestSuite) TestSearchTimeout() {
+	ats.withBlockingProcessor()
+	rec := ats.request(http.MethodGet, "/api/slow/documents?q=golang", "")
+	ats.Equal(http.StatusGatewayTimeout, rec.Code)
+}
+
+func (ats *apiTestSuite) TestUnknownCollection() {
+	ats.Equal(http.StatusBadRequest, ats.request(http.MethodGet, "/api/unknown/documents?q=golang", "").Code)
+	ats.Equal(http.StatusBadRequest, ats.request(http.MethodPost, "/api/unknown/_bulk", "").Code)
+}
+
+func (ats *apiTestSuite) TestDeleteByQuery() {
+	ats.addDocuments(
+		document("http://example.com/1", "first", "golang"),
+		document("http://example.com/2", "second", "golang"),
+		document("http://other.com/1", "third", "golang"),
+	)
+	rec := ats.request(http.MethodPost, "/api/test/_delete_by_query", `{"url_prefix":"http://example.com/"}`)
+	ats.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	var res DeleteResult
+	ats.decode(rec, &res)
+	ats.Equal(2, res.Deleted)
+	ats.Empty(res.Error)
+
+	rec = ats.request(http.MethodGet, "/api/test/documents/_doc?url=http://example.com/1", "")
+	ats.Equal(http.StatusNotFound, rec.Code)
+	rec = ats.request(http.MethodGet, "/api/test/documents/_doc?url=http://other.com/1", "")
+	ats.Equal(http.StatusOK, rec.Code)
+}
+
+func (ats *apiTestSuite) TestDeleteByQueryInvalid() {
+	ats.Equal(http.StatusBadRequest, ats.request(http.MethodPost, "/api/test/_delete_by_query", `{}`).Code)
+	ats.Equal(http.StatusBadRequest, ats.request(http.MethodPost, "/api/test/_delete_by_query", `{"query":`).Code)
+}
+
+func (ats *apiTestSuite) TestSchema() {
+	rec := ats.request(http.MethodGet, "/api/test/_schema", "")
+	ats.Equal(http.StatusOK, rec.Code)
+	var schema collection.Schema
+	ats.decode(rec, &schema)
+	ats.Empty(schema.Fields)
+
+	rec = ats.request(
+		http.MethodPut,
+		"/api/test/_schema",
+		`{"strict":true,"fields":{"lang":{"type":"keyword"},"words":{"type":"integer"}},"retention":"24h"}`,
+	)
+	ats.Equal(http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = ats.request(http.MethodGet, "/api/test/_schema", "")
+	ats.Equal(http.StatusOK, rec.Code)
+	ats.decode(rec, &schema)
+	ats.True(schema.Strict)
+	ats.Equal("24h", schema.Retention)
+	ats.Equal(collection.TypeKeyword, schema.Fields["lang"].Type)
+	ats.Equal(collection.TypeInteger, schema.Fields["words"].Type)
+}
+
+func (ats *apiTestSuite) TestSetSchemaInvalid() {
+	for _, body := range []string{
+		`{"fields":{"lang":{"type":"unknown"}}}`,
+		`{"retention":"-1h"}`,
+		`{"fields":`,
+	} {
+		rec := ats.request(http.MethodPut, "/api/test/_schema", body)
+		ats.Equal(http.StatusBadRequest, rec.Code, body)
+	}
+}
+
+func (ats *apiTestSuite) TestSchemaRejectsDocuments() {
+	rec := ats.request(http.MethodPut, "/api/test/_schema", `{"strict":true,"fields":{"lang":{"type":"keyword"}}}`)
+	ats.Equal(http.StatusOK, rec.Code)
+
+	doc := `{"url":"http://example.com/1","source":{"date":"2020-05-12T00:00:00Z","title":"first",` +
+		`"fields":{"author":"pike"}},"data":"golang"}`
+	rec = ats.request(http.MethodPost, "/api/test/documents", `{"documents":[`+doc+`]}`)
+	ats.Equal(http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+	var res BatchResult
+	ats.decode(rec, &res)
+	ats.True(res.Errors)
+	ats.Equal(http.StatusUnprocessableEntity, res.Items[0].Status)
+}
+
+func (ats *apiTestSuite) TestSearchByFilters() {
+	rec := ats.request(http.MethodPut, "/api/test/_schema", `{"fields":{"lang":{"type":"keyword"}}}`)
+	ats.Equal(http.StatusOK, rec.Code)
+	ats.addDocuments(
+		`{"url":"http://example.com/1","source":{"date":"2020-05-12T00:00:00Z","title":"first",`+
+			`"fields":{"lang":"en"}},"data":"golang"}`,
+		`{"url":"http://example.com/2","source":{"date":"2020-06-12T00:00:00Z","title":"second",`+
+			`"fields":{"lang":"en"}},"data":"rust"}`,
+		`{"url":"http://example.com/3","source":{"date":"2020-07-12T00:00:00Z","title":"third",`+
+			`"fields":{"lang":"ru"}},"data":"golang"}`,
+	)
+
+	rec = ats.request(http.MethodGet, "/api/test/documents?filter=lang:en", "")
+	ats.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	var docs []collection.ResponseData
+	ats.decode(rec, &docs)
+	ats.Require().Len(docs, 2)
+	ats.Equal("http://example.com/2", docs[0].Url)
+	ats.Equal("http://example.com/1", docs[1].Url)
+
+	rec = ats.request(http.MethodGet, "/api/test/documents?q=golang&filter=lang:en", "")
+	ats.Equal(http.StatusOK, rec.Code)
+	ats.decode(rec, &docs)
+	ats.Require().Len(docs, 1)
+	ats.Equal("http://example.com/1", docs[0].Url)
+}
+
+func (ats *apiTestSuite) TestSearchInvalid() {
+	ats.Equal(http.StatusBadRequest, ats.request(http.MethodGet, "/api/test/documents", "").Code)
+	ats.Equal(http.StatusBadRequest, ats.request(http.MethodGet, "/api/test/documents?filter=lang", "").Code)
+	ats.Equal(http.StatusBadRequest, ats.request(http.MethodGet, "/api/test/documents?filter=title:first", "").Code)
+}
+
+func (ats *apiTestSuite) TestStructuredSearch() {
+	ats.addDocuments(
+		document("http://example.com/1", "first", "golang generics"),
+		document("http://example.com/2", "second", "golang"),
+		document("http://other.com/1", "third", "rust"),
+	)
+	rec := ats.request(
+		http.MethodPost,
+		"/api/test/_search",
+		`{"must":[{"match":{"query":"golang"}}],"should":[{"match":{"query":"generics first"}}],`+
+			`"highlight":{"fields":["title"]}}`,
+	)
+	ats.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	var res collection.SearchResult
+	ats.decode(rec, &res)
+	ats.Equal(2, res.Total)
+	ats.Require().Len(res.Hits, 2)
+	ats.Equal("http://example.com/1", res.Hits[0].Url)
+	ats.Equal("http://example.com/2", res.Hits[1].Url)
+	ats.Greater(res.Hits[0].Score, res.Hits[1].Score)
+	ats.Equal([]string{"<em>first</em>"}, res.Hits[0].Highlight["title"])
+}
+
+func (ats *apiTestSuite) TestStructuredSearchInvalid() {
+	for _, body := range []string{
+		`{}`,
+		`{"must":[{}]}`,
+		`{"must":[{"match":{"query":"golang"},"term":{"field":"lang","value":"en"}}]}`,
+		`{"must":[{"match":{}}]}`,
+		`{"must":[{"match":{"query":"golang"}}],"limit":-1}`,
+		`{"must":[{"match":{"query":"golang"}}],"sort":[{"field":"title"}]}`,
+		`{"must":`,
+	} {
+		rec := ats.request(http.MethodPost, "/api/test/_search", body)
+		ats.Equal(http.StatusBadRequest, rec.Code, body)
+	}
+}
+
+func (ats *apiTestSuite) TestStructuredSearchTimeout() {
+	ats.withBlockingProcessor()
+	rec := ats.request(http.MethodPost, "/api/slow/_search", `{"must":[{"match":{"query":"golang"}}]}`)
+	ats.Equal(http.StatusGatewayTimeout, rec.Code)
+}
+
+func (ats *apiTestSuite) TestMultiGet() {
+	ats.addDocuments(
+		document("http://example.com/1", "first", "golang"),
+		document("http://example.com/2", "second", "rust"),
+	)
+	rec := ats.request(
+		http.MethodPost,
+		"/api/test/_mget",
+		`{"urls":["http://example.com/2","http://example.com/3","http://example.com/1"]}`,
+	)
+	ats.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	var res MultiGetResult
+	ats.decode(rec, &res)
+	ats.Require().Len(res.Docs, 3)
+	ats.Equal("http://example.com/2", res.Docs[0].Url)
+	ats.True(res.Docs[0].Found)
+	ats.Equal("second", res.Docs[0].Title)
+	ats.Equal("http://example.com/3", res.Docs[1].Url)
+	ats.False(res.Docs[1].Found)
+	ats.Nil(res.Docs[1].Source)
+	ats.Equal("http://example.com/1", res.Docs[2].Url)
+	ats.True(res.Docs[2].Found)
+}
+
+func (ats *apiTestSuite) TestMultiGetInvalid() {
+	tooMany := `{"urls":["http://example.com/1"` + strings.Repeat(`,"http://example.com/1"`, 1000) + `]}`
+	for _, body := range []string{`{}`, `{"urls":[]}`, `{"urls":[""]}`, `{"urls":`, tooMany} {
+		rec := ats.request(http.MethodPost, "/api/test/_mget", body)
+		ats.Equal(http.StatusBadRequest, rec.Code, fmt.Sprintf("%.20s", body))
+	}
+}
+
+func (ats *apiTestSuite) TestAnalyze() {
+	rec := ats.request(http.MethodPost, "/api/test/_analyze", `{"text":"Running the tests"}`)
+	ats.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	var res filters.Analysis
+	ats.decode(rec, &res)
+	ats.Equal([]string{"tokenizer", "StemmAndToLower"}, res.Stages)
+	ats.Require().Len(res.Tokens, 3)
+	ats.Equal(filters.Token{Position: 0, Forms: []string{"Running", "run"}, IndexPosition: 0}, res.Tokens[0])
+	ats.Equal(filters.Token{Position: 2, Forms: []string{"tests", "test"}, IndexPosition: 2}, res.Tokens[2])
+}
+
+func (ats *apiTestSuite) TestAnalyzeInvalid() {
+	ats.Equal(http.StatusBadRequest, ats.request(http.MethodPost, "/api/test/_analyze", `{}`).Code)
+	ats.Equal(http.StatusBadRequest, ats.request(http.MethodPost, "/api/test/_analyze", `{"text":`).Code)
+	ats.Equal(http.StatusBadRequest, ats.request(http.MethodPost, "/api/unknown/_analyze", `{"text":"go"}`).Code)
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/polyse/database/internal/collection"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	errMalformedAction = errors.New("action line must be an object with one of index, delete or update_fields")
+	errMissingDocument = errors.New("document line is missing")
+)
+
+// ActionMeta is the action line of a bulk request.
+type ActionMeta struct {
+	Url       string  `json:"url"`
+	IfVersion *uint64 `json:"if_version"`
+}
+
+// FieldsUpdate is the document line of an update_fields action.
+type FieldsUpdate struct {
+	Fields collection.Fields `json:"fields" validate:"required"`
+}
+
+// BulkResult is the response to a bulk request, it contains a result for every action in the order
+// of the request. Errors is set if any action failed.
+type BulkResult struct {
+	Errors bool         `json:"errors"`
+	Items  []ItemResult `json:"items"`
+}
+
+// add adds the result of the next action of the request and returns its index.
+func (r *BulkResult) add(action collection.ActionType, url string) int {
+	r.Items = append(r.Items, ItemResult{Index: len(r.Items), Action: string(action), Url: url, Status: http.StatusOK})
+	return len(r.Items) - 1
+}
+
+// fail marks the action as failed.
+func (r *BulkResult) fail(i int, url string, status int, err error) {
+	r.Errors = true
+	r.Items[i].Url, r.Items[i].Status, r.Items[i].Error = url, status, err.Error()
+}
+
+// handleBulk reads newline delimited json from the request body as a stream and applies it in order,
+// chunk by chunk. Every chunk is applied in its own transaction within the write timeout.
+// A line is either a document to index or an action line: an object with the single key index, delete
+// or update_fields. Every action line is followed by the document line, except for delete. The document
+// of update_fields has the fields to replace, a null value removes the field. The url and if_version
+// of the action line are used for index actions if the document has none.
+// Invalid documents and actions are skipped, reading stops at a malformed action line or if a chunk
+// can not be applied. The response has the result of every action read, with the version of the saved
+// or deleted document or the error.
+//
+// Input format:
+//    {"url": "source1", "source": {"date": "2020-05-12T00:00:00Z", "title": "test title"}, "data": "data1 data2"}
+//    {"index": {"url": "source2", "if_version": 1}}
+//    {"source": {"date": "2020-06-11T00:00:00Z", "title": "test second title"}, "data": "data2"}
+//    {"update_fields": {"url": "source3", "if_version": 3}}
+//    {"fields": {"lang": "en", "draft": null}}
+//    {"delete": {"url": "source4"}}
+func (a *API) handleBulk(c echo.Context) error {
+	collectionName := c.Param("collection")
+
+	log.Debug().
+		Str("collection", collectionName).
+		Msg("bulk applying actions")
+
+	proc, err := a.Manager.GetProcessor(collectionName)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleBulk GetProcessor err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	res := &BulkResult{Items: []ItemResult{}}
+	r := bufio.NewReader(c.Request().Body)
+	actions := make([]collection.Action, 0, a.bulkChunkSize)
+	indexes := make([]int, 0, a.bulkChunkSize)
+	// pending is the index of the action waiting for its document line, or -1.
+	pending := -1
+	var action collection.Action
+	for {
+		line, readErr := r.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			log.Debug().Err(readErr).Msg("handleBulk read err")
+			return c.JSON(http.StatusBadRequest, res)
+		}
+		status := http.StatusOK
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if pending >= 0 {
+				if err = a.parseDocumentLine(c, line, &action); err != nil {
+					log.Debug().Err(err).Int("index", pending).Msg("handleBulk document err")
+					res.fail(pending, action.Url, http.StatusBadRequest, err)
+				} else {
+					actions, indexes = append(actions, action), append(indexes, pending)
+				}
+				pending = -1
+			} else if isActionLine(line) {
+				action, err = parseActionLine(line)
+				i := res.add(action.Type, action.Url)
+				if err != nil {
+					log.Debug().Err(err).Int("index", i).Msg("handleBulk action err")
+					res.fail(i, "", http.StatusBadRequest, err)
+					// The rest of the stream can not be split into actions, actions read so far are applied.
+					status, readErr = http.StatusBadRequest, io.EOF
+				} else if action.Type != collection.ActionDelete {
+					pending = i
+				} else if action.Url == "" {
+					res.fail(i, "", http.StatusBadRequest, collection.ErrEmptyURL)
+				} else {
+					actions, indexes = append(actions, action), append(indexes, i)
+				}
+			} else {
+				action = collection.Action{Type: collection.ActionIndex}
+				i := res.add(action.Type, "")
+				if err = a.parseDocumentLine(c, line, &action); err != nil {
+					log.Debug().Err(err).Int("index", i).Msg("handleBulk document err")
+					res.fail(i, action.Url, http.StatusBadRequest, err)
+				} else {
+					actions, indexes = append(actions, action), append(indexes, i)
+				}
+			}
+		}
+		if readErr == io.EOF && pending >= 0 {
+			res.fail(pending, action.Url, http.StatusBadRequest, errMissingDocument)
+			pending = -1
+		}
+		if len(actions) == a.bulkChunkSize || (readErr == io.EOF && len(actions) > 0) {
+			if status, err := a.applyBulkChunk(c, proc, actions, indexes, res); err != nil {
+				log.Debug().Err(err).Msg("handleBulk applying err")
+				return c.JSON(status, res)
+			}
+			actions, indexes = actions[:0], indexes[:0]
+		}
+		if readErr == io.EOF {
+			return c.JSON(status, res)
+		}
+	}
+}
+
+// documentKeys are keys of document lines, see collection.RawData.
+var documentKeys = map[string]struct{}{"url": {}, "source": {}, "data": {}, "if_version": {}, "ttl": {}}
+
+// isActionLine reports whether the line is an action line rather than a document.
+// Valid documents always have several keys, so an object with a single key which is not a key
+// of documents is an action line.
+func isActionLine(line []byte) bool {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(line, &obj) != nil || len(obj) != 1 {
+		return false
+	}
+	for key := range obj {
+		if _, ok := documentKeys[key]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parseActionLine parses the action line into the action without its document.
+func parseActionLine(line []byte) (collection.Action, error) {
+	var meta map[collection.ActionType]ActionMeta
+	if err := json.Unmarshal(line, &meta); err != nil || len(meta) != 1 {
+		return collection.Action{}, errMalformedAction
+	}
+	for t, m := range meta {
+		switch t {
+		case collection.ActionIndex, collection.ActionDelete, collection.ActionUpdateFields:
+			return collection.Action{Type: t, Url: m.Url, IfVersion: m.IfVersion}, nil
+		}
+	}
+	return collection.Action{}, errMalformedAction
+}
+
+// parseDocumentLine parses and validates the document line of the index or update_fields action.
+func (a *API) parseDocumentLine(c echo.Context, line []byte, action *collection.Action) error {
+	if action.Type == collection.ActionUpdateFields {
+		var upd FieldsUpdate
+		if err := json.Unmarshal(line, &upd); err != nil {
+			return err
+		}
+		if err := c.Validate(&upd); err != nil {
+			return err
+		}
+		if action.Url == "" {
+			return collection.ErrEmptyURL
+		}
+		action.Fields = upd.Fields
+		return nil
+	}
+	var doc collection.RawData
+	if err := json.Unmarshal(line, &doc); err != nil {
+		return err
+	}
+	if doc.Url == "" {
+		doc.Url = action.Url
+	}
+	if doc.IfVersion == nil {
+		doc.IfVersion = action.IfVersion
+	}
+	action.Url = doc.Url
+	if err := c.Validate(&doc); err != nil {
+		return fmt.Errorf("document %q: %w", doc.Url, err)
+	}
+	action.IfVersion, action.Document = doc.IfVersion, doc
+	return nil
+}
+
+// applyBulkChunk applies the actions and sets their results, indexes are indexes of the actions in the request.
+// If processing stops, the http status of the error is returned with it.
+func (a *API) applyBulkChunk(
+	c echo.Context,
+	proc collection.Processor,
+	actions []collection.Action,
+	indexes []int,
+	res *BulkResult,
+) (int, error) {
+	ctx, cancel := a.writeContext(c)
+	defer cancel()
+
+	results, err := proc.ApplyActions(ctx, actions)
+	if chunkErr, ok := err.(*collection.ChunkError); ok {
+		err = chunkErr.Err
+	}
+	for i, r := range results {
+		if r.Err != nil {
+			res.fail(indexes[i], r.Url, rejectedStatus(r.Err), r.Err)
+			continue
+		}
+		res.Items[indexes[i]].Url, res.Items[indexes[i]].Version = r.Url, r.Version
+	}
+	if err != nil {
+		status := errorStatus(err)
+		for i := len(results); i < len(actions); i++ {
+			res.fail(indexes[i], actions[i].Url, status, err)
+		}
+		return status, err
+	}
+	return 0, nil
+}
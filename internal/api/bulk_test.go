@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// ndjson joins the lines into a newline delimited json body.
+func ndjson(lines ...string) string {
+	var b bytes.Buffer
+	for _, l := range lines {
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (ats *apiTestSuite) TestBulk() {
+	ats.addDocuments(
+		document("http://example.com/3", "third", "golang"),
+		document("http://example.com/4", "fourth", "golang"),
+	)
+	rec := ats.request(http.MethodPost, "/api/test/_bulk", ndjson(
+		document("http://example.com/1", "first", "golang"),
+		`{"index": {"url": "http://example.com/2"}}`,
+		`{"source": {"date": "2020-06-11T00:00:00Z", "title": "second"}, "data": "rust"}`,
+		`{"update_fields": {"url": "http://example.com/3", "if_version": 1}}`,
+		`{"fields": {"lang": "en"}}`,
+		`{"delete": {"url": "http://example.com/4"}}`,
+	))
+	ats.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	var res BulkResult
+	ats.decode(rec, &res)
+	ats.Equal(BulkResult{Items: []ItemResult{
+		{Index: 0, Action: "index", Url: "http://example.com/1", Status: http.StatusOK, Version: 1},
+		{Index: 1, Action: "index", Url: "http://example.com/2", Status: http.StatusOK, Version: 1},
+		{Index: 2, Action: "update_fields", Url: "http://example.com/3", Status: http.StatusOK, Version: 2},
+		{Index: 3, Action: "delete", Url: "http://example.com/4", Status: http.StatusOK, Version: 1},
+	}}, res)
+
+	rec = ats.request(http.MethodGet, "/api/test/documents/_doc?url=http://example.com/2", "")
+	ats.Equal(http.StatusOK, rec.Code)
+	rec = ats.request(http.MethodGet, "/api/test/documents/_doc?url=http://example.com/3", "")
+	ats.Equal(http.StatusOK, rec.Code)
+	ats.Contains(rec.Body.String(), `"lang":"en"`)
+	rec = ats.request(http.MethodGet, "/api/test/documents/_doc?url=http://example.com/4", "")
+	ats.Equal(http.StatusNotFound, rec.Code)
+}
+
+func (ats *apiTestSuite) TestBulkItemErrors() {
+	ats.addDocuments(document("http://example.com/1", "first", "golang"))
+	rec := ats.request(http.MethodPost, "/api/test/_bulk", ndjson(
+		`{"url": "bad"}`,
+		`{"delete": {"url": "http://example.com/2"}}`,
+		`{"index": {"url": "http://example.com/1", "if_version": 5}}`,
+		`{"source": {"date": "2020-06-11T00:00:00Z", "title": "first"}, "data": "rust"}`,
+		`{"delete": {}}`,
+		`{"update_fields": {"url": "http://example.com/1"}}`,
+		`{}`,
+		document("http://example.com/3", "third", "golang"),
+		`{"update_fields": {"url": "http://example.com/1"}}`,
+	))
+	ats.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	var res BulkResult
+	ats.decode(rec, &res)
+	ats.True(res.Errors)
+
+	type item struct {
+		index   int
+		action  string
+		status  int
+		version uint64
+	}
+	var items []item
+	for i, r := range res.Items {
+		ats.Equal(i, r.Index)
+		ats.Equal(r.Status != http.StatusOK, r.Error != "", r)
+		items = append(items, item{r.Index, r.Action, r.Status, r.Version})
+	}
+	ats.Equal([]item{
+		{0, "index", http.StatusBadRequest, 0},
+		{1, "delete", http.StatusNotFound, 0},
+		{2, "index", http.StatusConflict, 0},
+		{3, "delete", http.StatusBadRequest, 0},
+		{4, "update_fields", http.StatusBadRequest, 0},
+		{5, "index", http.StatusOK, 1},
+		{6, "update_fields", http.StatusBadRequest, 0},
+	}, items)
+}
+
+func (ats *apiTestSuite) TestBulkMalformedAction() {
+	rec := ats.request(http.MethodPost, "/api/test/_bulk", ndjson(
+		document("http://example.com/1", "first", "golang"),
+		`{"upsert": {"url": "http://example.com/2"}}`,
+		document("http://example.com/3", "third", "golang"),
+	))
+	ats.Equal(http.StatusBadRequest, rec.Code)
+	var res BulkResult
+	ats.decode(rec, &res)
+	ats.True(res.Errors)
+	ats.Require().Len(res.Items, 2)
+	ats.Equal(http.StatusOK, res.Items[0].Status)
+	ats.Equal(http.StatusBadRequest, res.Items[1].Status)
+	ats.Equal(errMalformedAction.Error(), res.Items[1].Error)
+
+	// Documents read before the malformed line are saved, the rest are not.
+	rec = ats.request(http.MethodGet, "/api/test/documents/_doc?url=http://example.com/1", "")
+	ats.Equal(http.StatusOK, rec.Code)
+	rec = ats.request(http.MethodGet, "/api/test/documents/_doc?url=http://example.com/3", "")
+	ats.Equal(http.StatusNotFound, rec.Code)
+}
+
+func (ats *apiTestSuite) TestBulkTimeout() {
+	ats.withBlockingProcessor()
+	rec := ats.request(http.MethodPost, "/api/slow/_bulk", ndjson(
+		document("http://example.com/1", "first", "golang"),
+		document("http://example.com/2", "second", "golang"),
+		document("http://example.com/3", "third", "golang"),
+	))
+	ats.Equal(http.StatusGatewayTimeout, rec.Code)
+	var res BulkResult
+	ats.decode(rec, &res)
+	// Reading stops at the first chunk which can not be applied.
+	ats.True(res.Errors)
+	ats.Require().Len(res.Items, 2)
+	for _, r := range res.Items {
+		ats.Equal(http.StatusGatewayTimeout, r.Status)
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/polyse/database/internal/idempotency"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// idempotencyKeyHeader is the header with the client-supplied request key.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader is set on responses replayed from the record.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLen limits the length of the key.
+	maxIdempotencyKeyLen = 255
+)
+
+// responseRecorder keeps a copy of the response body.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotent makes the handler idempotent for requests with the Idempotency-Key header.
+// The response of the first request with a key is recorded and replayed on retries with the same key,
+// unless the handler fails with an error or a server error status, then the request can be retried.
+// The key is scoped by collection and may not be reused with another query or body.
+func (a *API) idempotent(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(idempotencyKeyHeader)
+		if key == "" || a.Idempotency == nil {
+			return next(c)
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			return echo.NewHTTPError(http.StatusBadRequest, "idempotency key is too long")
+		}
+
+		body, err := ioutil.ReadAll(c.Request().Body)
+		if err != nil {
+			log.Debug().Err(err).Msg("idempotent read err")
+			return echo.NewHTTPError(http.StatusBadRequest)
+		}
+		c.Request().Body = ioutil.NopCloser(bytes.NewReader(body))
+		h := sha256.New()
+		h.Write([]byte(c.Request().URL.RawQuery))
+		h.Write([]byte{0})
+		h.Write(body)
+		fingerprint := h.Sum(nil)
+
+		key = c.Param("collection") + "/" + key
+		recorded, err := a.Idempotency.Begin(key, fingerprint)
+		switch err {
+		case nil:
+		case idempotency.ErrInProgress:
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		case idempotency.ErrMismatch:
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		default:
+			log.Err(err).Msg("idempotent Begin err")
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		if recorded != nil {
+			log.Debug().Str("key", key).Msg("replaying recorded response")
+			c.Response().Header().Set(idempotentReplayedHeader, "true")
+			return c.JSONBlob(recorded.Status, recorded.Body)
+		}
+
+		rec := &responseRecorder{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = rec
+		err = next(c)
+
+		var r *idempotency.Response
+		if err == nil && c.Response().Status < http.StatusInternalServerError {
+			r = &idempotency.Response{
+				Fingerprint: fingerprint,
+				Status:      c.Response().Status,
+				Body:        rec.body.Bytes(),
+			}
+		}
+		if endErr := a.Idempotency.End(key, r); endErr != nil {
+			log.Err(endErr).Str("key", key).Msg("can not record response")
+		}
+		return err
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+func (ats *apiTestSuite) TestIdempotentReplay() {
+	body := `{"documents":[` + document("http://example.com/1", "first", "golang") + `]}`
+	first := ats.request(http.MethodPost, "/api/test/documents", body, idempotencyKeyHeader, "key1")
+	ats.Equal(http.StatusCreated, first.Code, first.Body.String())
+	ats.Empty(first.Header().Get(idempotentReplayedHeader))
+
+	replayed := ats.request(http.MethodPost, "/api/test/documents", body, idempotencyKeyHeader, "key1")
+	ats.Equal(http.StatusCreated, replayed.Code)
+	ats.Equal("true", replayed.Header().Get(idempotentReplayedHeader))
+	ats.JSONEq(first.Body.String(), replayed.Body.String())
+
+	// The document is saved once, so its version is not increased by the retry.
+	rec := ats.request(http.MethodGet, "/api/test/documents/_doc?url=http://example.com/1", "")
+	ats.Equal(http.StatusOK, rec.Code)
+	ats.Contains(rec.Body.String(), `"version":1`)
+}
+
+func (ats *apiTestSuite) TestIdempotentMismatch() {
+	body := `{"documents":[` + document("http://example.com/1", "first", "golang") + `]}`
+	rec := ats.request(http.MethodPost, "/api/test/documents", body, idempotencyKeyHeader, "key1")
+	ats.Equal(http.StatusCreated, rec.Code)
+
+	other := `{"documents":[` + document("http://example.com/2", "second", "golang") + `]}`
+	rec = ats.request(http.MethodPost, "/api/test/documents", other, idempotencyKeyHeader, "key1")
+	ats.Equal(http.StatusUnprocessableEntity, rec.Code)
+
+	// Another query with the same body is another request too.
+	rec = ats.request(http.MethodPost, "/api/test/documents?best_effort=true", body, idempotencyKeyHeader, "key1")
+	ats.Equal(http.StatusUnprocessableEntity, rec.Code)
+}
+
+func (ats *apiTestSuite) TestIdempotentNotRecordedOnError() {
+	body := `{"documents":[` + document("http://example.com/1", "first", "golang") + `]}`
+	rec := ats.request(http.MethodPost, "/api/test/documents?best_effort=maybe", body, idempotencyKeyHeader, "key1")
+	ats.Equal(http.StatusBadRequest, rec.Code)
+
+	// The failed request is not recorded, so the key can be used again.
+	rec = ats.request(http.MethodPost, "/api/test/documents", body, idempotencyKeyHeader, "key1")
+	ats.Equal(http.StatusCreated, rec.Code)
+	ats.Empty(rec.Header().Get(idempotentReplayedHeader))
+}
+
+func (ats *apiTestSuite) TestIdempotentKeyTooLong() {
+	body := `{"documents":[` + document("http://example.com/1", "first", "golang") + `]}`
+	key := strings.Repeat("k", maxIdempotencyKeyLen+1)
+	rec := ats.request(http.MethodPost, "/api/test/documents", body, idempotencyKeyHeader, key)
+	ats.Equal(http.StatusBadRequest, rec.Code)
+}
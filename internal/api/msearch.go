@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo"
+	"github.com/polyse/database/internal/collection"
+	"github.com/rs/zerolog/log"
+)
+
+// maxMultiSearchQueries limits the number of queries of a multi-search request.
+const maxMultiSearchQueries = 100
+
+// MultiSearchQuery is a structured query to the collection, see collection.StructuredQuery.
+type MultiSearchQuery struct {
+	Collection string                     `json:"collection" validate:"required"`
+	Query      collection.StructuredQuery `json:"query"`
+}
+
+// MultiSearchResult is the result of a query of a multi-search request: found documents or the error.
+type MultiSearchResult struct {
+	Status int                      `json:"status"`
+	Error  string                   `json:"error,omitempty"`
+	Result *collection.SearchResult `json:"result,omitempty"`
+}
+
+// MultiSearchResponse is the response to a multi-search request, it contains a result for every query in order.
+type MultiSearchResponse struct {
+	Errors    bool                `json:"errors"`
+	Responses []MultiSearchResult `json:"responses"`
+}
+
+// handleMultiSearch runs structured queries to several collections concurrently within the request timeout.
+// A failed query does not fail the others, its error is returned in its place.
+//
+// Input format:
+//    [
+//      {"collection": "articles", "query": {"must": [{"match": {"query": "golang"}}]}},
+//      {"collection": "news", "query": {"filter": [{"term": {"field": "lang", "value": "en"}}], "limit": 3}}
+//    ]
+func (a *API) handleMultiSearch(c echo.Context) error {
+	var queries []MultiSearchQuery
+	if err := c.Bind(&queries); err != nil {
+		log.Debug().Err(err).Msg("handleMultiSearch Bind err")
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+	if len(queries) == 0 || len(queries) > maxMultiSearchQueries {
+		return echo.NewHTTPError(
+			http.StatusBadRequest,
+			fmt.Sprintf("number of queries must be from 1 to %d", maxMultiSearchQueries),
+		)
+	}
+
+	log.Debug().
+		Int("queries", len(queries)).
+		Msg("handleMultiSearch run")
+
+	ctx, cancel := a.requestContext(c)
+	defer cancel()
+
+	res := &MultiSearchResponse{Responses: make([]MultiSearchResult, len(queries))}
+	var wg sync.WaitGroup
+	for i := range queries {
+		if err := c.Validate(&queries[i]); err != nil {
+			log.Debug().Err(err).Int("index", i).Msg("handleMultiSearch Validate err")
+			res.Responses[i] = MultiSearchResult{Status: http.StatusBadRequest, Error: err.Error()}
+			continue
+		}
+		proc, err := a.Manager.GetProcessor(queries[i].Collection)
+		if err != nil {
+			log.Debug().Err(err).Int("index", i).Msg("handleMultiSearch GetProcessor err")
+			res.Responses[i] = MultiSearchResult{Status: http.StatusBadRequest, Error: err.Error()}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, proc collection.Processor) {
+			defer wg.Done()
+			r, err := proc.SearchStructured(ctx, queries[i].Query)
+			if err != nil {
+				log.Debug().Err(err).Int("index", i).Msg("handleMultiSearch SearchStructured err")
+				res.Responses[i] = MultiSearchResult{Status: searchStatus(err), Error: err.Error()}
+				return
+			}
+			res.Responses[i] = MultiSearchResult{Status: http.StatusOK, Result: &r}
+		}(i, proc)
+	}
+	wg.Wait()
+
+	for _, r := range res.Responses {
+		res.Errors = res.Errors || r.Status != http.StatusOK
+	}
+	return c.JSON(http.StatusOK, res)
+}
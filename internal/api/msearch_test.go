@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func (ats *apiTestSuite) TestMultiSearch() {
+	ats.addDocuments(
+		document("http://example.com/1", "first", "golang"),
+		document("http://example.com/2", "second", "rust"),
+	)
+	rec := ats.request(http.MethodPost, "/api/_msearch", `[
+		{"collection": "test", "query": {"must": [{"match": {"query": "golang"}}]}},
+		{"collection": "unknown", "query": {"must": [{"match": {"query": "golang"}}]}},
+		{"query": {"must": [{"match": {"query": "golang"}}]}},
+		{"collection": "test", "query": {"must": [{}]}},
+		{"collection": "test", "query": {"should": [{"match": {"query": "golang rust"}}], "limit": 1}}
+	]`)
+	ats.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	var res MultiSearchResponse
+	ats.decode(rec, &res)
+	ats.True(res.Errors)
+	ats.Require().Len(res.Responses, 5)
+
+	ats.Equal(http.StatusOK, res.Responses[0].Status)
+	ats.Require().NotNil(res.Responses[0].Result)
+	ats.Equal(1, res.Responses[0].Result.Total)
+	ats.Equal("http://example.com/1", res.Responses[0].Result.Hits[0].Url)
+
+	for _, i := range []int{1, 2, 3} {
+		ats.Equal(http.StatusBadRequest, res.Responses[i].Status, i)
+		ats.NotEmpty(res.Responses[i].Error, i)
+		ats.Nil(res.Responses[i].Result, i)
+	}
+
+	ats.Equal(http.StatusOK, res.Responses[4].Status)
+	ats.Equal(2, res.Responses[4].Result.Total)
+	ats.Len(res.Responses[4].Result.Hits, 1)
+}
+
+func (ats *apiTestSuite) TestMultiSearchWithoutErrors() {
+	rec := ats.request(http.MethodPost, "/api/_msearch", `[{"collection": "test", "query": {"must": [{"match": {"query": "golang"}}]}}]`)
+	ats.Equal(http.StatusOK, rec.Code)
+	var res MultiSearchResponse
+	ats.decode(rec, &res)
+	ats.False(res.Errors)
+	ats.Equal(0, res.Responses[0].Result.Total)
+}
+
+func (ats *apiTestSuite) TestMultiSearchInvalid() {
+	query := `{"collection": "test", "query": {"must": [{"match": {"query": "golang"}}]}}`
+	tooMany := "[" + strings.TrimSuffix(strings.Repeat(query+",", maxMultiSearchQueries+1), ",") + "]"
+	for _, body := range []string{`[]`, `{}`, `[`, tooMany} {
+		rec := ats.request(http.MethodPost, "/api/_msearch", body)
+		ats.Equal(http.StatusBadRequest, rec.Code, fmt.Sprintf("%.20s", body))
+	}
+}
+
+func (ats *apiTestSuite) TestMultiSearchTimeout() {
+	ats.withBlockingProcessor()
+	rec := ats.request(http.MethodPost, "/api/_msearch", `[
+		{"collection": "slow", "query": {"must": [{"match": {"query": "golang"}}]}},
+		{"collection": "test", "query": {"must": [{"match": {"query": "golang"}}]}}
+	]`)
+	ats.Equal(http.StatusOK, rec.Code)
+	var res MultiSearchResponse
+	ats.decode(rec, &res)
+	ats.True(res.Errors)
+	ats.Equal(http.StatusGatewayTimeout, res.Responses[0].Status)
+	ats.Equal(http.StatusOK, res.Responses[1].Status)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/polyse/database/internal/collection"
+	"github.com/rs/zerolog/log"
+)
+
+// Limits of the time scrolls are kept open for between requests.
+const (
+	defaultScrollKeepAlive = time.Minute
+	maxScrollKeepAlive     = time.Hour
+)
+
+// ListRequest is struct to Bind query params of listing documents.
+type ListRequest struct {
+	Limit int `validate:"gte=0,lte=1000" query:"limit"`
+	// After is the cursor returned with the previous page.
+	After string `query:"after"`
+	// Scroll is the time to keep the scroll open for, like 1m. If it is set, a scroll is opened
+	// instead of listing by the cursor.
+	Scroll string `query:"scroll"`
+}
+
+// ScrollRequest is struct to Bind query params of getting the next page of a scroll.
+type ScrollRequest struct {
+	ScrollID string `validate:"required" query:"scroll_id"`
+	Limit    int    `validate:"gte=0,lte=1000" query:"limit"`
+	Scroll   string `query:"scroll"`
+}
+
+// handleListDocuments returns a page of stored documents in the order they were added in, the next page is
+// requested with the cursor returned in next. With the scroll param it opens a scroll over all documents
+// and returns its first page, next pages are requested from handleScroll.
+func (a *API) handleListDocuments(c echo.Context) error {
+	collectionName := c.Param("collection")
+	proc, err := a.Manager.GetProcessor(collectionName)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleListDocuments GetProcessor err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	request := &ListRequest{}
+	if err = c.Bind(request); err != nil {
+		log.Debug().Err(err).Msg("handleListDocuments Bind err")
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+	if err = c.Validate(request); err != nil {
+		log.Debug().Err(err).Msg("handleListDocuments Validate err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := a.requestContext(c)
+	defer cancel()
+
+	if request.Scroll == "" {
+		page, err := proc.List(ctx, request.After, request.Limit)
+		if err != nil {
+			return scrollError(err, "handleListDocuments List err")
+		}
+		return c.JSON(http.StatusOK, page)
+	}
+	if request.After != "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "after can not be used with scroll")
+	}
+	keepAlive, err := parseKeepAlive(request.Scroll)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	page, err := proc.Scroll(ctx, "", request.Limit, keepAlive)
+	if err != nil {
+		return scrollError(err, "handleListDocuments Scroll err")
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// handleScroll returns the next page of the scroll and keeps it open for the time of the scroll param,
+// 1m by default. The scroll is closed after the last page.
+func (a *API) handleScroll(c echo.Context) error {
+	collectionName := c.Param("collection")
+	proc, err := a.Manager.GetProcessor(collectionName)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleScroll GetProcessor err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	request := &ScrollRequest{}
+	if err = c.Bind(request); err != nil {
+		log.Debug().Err(err).Msg("handleScroll Bind err")
+		return echo.NewHTTPError(http.StatusBadRequest)
+	}
+	if err = c.Validate(request); err != nil {
+		log.Debug().Err(err).Msg("handleScroll Validate err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	keepAlive, err := parseKeepAlive(request.Scroll)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx, cancel := a.requestContext(c)
+	defer cancel()
+
+	page, err := proc.Scroll(ctx, request.ScrollID, request.Limit, keepAlive)
+	if err != nil {
+		return scrollError(err, "handleScroll Scroll err")
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// handleClearScroll closes the scroll before it expires.
+func (a *API) handleClearScroll(c echo.Context) error {
+	collectionName := c.Param("collection")
+	proc, err := a.Manager.GetProcessor(collectionName)
+	if err != nil {
+		log.Debug().Err(err).Msg("handleClearScroll GetProcessor err")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	scrollID := c.QueryParam("scroll_id")
+	if scrollID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "scroll_id is required")
+	}
+	if !proc.ClearScroll(scrollID) {
+		return echo.NewHTTPError(http.StatusNotFound, collection.ErrScrollNotFound.Error())
+	}
+	return ok(c)
+}
+
+// parseKeepAlive parses the time to keep a scroll open for, an empty value means the default.
+func parseKeepAlive(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultScrollKeepAlive, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 || d > maxScrollKeepAlive {
+		return 0, fmt.Errorf("scroll must be a positive duration up to %s", maxScrollKeepAlive)
+	}
+	return d, nil
+}
+
+// scrollError converts the error of listing or scrolling documents to the http error.
+func scrollError(err error, msg string) error {
+	switch {
+	case errors.Is(err, collection.ErrInvalidCursor):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, collection.ErrScrollNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, collection.ErrTooManyScrolls):
+		return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+	}
+	if httpErr := contextError(err); httpErr != nil {
+		return httpErr
+	}
+	log.Err(err).Msg(msg)
+	return echo.NewHTTPError(http.StatusInternalServerError)
+}
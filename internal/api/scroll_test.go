@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/polyse/database/internal/collection"
+)
+
+func (ats *apiTestSuite) addThreeDocuments() {
+	ats.addDocuments(
+		document("http://example.com/1", "first", "golang"),
+		document("http://example.com/2", "second", "rust"),
+		document("http://example.com/3", "third", "golang"),
+	)
+}
+
+func (ats *apiTestSuite) TestListDocuments() {
+	ats.addThreeDocuments()
+	rec := ats.request(http.MethodGet, "/api/test/documents/_all?limit=2", "")
+	ats.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	var page collection.DocumentPage
+	ats.decode(rec, &page)
+	ats.Require().Len(page.Docs, 2)
+	ats.Equal("http://example.com/1", page.Docs[0].Url)
+	ats.Equal("http://example.com/2", page.Docs[1].Url)
+	ats.NotEmpty(page.Next)
+
+	rec = ats.request(http.MethodGet, "/api/test/documents/_all?limit=2&after="+page.Next, "")
+	ats.Equal(http.StatusOK, rec.Code)
+	page = collection.DocumentPage{}
+	ats.decode(rec, &page)
+	ats.Require().Len(page.Docs, 1)
+	ats.Equal("http://example.com/3", page.Docs[0].Url)
+	ats.Empty(page.Next)
+}
+
+func (ats *apiTestSuite) TestListDocumentsInvalid() {
+	for _, target := range []string{
+		"/api/test/documents/_all?after=abc",
+		"/api/test/documents/_all?limit=-1",
+		"/api/test/documents/_all?limit=1001",
+		"/api/test/documents/_all?after=1&scroll=1m",
+		"/api/test/documents/_all?scroll=2h",
+		"/api/test/documents/_all?scroll=abc",
+	} {
+		ats.Equal(http.StatusBadRequest, ats.request(http.MethodGet, target, "").Code, target)
+	}
+}
+
+func (ats *apiTestSuite) TestListDocumentsTimeout() {
+	ats.withBlockingProcessor()
+	ats.Equal(http.StatusGatewayTimeout, ats.request(http.MethodGet, "/api/slow/documents/_all", "").Code)
+}
+
+func (ats *apiTestSuite) TestScroll() {
+	ats.addThreeDocuments()
+	rec := ats.request(http.MethodGet, "/api/test/documents/_all?limit=2&scroll=1m", "")
+	ats.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	var page collection.ScrollPage
+	ats.decode(rec, &page)
+	ats.Equal(3, page.Total)
+	ats.Len(page.Docs, 2)
+	ats.Require().NotEmpty(page.ScrollID)
+	scrollID := page.ScrollID
+
+	// Documents added after the scroll is opened are not returned.
+	ats.addDocuments(document("http://example.com/4", "fourth", "golang"))
+
+	rec = ats.request(http.MethodGet, "/api/test/documents/_scroll?limit=2&scroll_id="+scrollID, "")
+	ats.Equal(http.StatusOK, rec.Code, rec.Body.String())
+	page = collection.ScrollPage{}
+	ats.decode(rec, &page)
+	ats.Require().Len(page.Docs, 1)
+	ats.Equal("http://example.com/3", page.Docs[0].Url)
+	ats.Empty(page.ScrollID)
+
+	// The scroll is closed after the last page.
+	rec = ats.request(http.MethodGet, "/api/test/documents/_scroll?scroll_id="+scrollID, "")
+	ats.Equal(http.StatusNotFound, rec.Code)
+}
+
+func (ats *apiTestSuite) TestClearScroll() {
+	ats.addThreeDocuments()
+	rec := ats.request(http.MethodGet, "/api/test/documents/_all?limit=1&scroll=1m", "")
+	ats.Equal(http.StatusOK, rec.Code)
+	var page collection.ScrollPage
+	ats.decode(rec, &page)
+	ats.Require().NotEmpty(page.ScrollID)
+
+	target := "/api/test/documents/_scroll?scroll_id=" + page.ScrollID
+	ats.Equal(http.StatusOK, ats.request(http.MethodDelete, target, "").Code)
+	ats.Equal(http.StatusNotFound, ats.request(http.MethodDelete, target, "").Code)
+	ats.Equal(http.StatusNotFound, ats.request(http.MethodGet, target, "").Code)
+	ats.Equal(http.StatusBadRequest, ats.request(http.MethodDelete, "/api/test/documents/_scroll", "").Code)
+}
+
+func (ats *apiTestSuite) TestScrollInvalid() {
+	for _, target := range []string{
+		"/api/test/documents/_scroll",
+		"/api/test/documents/_scroll?scroll_id=abc&limit=1001",
+		"/api/test/documents/_scroll?scroll_id=abc&scroll=0s",
+	} {
+		ats.Equal(http.StatusBadRequest, ats.request(http.MethodGet, target, "").Code, target)
+	}
+	ats.Equal(http.StatusNotFound, ats.request(http.MethodGet, "/api/test/documents/_scroll?scroll_id=abc", "").Code)
+}
+
+func (ats *apiTestSuite) TestTooManyScrolls() {
+	ats.addThreeDocuments()
+	// Scrolls are opened until the limit of the collection is reached.
+	status := http.StatusOK
+	for i := 0; i < 1000 && status == http.StatusOK; i++ {
+		status = ats.request(http.MethodGet, "/api/test/documents/_all?limit=1&scroll=1m", "").Code
+	}
+	ats.Equal(http.StatusTooManyRequests, status)
+}
@@ -0,0 +1,274 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/polyse/database/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrUnknownAction error to return if the type of a bulk action is not supported.
+	ErrUnknownAction = errors.New("unknown action")
+)
+
+// ActionType is the kind of change made by an Action.
+type ActionType string
+
+// Supported types of actions.
+const (
+	// ActionIndex saves the document like ProcessAndInsertString.
+	ActionIndex ActionType = "index"
+	// ActionDelete deletes the document with the url.
+	ActionDelete ActionType = "delete"
+	// ActionUpdateFields replaces values of the given fields of the saved document with the url.
+	ActionUpdateFields ActionType = "update_fields"
+)
+
+// Action is a change of a single document in a batch of mixed changes.
+// Index actions save Document, its IfVersion is checked like in ProcessAndInsertString.
+// Delete and update_fields actions change the saved document with Url, ErrDocumentNotFound is reported if it does not
+// exist. If IfVersion is set, they are applied only if the current version of the document equals to it.
+// Update_fields actions replace values of Fields and keep the data, the title and other fields of the document,
+// a nil value removes the field. The version of the document is incremented.
+type Action struct {
+	Type      ActionType
+	Url       string
+	IfVersion *uint64
+	Document  RawData
+	Fields    Fields
+}
+
+// fieldsUpdate is an update_fields action prepared for saving.
+type fieldsUpdate struct {
+	// names are names of all updated and removed fields.
+	names  map[string]struct{}
+	stored Fields
+	tokens map[string][]int
+}
+
+// replaces reports whether the token belongs to one of the updated fields.
+func (u *fieldsUpdate) replaces(token string) bool {
+	for name := range u.names {
+		if strings.HasPrefix(token, fieldTerm(name, "")) || strings.HasPrefix(token, numericFieldPrefix(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ActionResult is the result of an action: the version of the document saved by an index or update_fields action
+// or deleted by a delete action, or the error if the action was rejected.
+type ActionResult struct {
+	Url     string
+	Version uint64
+	Err     error
+}
+
+// ApplyActions applies the actions in their order and returns the result of every action in the same order.
+// Every chunk of actions is applied in its own transaction, actions which can not be applied
+// because of a wrong document, a version conflict or a missing document are rejected and skipped.
+// If applying a chunk fails or the context is done, *ChunkError is returned and the remaining actions are not applied,
+// only results of the applied chunks are returned then.
+func (p *SimpleProcessor) ApplyActions(ctx context.Context, actions []Action) ([]ActionResult, error) {
+	log.Debug().
+		Str("collection in processor", p.GetCollectionName()).
+		Int("actions", len(actions)).
+		Msg("applying actions")
+	schema, err := p.Schema(ctx)
+	if err != nil {
+		return nil, &ChunkError{Err: err}
+	}
+	results := make([]ActionResult, 0, len(actions))
+	for offset := 0; offset < len(actions); offset += p.chunkSize {
+		end := offset + p.chunkSize
+		if end > len(actions) {
+			end = len(actions)
+		}
+		chunk, err := p.applyChunk(ctx, &schema, actions[offset:end])
+		if err != nil {
+			return results, &ChunkError{Processed: offset, Err: err}
+		}
+		results = append(results, chunk...)
+	}
+	return results, nil
+}
+
+// applyChunk analyzes the actions and applies them in a single transaction.
+func (p *SimpleProcessor) applyChunk(ctx context.Context, schema *Schema, actions []Action) ([]ActionResult, error) {
+	var data []RawData
+	for i := range actions {
+		if actions[i].Type == ActionIndex {
+			data = append(data, actions[i].Document)
+		}
+	}
+	analyzed := p.analyzeDocuments(ctx, schema, data)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]ActionResult, len(actions))
+	docs := make(map[int]document, len(analyzed))
+	updates := make(map[int]fieldsUpdate)
+	for i := range actions {
+		a := &actions[i]
+		results[i].Url = a.Url
+		switch a.Type {
+		case ActionIndex:
+			docs[i], analyzed = analyzed[0], analyzed[1:]
+			results[i].Url, results[i].Err = a.Document.Url, docs[i].err
+		case ActionDelete, ActionUpdateFields:
+			if a.Url == "" {
+				results[i].Err = ErrEmptyURL
+				continue
+			}
+			if a.Type == ActionDelete {
+				continue
+			}
+			u, err := p.analyzeUpdate(schema, a.Fields)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			updates[i] = u
+		default:
+			results[i].Err = fmt.Errorf("%w %q", ErrUnknownAction, a.Type)
+		}
+	}
+
+	applied := make([]ActionResult, len(results))
+	if err := p.update(func(tx storage.Tx) error {
+		w := newBatchWriter(p.index(tx))
+		copy(applied, results)
+		for i := range actions {
+			if applied[i].Err != nil {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			a := &actions[i]
+			var version uint64
+			var err error
+			switch a.Type {
+			case ActionIndex:
+				doc := docs[i]
+				version, err = w.writeDocument(&doc)
+			case ActionDelete:
+				version, err = w.delete(a.Url, a.IfVersion)
+			case ActionUpdateFields:
+				version, err = w.updateFields(a.Url, a.IfVersion, updates[i])
+			}
+			if errors.Is(err, ErrVersionConflict) || errors.Is(err, ErrDocumentNotFound) {
+				applied[i].Err = err
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			applied[i].Version = version
+		}
+		return w.flush()
+	}); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// analyzeUpdate checks the updated fields against the schema and returns their stored values and tokens.
+func (p *SimpleProcessor) analyzeUpdate(schema *Schema, fields Fields) (u fieldsUpdate, err error) {
+	u.names = make(map[string]struct{}, len(fields))
+	set := make(Fields, len(fields))
+	for name, v := range fields {
+		if name == dataField || name == titleField || name == hostFilter {
+			return u, fmt.Errorf("%w: field name %q is reserved", ErrInvalidField, name)
+		}
+		u.names[name] = struct{}{}
+		if v != nil {
+			set[name] = v
+		}
+	}
+	u.stored, u.tokens, err = p.analyzeFields(schema, set)
+	return u, err
+}
+
+// delete deletes the saved document with the url if its version equals to the expected one
+// and returns the version of the deleted document.
+func (w *batchWriter) delete(url string, ifVersion *uint64) (uint64, error) {
+	id, ok, version, err := w.version(RawData{Url: url, IfVersion: ifVersion})
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrDocumentNotFound
+	}
+	return version, w.remove(id, url)
+}
+
+// updateFields replaces tokens and stored values of the updated fields of the saved document
+// and increments its version, the new version is returned.
+func (w *batchWriter) updateFields(url string, ifVersion *uint64, u fieldsUpdate) (uint64, error) {
+	id, ok, version, err := w.version(RawData{Url: url, IfVersion: ifVersion})
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrDocumentNotFound
+	}
+	src, err := w.idx.source(id)
+	if err != nil {
+		return 0, err
+	}
+	tokens, err := w.idx.tokens(id)
+	if err != nil {
+		return 0, err
+	}
+	_, written := w.written[id]
+	kept := make([]string, 0, len(tokens)+len(u.tokens))
+	for _, token := range tokens {
+		if !u.replaces(token) {
+			kept = append(kept, token)
+			continue
+		}
+		if err = w.removeToken(token, id, written); err != nil {
+			return 0, err
+		}
+	}
+	for token, pos := range u.tokens {
+		if err = w.addToken(token, id, pos); err != nil {
+			return 0, err
+		}
+		kept = append(kept, token)
+	}
+	sort.Strings(kept)
+	if err = w.idx.putTokens(id, kept); err != nil {
+		return 0, err
+	}
+
+	var fields Fields
+	for name, v := range src.Fields {
+		if _, ok := u.names[name]; !ok {
+			if fields == nil {
+				fields = make(Fields, len(src.Fields)+len(u.stored))
+			}
+			fields[name] = v
+		}
+	}
+	for name, v := range u.stored {
+		if fields == nil {
+			fields = make(Fields, len(u.stored))
+		}
+		fields[name] = v
+	}
+	src.Fields = fields
+	src.Version = version + 1
+	if err = w.idx.putSource(id, src); err != nil {
+		return 0, fmt.Errorf("can not save source %s, error %s", url, err)
+	}
+	w.written[id] = struct{}{}
+	return src.Version, nil
+}
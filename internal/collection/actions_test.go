@@ -0,0 +1,90 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func (cts *processorTestSuite) TestSimpleProcessor_ApplyActions() {
+	cts.NoError(cts.proc.SetSchema(context.Background(), Schema{Fields: map[string]FieldMapping{
+		"lang":  {Type: TypeKeyword},
+		"words": {Type: TypeInteger},
+	}}))
+	version := func(v uint64) *uint64 { return &v }
+	now := time.Now()
+	results, err := cts.proc.ApplyActions(context.Background(), []Action{
+		{Type: ActionIndex, Document: RawData{Url: "source1", Data: "golang generics", Source: Source{
+			Date: now, Title: "Generics", Fields: Fields{"lang": "en", "words": 100},
+		}}},
+		{Type: ActionIndex, Document: RawData{Url: "source2", Data: "golang channels", Source: Source{
+			Date: now, Title: "Channels", Fields: Fields{"lang": "en"},
+		}}},
+		{Type: ActionUpdateFields, Url: "source1", Fields: Fields{"lang": "de", "words": nil}},
+		{Type: ActionDelete, Url: "source2", IfVersion: version(1)},
+		{Type: ActionDelete, Url: "source3"},
+		{Type: ActionUpdateFields, Url: "source1", IfVersion: version(1), Fields: Fields{"lang": "fr"}},
+		{Type: ActionUpdateFields, Url: "source1", Fields: Fields{"title": "Go"}},
+		{Type: ActionIndex, Document: RawData{Data: "golang"}},
+		{Type: "upsert", Url: "source1"},
+	})
+	cts.NoError(err)
+	cts.Require().Len(results, 9)
+	for i, r := range []struct {
+		url     string
+		version uint64
+		err     error
+	}{
+		{"source1", 1, nil},
+		{"source2", 1, nil},
+		{"source1", 2, nil},
+		{"source2", 1, nil},
+		{"source3", 0, ErrDocumentNotFound},
+		{"source1", 0, ErrVersionConflict},
+		{"source1", 0, ErrInvalidField},
+		{"", 0, ErrEmptyURL},
+		{"source1", 0, ErrUnknownAction},
+	} {
+		cts.Equal(r.url, results[i].Url, i)
+		cts.Equal(r.version, results[i].Version, i)
+		if r.err == nil {
+			cts.NoError(results[i].Err, i)
+		} else {
+			cts.True(errors.Is(results[i].Err, r.err), results[i].Err)
+		}
+	}
+
+	res, err := cts.proc.Get(context.Background(), "source1")
+	cts.NoError(err)
+	cts.Equal(uint64(2), res.Version)
+	cts.Equal("Generics", res.Title)
+	cts.Equal(Fields{"lang": "de"}, res.Fields)
+	_, err = cts.proc.Get(context.Background(), "source2")
+	cts.Equal(ErrDocumentNotFound, err)
+	cts.Equal(map[string][]int{"source1": {0}}, cts.postings("golang"))
+	cts.Equal(map[string][]int{"source1": {0}}, cts.postings(fieldTerm("lang", "de")))
+	cts.Empty(cts.postings(fieldTerm("lang", "en")))
+
+	urls := func(q StructuredQuery) []string {
+		res, err := cts.proc.SearchStructured(context.Background(), q)
+		cts.NoError(err)
+		var urls []string
+		for _, h := range res.Hits {
+			urls = append(urls, h.Url)
+		}
+		return urls
+	}
+	words := StructuredQuery{Filter: []Clause{{Range: &RangeClause{Field: "words", Gte: scalar("200")}}}}
+	cts.Empty(urls(words))
+
+	// Updates of saved documents keep tokens of other fields.
+	results, err = cts.proc.ApplyActions(context.Background(), []Action{
+		{Type: ActionUpdateFields, Url: "source1", IfVersion: version(2), Fields: Fields{"words": 300}},
+	})
+	cts.NoError(err)
+	cts.Equal([]ActionResult{{Url: "source1", Version: 3}}, results)
+	cts.Equal([]string{"source1"}, urls(words))
+	cts.Equal([]string{"source1"}, urls(StructuredQuery{Filter: []Clause{{Term: &TermClause{Field: "lang", Value: "de"}}}}))
+	cts.Equal([]string{"source1"}, urls(StructuredQuery{Must: []Clause{{Match: &MatchClause{Query: "generics"}}}}))
+	cts.Equal(uint64(1), cts.documents(cts.proc.(*SimpleProcessor)))
+}
@@ -0,0 +1,154 @@
+package collection
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultAggsSize is the default number of buckets of terms aggregations.
+const defaultAggsSize = 10
+
+// Aggregation summarizes all documents found by a structured query, exactly one of its members must be set:
+//    {"terms": {"field": "lang", "size": 5}}
+//    {"stats": {"field": "words"}}
+type Aggregation struct {
+	Terms *TermsAggregation `json:"terms"`
+	Stats *StatsAggregation `json:"stats"`
+}
+
+// TermsAggregation counts found documents with every value of the field which is not text,
+// Size most frequent values are returned, 10 by default.
+type TermsAggregation struct {
+	Field string `json:"field" validate:"required"`
+	Size  int    `json:"size" validate:"gte=0"`
+}
+
+// StatsAggregation computes statistics of values of the numeric or date field,
+// dates are numbers of seconds since the epoch.
+type StatsAggregation struct {
+	Field string `json:"field" validate:"required"`
+}
+
+// AggregationResult is the result of the terms or the stats aggregation.
+type AggregationResult struct {
+	Buckets []AggregationBucket `json:"buckets,omitempty"`
+	Stats   *NumericStats       `json:"stats,omitempty"`
+}
+
+// AggregationBucket is the number of found documents with the value of the field.
+type AggregationBucket struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// NumericStats are statistics of values of a field, every value of arrays is counted.
+type NumericStats struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Sum   float64 `json:"sum"`
+	Avg   float64 `json:"avg"`
+}
+
+func (s *NumericStats) add(v float64) {
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count++
+	s.Sum += v
+	s.Avg = s.Sum / float64(s.Count)
+}
+
+// analyzedAgg is an aggregation of values of tokens with the prefix.
+type analyzedAgg struct {
+	name   string
+	prefix string
+	stats  bool
+	size   int
+}
+
+// analyzeAggs checks fields of the aggregations, the error wraps ErrInvalidQuery or ErrInvalidField.
+func analyzeAggs(schema *Schema, aggs map[string]Aggregation) ([]analyzedAgg, error) {
+	res := make([]analyzedAgg, 0, len(aggs))
+	for name, a := range aggs {
+		switch {
+		case a.Terms != nil && a.Stats == nil:
+			m, ok := schema.mapping(a.Terms.Field)
+			if !ok || m.Type == TypeText || !m.indexed() || a.Terms.Field == titleField {
+				return nil, fmt.Errorf("%w: terms aggregation on field %s", ErrInvalidField, a.Terms.Field)
+			}
+			size := a.Terms.Size
+			if size <= 0 {
+				size = defaultAggsSize
+			}
+			res = append(res, analyzedAgg{name: name, prefix: fieldTerm(a.Terms.Field, ""), size: size})
+		case a.Stats != nil && a.Terms == nil:
+			if _, err := schema.checkNumeric(a.Stats.Field, "stats aggregation"); err != nil {
+				return nil, err
+			}
+			res = append(res, analyzedAgg{name: name, prefix: numericFieldPrefix(a.Stats.Field), stats: true})
+		default:
+			return nil, fmt.Errorf("%w: aggregation %s must have one of terms or stats", ErrInvalidQuery, name)
+		}
+	}
+	return res, nil
+}
+
+// aggregate computes the aggregations of the documents from their tokens.
+func aggregate(ctx context.Context, idx *index, aggs []analyzedAgg, ids []uint64) (map[string]AggregationResult, error) {
+	if len(aggs) == 0 {
+		return nil, nil
+	}
+	counts := make([]map[string]int, len(aggs))
+	stats := make([]NumericStats, len(aggs))
+	for i := range aggs {
+		counts[i] = make(map[string]int)
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tokens, err := idx.tokens(id)
+		if err != nil {
+			return nil, err
+		}
+		for i, a := range aggs {
+			for j := sort.SearchStrings(tokens, a.prefix); j < len(tokens) && strings.HasPrefix(tokens[j], a.prefix); j++ {
+				value := tokens[j][len(a.prefix):]
+				if !a.stats {
+					counts[i][value]++
+				} else if len(value) == 8 {
+					stats[i].add(decodeFloat([]byte(value)))
+				}
+			}
+		}
+	}
+
+	res := make(map[string]AggregationResult, len(aggs))
+	for i, a := range aggs {
+		if a.stats {
+			res[a.name] = AggregationResult{Stats: &stats[i]}
+			continue
+		}
+		buckets := make([]AggregationBucket, 0, len(counts[i]))
+		for value, count := range counts[i] {
+			buckets = append(buckets, AggregationBucket{Value: value, Count: count})
+		}
+		sort.Slice(buckets, func(x, y int) bool {
+			if buckets[x].Count != buckets[y].Count {
+				return buckets[x].Count > buckets[y].Count
+			}
+			return buckets[x].Value < buckets[y].Value
+		})
+		if len(buckets) > a.size {
+			buckets = buckets[:a.size]
+		}
+		res[a.name] = AggregationResult{Buckets: buckets}
+	}
+	return res, nil
+}
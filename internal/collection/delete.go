@@ -0,0 +1,217 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/polyse/database/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrEmptyDeleteQuery error to return if a delete query has no conditions.
+	ErrEmptyDeleteQuery = errors.New("delete query has no conditions")
+)
+
+// DeleteQuery describes documents to delete. Documents must match all given conditions:
+// the search query, the url prefix and the date bounds, zero values are not checked.
+type DeleteQuery struct {
+	Query     string    `json:"query"`
+	UrlPrefix string    `json:"url_prefix"`
+	Before    time.Time `json:"before"`
+	After     time.Time `json:"after"`
+}
+
+func (q *DeleteQuery) empty() bool {
+	return q.Query == "" && q.UrlPrefix == "" && q.Before.IsZero() && q.After.IsZero()
+}
+
+// DeleteByQuery deletes documents matching the query and returns the number of deleted documents.
+// The search query matches the same documents as ProcessAndGet without pagination.
+//
+// Matching documents are found once and deleted in batches of the configured chunk size, each batch in its own
+// transaction. Every document is checked again before deleting, so documents changed by concurrent writes
+// so that they do not match anymore are kept. If the context is done or deleting fails,
+// the number of documents deleted by the previous batches is returned with the error.
+func (p *SimpleProcessor) DeleteByQuery(ctx context.Context, q DeleteQuery) (int, error) {
+	if q.empty() {
+		return 0, ErrEmptyDeleteQuery
+	}
+	m := &deleteMatcher{query: q}
+	if q.Query != "" {
+		if err := p.store.View(func(tx storage.Tx) error {
+			idx := p.index(tx)
+			schema, err := idx.schema()
+			if err != nil {
+				return err
+			}
+			sq, err := resolveQuery(idx, &schema, ParseQuery(q.Query))
+			if err != nil {
+				return err
+			}
+			m.search, err = p.analyzeQuery(&schema, sq)
+			return err
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	var ids []uint64
+	if err := p.store.View(func(tx storage.Tx) (err error) {
+		ids, err = m.find(ctx, p.index(tx))
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	log.Debug().
+		Str("collection", p.colName).
+		Int("found", len(ids)).
+		Msg("deleting documents by query")
+
+	deleted := 0
+	for start := 0; start < len(ids); start += p.chunkSize {
+		end := start + p.chunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		n := 0
+		if err := p.update(func(tx storage.Tx) (err error) {
+			n, err = m.delete(ctx, p.index(tx), ids[start:end])
+			return err
+		}); err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// deleteMatcher finds and deletes documents matching the delete query.
+type deleteMatcher struct {
+	query DeleteQuery
+	// search is the analyzed search query.
+	search analyzedQuery
+	// count is the number of words found in the matching documents.
+	count int
+}
+
+// find returns sorted ids of matching documents.
+func (m *deleteMatcher) find(ctx context.Context, idx *index) ([]uint64, error) {
+	var ids []uint64
+	if m.query.Query != "" {
+		m.search.sets = m.search.sets[:0]
+		for _, term := range m.search.filterTerms {
+			ids, err := idx.postings(term)
+			if err != nil {
+				return nil, err
+			}
+			m.search.sets = append(m.search.sets, ids)
+		}
+		wps, err := findWords(ctx, idx, m.search.words)
+		if err != nil {
+			return nil, err
+		}
+		lists, err := m.search.filter(ctx, idx, unions(wps))
+		if err != nil {
+			return nil, err
+		}
+		ids = maxKeys(lists)
+		if len(ids) > 0 && len(m.search.words) > 0 {
+			m.count = listsContaining(lists, ids[0])
+		}
+	} else {
+		var err error
+		if ids, err = idx.ids(m.query.UrlPrefix); err != nil {
+			return nil, err
+		}
+	}
+
+	res := ids[:0]
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		_, ok, err := m.match(idx, id, false)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res = append(res, id)
+		}
+	}
+	return res, nil
+}
+
+// delete deletes documents which still match the query and returns the number of deleted documents.
+func (m *deleteMatcher) delete(ctx context.Context, idx *index, ids []uint64) (int, error) {
+	w := newBatchWriter(idx)
+	deleted := 0
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		url, ok, err := m.match(idx, id, m.query.Query != "")
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		if err = w.remove(id, url); err != nil {
+			return 0, err
+		}
+		deleted++
+	}
+	return deleted, w.flush()
+}
+
+// match checks the url and the date of the document and returns its url. If checkTokens is set,
+// the document must contain as many words as the documents found by the search query and match its conditions.
+// Deleted documents do not match.
+func (m *deleteMatcher) match(idx *index, id uint64, checkTokens bool) (string, bool, error) {
+	url, err := idx.url(id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if !strings.HasPrefix(url, m.query.UrlPrefix) {
+		return url, false, nil
+	}
+	if !m.query.Before.IsZero() || !m.query.After.IsZero() {
+		date, err := idx.date(id)
+		if err != nil {
+			return "", false, err
+		}
+		if !m.query.Before.IsZero() && !date.Before(m.query.Before) {
+			return url, false, nil
+		}
+		if !m.query.After.IsZero() && !date.After(m.query.After) {
+			return url, false, nil
+		}
+	}
+	if checkTokens {
+		tokens, err := idx.tokens(id)
+		if err != nil {
+			return "", false, err
+		}
+		if count, ok := m.search.matchTokens(tokens); !ok || count < m.count {
+			return url, false, nil
+		}
+	}
+	return url, true, nil
+}
+
+// listsContaining returns the number of sorted lists containing the id.
+func listsContaining(lists [][]uint64, id uint64) int {
+	count := 0
+	for _, list := range lists {
+		if containsID(list, id) {
+			count++
+		}
+	}
+	return count
+}
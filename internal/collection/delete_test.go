@@ -0,0 +1,62 @@
+package collection
+
+import (
+	"context"
+	"time"
+)
+
+func (cts *processorTestSuite) TestSimpleProcessor_DeleteByQuery() {
+	saveData := []RawData{
+		{Url: "source1", Data: "data1 data2"},
+		{Url: "source2", Data: "data1"},
+		{Url: "source3", Data: "data1 data2"},
+		{Url: "source4", Data: "data3"},
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+
+	deleted, err := cts.proc.DeleteByQuery(context.Background(), DeleteQuery{Query: "data1 data2"})
+	cts.NoError(err)
+	cts.Equal(2, deleted)
+	cts.Equal(map[string][]int{"source2": {0}}, cts.postings("data1"))
+	cts.Empty(cts.postings("data2"))
+	_, err = cts.proc.Get(context.Background(), "source1")
+	cts.Equal(ErrDocumentNotFound, err)
+	cts.Equal(uint64(2), cts.documents(cts.proc.(*SimpleProcessor)))
+
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData[:1]))
+	res, err := cts.proc.Get(context.Background(), "source1")
+	cts.NoError(err)
+	cts.Equal(uint64(1), res.Version)
+	cts.Equal(map[string][]int{"source1": {0}, "source2": {0}}, cts.postings("data1"))
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_DeleteByUrlAndDate() {
+	now := time.Now()
+	saveData := []RawData{
+		{Url: "http://a.com/1", Data: "data1", Source: Source{Date: now.Add(-time.Hour)}},
+		{Url: "http://a.com/2", Data: "data1", Source: Source{Date: now}},
+		{Url: "http://b.com/1", Data: "data1", Source: Source{Date: now.Add(-time.Hour)}},
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+
+	deleted, err := cts.proc.DeleteByQuery(context.Background(), DeleteQuery{
+		UrlPrefix: "http://a.com/",
+		Before:    now.Add(-time.Minute),
+	})
+	cts.NoError(err)
+	cts.Equal(1, deleted)
+	cts.Equal(map[string][]int{"http://a.com/2": {0}, "http://b.com/1": {0}}, cts.postings("data1"))
+
+	deleted, err = cts.proc.DeleteByQuery(context.Background(), DeleteQuery{After: now.Add(-time.Minute)})
+	cts.NoError(err)
+	cts.Equal(1, deleted)
+	cts.Equal(map[string][]int{"http://b.com/1": {0}}, cts.postings("data1"))
+
+	_, err = cts.proc.DeleteByQuery(context.Background(), DeleteQuery{})
+	cts.Equal(ErrEmptyDeleteQuery, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cts.proc.DeleteByQuery(ctx, DeleteQuery{UrlPrefix: "http://"})
+	cts.Equal(context.Canceled, err)
+}
@@ -0,0 +1,102 @@
+package collection
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/polyse/database/internal/storage"
+)
+
+var (
+	// ErrInvalidField error to return if a metadata field of a document has a wrong name or value.
+	ErrInvalidField = errors.New("invalid field")
+
+	fieldNameRe = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+)
+
+// fieldTermPrefix starts tokens of metadata fields, it can not appear in tokens of the text.
+const fieldTermPrefix = "\x01"
+
+// fieldKeyPrefix starts keys of the meta bucket with names of indexed fields, see putFieldNames.
+var fieldKeyPrefix = "field:"
+
+// Fields are metadata fields of a document, such as the author, the language or tags.
+// A value is a string, a number, a boolean or an array of them. Values are checked and indexed according to
+// the schema of the collection, fields missing in the schema are indexed as is and can be matched exactly by search,
+// numbers are matched in their shortest decimal form.
+type Fields map[string]interface{}
+
+// GobEncode encodes fields as json, since gob can not encode values of arbitrary types.
+func (f Fields) GobEncode() ([]byte, error) {
+	return json.Marshal(f)
+}
+
+// GobDecode decodes fields encoded by GobEncode.
+func (f *Fields) GobDecode(b []byte) error {
+	return json.Unmarshal(b, f)
+}
+
+// fieldTerm returns the token of the field value.
+func fieldTerm(name, value string) string {
+	return fieldTermPrefix + name + ":" + value
+}
+
+// formatFieldValue returns the string form of the scalar field value.
+func formatFieldValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	default:
+		return "", false
+	}
+}
+
+// putFieldNames records names of fields with tokens or numeric tokens among the tokens, so conditions
+// of search queries can be told from words containing a colon, see ParseQuery.
+func (idx *index) putFieldNames(tokens map[string][]uint64) error {
+	seen := make(map[string]struct{})
+	for token := range tokens {
+		if !strings.HasPrefix(token, fieldTermPrefix) && !isNumericTerm(token) {
+			continue
+		}
+		name := token[1:strings.IndexByte(token, ':')]
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		ok, err := idx.fieldIndexed(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err = idx.tx.Put(idx.b.meta, []byte(fieldKeyPrefix+name), []byte(name), 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fieldIndexed reports whether a document with the field has been indexed.
+func (idx *index) fieldIndexed(name string) (bool, error) {
+	_, err := idx.tx.Get(idx.b.meta, []byte(fieldKeyPrefix+name))
+	switch err {
+	case nil:
+		return true, nil
+	case storage.ErrNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
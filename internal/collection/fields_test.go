@@ -0,0 +1,157 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/polyse/database/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuery(t *testing.T) {
+	schema := Schema{Fields: map[string]FieldMapping{
+		"lang":      {Type: TypeKeyword},
+		"words":     {Type: TypeInteger},
+		"published": {Type: TypeDate},
+	}}
+	store := storage.NewMemory()
+	assert.NoError(t, store.Update(func(tx storage.Tx) error {
+		idx := &index{tx: tx, b: newBuckets(nutColl)}
+		return idx.putFieldNames(map[string][]uint64{fieldTerm("tags", "go"): nil, numericTerm("rating", 1): nil})
+	}))
+	tests := []struct {
+		name  string
+		query string
+		want  SearchQuery
+	}{
+		{name: "Text", query: "data1 data2", want: SearchQuery{Text: "data1 data2"}},
+		{
+			name:  "Fields",
+			query: "data1 lang:en  tags:go data2 title:golang",
+			want: SearchQuery{
+				Text: "data1 data2",
+				Fields: []FieldMatch{
+					{Name: "lang", Value: "en"}, {Name: "tags", Value: "go"}, {Name: "title", Value: "golang"},
+				},
+			},
+		},
+		{name: "NotFields", query: ":en lang: a/b:c", want: SearchQuery{Text: ":en lang: a/b:c"}},
+		{name: "Time", query: "meeting 10:30", want: SearchQuery{Text: "meeting 10:30"}},
+		{name: "Url", query: "http://example.com", want: SearchQuery{Text: "http://example.com"}},
+		{name: "UnknownField", query: "error:timeout lang:en", want: SearchQuery{
+			Text:   "error:timeout",
+			Fields: []FieldMatch{{Name: "lang", Value: "en"}},
+		}},
+		{name: "Host", query: "data1 _host:example.com", want: SearchQuery{
+			Text:    "data1",
+			Filters: []FieldMatch{{Name: hostFilter, Value: "example.com"}},
+		}},
+		{
+			name:  "Ranges",
+			query: "data1 words:[100 TO *] published:{2020-01-01 TO 2021-01-01] rating:[* TO 5} pages:[1 TO 2]",
+			want: SearchQuery{
+				Text: "data1 pages:[1 TO 2]",
+				Ranges: []FieldRange{
+					{Name: "words", From: "100", IncludeFrom: true, IncludeTo: true},
+					{Name: "published", From: "2020-01-01", To: "2021-01-01", IncludeTo: true},
+					{Name: "rating", To: "5", IncludeFrom: true},
+				},
+			},
+		},
+		{
+			name:  "NotRanges",
+			query: "words:[100 TO words:[1 to 2]",
+			want: SearchQuery{
+				Text:   "TO to 2]",
+				Fields: []FieldMatch{{Name: "words", Value: "[100"}, {Name: "words", Value: "[1"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, store.View(func(tx storage.Tx) error {
+				q, err := resolveQuery(&index{tx: tx, b: newBuckets(nutColl)}, &schema, ParseQuery(tt.query))
+				assert.Equal(t, tt.want, q)
+				return err
+			}))
+		})
+	}
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_Fields() {
+	saveData := []RawData{
+		{Url: "source1", Data: "data1", Source: Source{Fields: Fields{"lang": "en", "tags": []interface{}{"go", "db"}}}},
+		{Url: "source2", Data: "data1 data2", Source: Source{Fields: Fields{"lang": "ru", "words": 2.0}}},
+		{Url: "source3", Data: "data2", Source: Source{Fields: Fields{"lang": "en", "draft": true}}},
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+
+	res, err := cts.proc.Get(context.Background(), "source1")
+	cts.NoError(err)
+	cts.Equal(Fields{"lang": "en", "tags": []interface{}{"go", "db"}}, res.Fields)
+
+	urls := func(query string) []string {
+		res, err := cts.proc.ProcessAndGet(context.Background(), query, 10, 0)
+		cts.NoError(err)
+		var urls []string
+		for _, r := range res {
+			urls = append(urls, r.Url)
+		}
+		return urls
+	}
+	cts.ElementsMatch([]string{"source1", "source3"}, urls("lang:en"))
+	cts.Equal([]string{"source1"}, urls("data1 lang:en"))
+	cts.Equal([]string{"source1"}, urls("data1 data2 tags:db"))
+	cts.Equal([]string{"source2"}, urls("words:2"))
+	cts.Equal([]string{"source3"}, urls("draft:true lang:en"))
+	cts.Empty(urls("data3 lang:en"))
+	cts.Empty(urls("lang:de"))
+
+	// The updated document loses its old fields.
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{{Url: "source1", Data: "data1"}}))
+	cts.Equal([]string{"source3"}, urls("lang:en"))
+
+	deleted, err := cts.proc.DeleteByQuery(context.Background(), DeleteQuery{Query: "data2 lang:en"})
+	cts.NoError(err)
+	cts.Equal(1, deleted)
+	cts.Empty(urls("lang:en"))
+
+	rejected, err := cts.proc.ProcessAndInsertBestEffort(context.Background(), []RawData{
+		{Url: "source4", Data: "data1", Source: Source{Fields: Fields{"a b": "c"}}},
+		{Url: "source5", Data: "data1", Source: Source{Fields: Fields{"a": map[string]interface{}{}}}},
+	})
+	cts.NoError(err)
+	cts.Len(rejected, 2)
+	for _, r := range rejected {
+		cts.True(errors.Is(r.Err, ErrInvalidField))
+	}
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_WordsWithColons() {
+	saveData := []RawData{
+		{Url: "source1", Data: "meeting at 10:30"},
+		{Url: "source2", Data: "error timeout"},
+		{Url: "source3", Data: "see http://example.com"},
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	urls := func(query string) []string {
+		res, err := cts.proc.ProcessAndGet(context.Background(), query, 10, 0)
+		cts.NoError(err)
+		var urls []string
+		for _, r := range res {
+			urls = append(urls, r.Url)
+		}
+		return urls
+	}
+
+	// Words with a colon are the text unless the name is a known field.
+	cts.Equal([]string{"source1"}, urls("meeting 10:30"))
+	cts.Equal([]string{"source3"}, urls("http://example.com"))
+	cts.Equal([]string{"source2"}, urls("error:timeout"))
+
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+		{Url: "source4", Data: "failed", Source: Source{Fields: Fields{"error": "timeout"}}},
+	}))
+	cts.Equal([]string{"source4"}, urls("error:timeout"))
+}
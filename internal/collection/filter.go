@@ -0,0 +1,175 @@
+package collection
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/polyse/database/internal/storage"
+)
+
+// hostFilter is the name of the filter matching documents by the host of their url.
+// It can not be used as a name of metadata fields.
+const hostFilter = "_host"
+
+// hostTermPrefix starts tokens of hosts of document urls.
+const hostTermPrefix = "\x03"
+
+func hostTerm(host string) string {
+	return hostTermPrefix + strings.ToLower(host)
+}
+
+// urlHost returns the host of the url without the port, it is empty if the url has no host.
+func urlHost(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// hostTerms returns the token of the host of the document url.
+func hostTerms(rawurl string) map[string][]int {
+	host := urlHost(rawurl)
+	if host == "" {
+		return nil
+	}
+	return map[string][]int{hostTerm(host): nil}
+}
+
+// filterTerm returns the token a document must contain to match the filter. Filters match values of fields
+// exactly, so they can not be used with text fields. An error wrapping ErrInvalidField is returned
+// if the field can not be filtered.
+func (p *SimpleProcessor) filterTerm(schema *Schema, f FieldMatch) (string, error) {
+	if f.Name == hostFilter {
+		return hostTerm(f.Value), nil
+	}
+	m, ok := schema.mapping(f.Name)
+	switch {
+	case !ok:
+		return "", fmt.Errorf("%w: filter on field %q which is not in the schema", ErrInvalidField, f.Name)
+	case m.Type == TypeText:
+		return "", fmt.Errorf("%w: filter on text field %s", ErrInvalidField, f.Name)
+	case !m.indexed():
+		return "", fmt.Errorf("%w: filter on field %s which is not indexed", ErrInvalidField, f.Name)
+	}
+	return p.fieldTerms(schema, f)[0], nil
+}
+
+// filterSets returns sorted ids of documents matching every filter token, ids are taken from the cache if possible.
+// The generation of the cache must be taken before the transaction of the index is started.
+func (p *SimpleProcessor) filterSets(ctx context.Context, idx *index, gen uint64, terms []string) ([][]uint64, error) {
+	sets := make([][]uint64, 0, len(terms))
+	for _, term := range terms {
+		if ids, ok := p.filterCache.get(term); ok {
+			sets = append(sets, ids)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ids, err := idx.postings(term)
+		if err != nil {
+			return nil, err
+		}
+		p.filterCache.put(term, gen, ids)
+		sets = append(sets, ids)
+	}
+	return sets, nil
+}
+
+// update runs the function in a write transaction. Cached filters with postings changed by the transaction
+// are dropped before it is committed, see filterCache.
+func (p *SimpleProcessor) update(fn func(tx storage.Tx) error) error {
+	return p.store.Update(func(tx storage.Tx) error {
+		ftx := &filterTx{Tx: tx, bucket: p.buckets.data, terms: make(map[string]struct{})}
+		if err := fn(ftx); err != nil {
+			return err
+		}
+		p.filterCache.invalidate(ftx.terms)
+		return nil
+	})
+}
+
+// filterTx is a write transaction collecting tokens of filters with changed postings in the bucket.
+type filterTx struct {
+	storage.Tx
+	bucket string
+	terms  map[string]struct{}
+}
+
+func (t *filterTx) Put(bucket string, key, value []byte, ttl uint32) error {
+	t.touch(bucket, key)
+	return t.Tx.Put(bucket, key, value, ttl)
+}
+
+func (t *filterTx) Delete(bucket string, key []byte) error {
+	t.touch(bucket, key)
+	return t.Tx.Delete(bucket, key)
+}
+
+func (t *filterTx) touch(bucket string, key []byte) {
+	if bucket != t.bucket {
+		return
+	}
+	if token := string(key); strings.HasPrefix(token, fieldTermPrefix) || strings.HasPrefix(token, hostTermPrefix) {
+		t.terms[token] = struct{}{}
+	}
+}
+
+// filterCache keeps ids of documents matching filters of recent searches. Every write to the collection
+// starts a new generation of the cache and drops ids of the filters it changes. Writes block searches
+// and drop ids before they are committed, so a search either finds ids before the write and can not cache
+// them in the new generation, or finds them after the write is committed.
+// Cached ids are shared between searches and must not be modified.
+type filterCache struct {
+	mu      sync.Mutex
+	size    int
+	gen     uint64
+	entries map[string][]uint64
+}
+
+func newFilterCache(size int) *filterCache {
+	return &filterCache{size: size, entries: make(map[string][]uint64)}
+}
+
+func (c *filterCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+func (c *filterCache) get(term string) ([]uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids, ok := c.entries[term]
+	return ids, ok
+}
+
+// put caches ids of the filter found in the generation, if the cache is full an arbitrary entry is dropped.
+func (c *filterCache) put(term string, gen uint64, ids []uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen || c.size <= 0 {
+		return
+	}
+	if _, ok := c.entries[term]; !ok && len(c.entries) >= c.size {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[term] = ids
+}
+
+// invalidate starts a new generation of the cache and drops ids of the filters.
+func (c *filterCache) invalidate(terms map[string]struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for term := range terms {
+		delete(c.entries, term)
+	}
+}
@@ -0,0 +1,139 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterCache(t *testing.T) {
+	c := newFilterCache(2)
+	gen := c.generation()
+	c.put("a", gen, []uint64{1})
+	c.put("b", gen, []uint64{2})
+	ids, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []uint64{1}, ids)
+
+	c.put("c", gen, []uint64{3})
+	assert.Len(t, c.entries, 2)
+	_, ok = c.get("c")
+	assert.True(t, ok)
+
+	// A write drops only the filters it changes, ids found before it are not cached.
+	c.invalidate(map[string]struct{}{"c": {}})
+	_, ok = c.get("c")
+	assert.False(t, ok)
+	assert.Len(t, c.entries, 1)
+	c.put("c", gen, []uint64{3})
+	_, ok = c.get("c")
+	assert.False(t, ok)
+
+	c = newFilterCache(-1)
+	c.put("a", c.generation(), []uint64{1})
+	_, ok = c.get("a")
+	assert.False(t, ok)
+}
+
+func TestParseFilter(t *testing.T) {
+	f, ok := ParseFilter("section:world news")
+	assert.True(t, ok)
+	assert.Equal(t, FieldMatch{Name: "section", Value: "world news"}, f)
+	for _, s := range []string{"lang", "lang:", ":en", "a/b:c"} {
+		_, ok = ParseFilter(s)
+		assert.False(t, ok, s)
+	}
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_Filters() {
+	cts.NoError(cts.proc.SetSchema(context.Background(), Schema{Fields: map[string]FieldMapping{
+		"author": {Type: TypeText},
+		"lang":   {Type: TypeKeyword},
+	}}))
+	now := time.Now()
+	saveData := []RawData{
+		{Url: "https://news.example.com/1", Data: "golang", Source: Source{
+			Date: now, Title: "golang", Fields: Fields{"lang": "en", "section": "news"},
+		}},
+		{Url: "https://news.example.com/2", Data: "golang", Source: Source{
+			Date: now.Add(time.Hour), Fields: Fields{"lang": "de", "section": "news"},
+		}},
+		{Url: "https://blog.example.com/3", Data: "golang", Source: Source{
+			Date: now.Add(2 * time.Hour), Fields: Fields{"lang": "en", "section": "blog"},
+		}},
+		{Url: "source4", Data: "golang", Source: Source{Date: now.Add(3 * time.Hour), Fields: Fields{"lang": "en"}}},
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+
+	urls := func(query string, filters ...FieldMatch) []string {
+		q := ParseQuery(query)
+		q.Filters = filters
+		res, err := cts.proc.Search(context.Background(), q)
+		cts.NoError(err)
+		var urls []string
+		for _, r := range res {
+			urls = append(urls, r.Url)
+		}
+		return urls
+	}
+	en := FieldMatch{Name: "lang", Value: "en"}
+	news := FieldMatch{Name: "section", Value: "news"}
+	host := FieldMatch{Name: hostFilter, Value: "News.Example.com"}
+
+	// Filters restrict found documents, the title match still ranks first.
+	cts.Equal([]string{"https://news.example.com/1", "source4", "https://blog.example.com/3"}, urls("golang", en))
+	cts.Equal([]string{"https://news.example.com/1"}, urls("golang", en, news))
+	cts.Equal([]string{"https://news.example.com/1", "https://news.example.com/2"}, urls("golang", host))
+	cts.Equal([]string{"https://news.example.com/2", "https://news.example.com/1"}, urls("", host))
+	cts.Empty(urls("golang", host, FieldMatch{Name: "section", Value: "blog"}))
+
+	// Cached filters are dropped by writes.
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+		{Url: "https://news.example.com/2", Data: "golang", Source: Source{
+			Date: now.Add(time.Hour), Fields: Fields{"lang": "en", "section": "news"},
+		}},
+	}))
+	cts.Equal([]string{"https://news.example.com/1", "https://news.example.com/2"}, urls("golang", en, news))
+	n, err := cts.proc.DeleteByQuery(context.Background(), DeleteQuery{UrlPrefix: "https://news.example.com/1"})
+	cts.NoError(err)
+	cts.Equal(1, n)
+	cts.Equal([]string{"https://news.example.com/2"}, urls("golang", en, news))
+
+	for _, f := range []FieldMatch{{Name: "author", Value: "john"}, {Name: "title", Value: "golang"}} {
+		_, err := cts.proc.Search(context.Background(), SearchQuery{Text: "golang", Filters: []FieldMatch{f}})
+		cts.True(errors.Is(err, ErrInvalidField), f.Name)
+	}
+	rejected, err := cts.proc.ProcessAndInsertBestEffort(context.Background(), []RawData{
+		{Url: "source5", Data: "golang", Source: Source{Fields: Fields{hostFilter: "example.com"}}},
+	})
+	cts.NoError(err)
+	cts.Len(rejected, 1)
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_FilterCacheInvalidation() {
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+		{Url: "source1", Data: "golang", Source: Source{Fields: Fields{"lang": "en"}}},
+		{Url: "source2", Data: "golang", Source: Source{Fields: Fields{"lang": "de"}}},
+	}))
+	proc := cts.proc.(*SimpleProcessor)
+	for _, lang := range []string{"en", "de"} {
+		_, err := proc.Search(context.Background(), SearchQuery{Filters: []FieldMatch{{Name: "lang", Value: lang}}})
+		cts.NoError(err)
+	}
+	cached := func(lang string) bool {
+		_, ok := proc.filterCache.get(fieldTerm("lang", lang))
+		return ok
+	}
+	cts.True(cached("en"))
+	cts.True(cached("de"))
+
+	// Only filters with changed postings are dropped.
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+		{Url: "source3", Data: "golang", Source: Source{Fields: Fields{"lang": "de"}}},
+	}))
+	cts.True(cached("en"))
+	cts.False(cached("de"))
+}
@@ -0,0 +1,130 @@
+package collection
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/polyse/database/pkg/filters"
+)
+
+// Default tags around highlighted words.
+const (
+	defaultPreTag  = "<em>"
+	defaultPostTag = "</em>"
+)
+
+// Highlight selects stored text fields to highlight words matching the query in. The title and text fields
+// searched by match clauses of Must and Should are highlighted by default. The data of documents is not stored,
+// so it can not be highlighted.
+type Highlight struct {
+	Fields  []string `json:"fields"`
+	PreTag  string   `json:"pre_tag"`
+	PostTag string   `json:"post_tag"`
+}
+
+// highlighter keeps tokens of the query searched in every highlighted field.
+type highlighter struct {
+	fields    map[string]map[string]struct{}
+	pre, post string
+}
+
+// analyzeHighlight collects tokens of match clauses for the highlighted fields.
+func (p *SimpleProcessor) analyzeHighlight(schema *Schema, h *Highlight, clauses []Clause) (hl highlighter, err error) {
+	if h == nil {
+		return hl, nil
+	}
+	hl = highlighter{fields: make(map[string]map[string]struct{}), pre: h.PreTag, post: h.PostTag}
+	if hl.pre == "" && hl.post == "" {
+		hl.pre, hl.post = defaultPreTag, defaultPostTag
+	}
+	for _, c := range clauses {
+		if c.Match == nil {
+			continue
+		}
+		name := c.Match.Field
+		if name == "" {
+			name = titleField
+		}
+		if m, _ := schema.mapping(name); m.Type != TypeText || !m.stored() || name == dataField {
+			continue
+		}
+		if hl.fields[name] == nil {
+			hl.fields[name] = make(map[string]struct{})
+		}
+		for _, token := range p.tokenizer(c.Match.Query, p.filters...) {
+			hl.fields[name][token] = struct{}{}
+		}
+	}
+	if len(h.Fields) == 0 {
+		return hl, nil
+	}
+	selected := make(map[string]map[string]struct{}, len(h.Fields))
+	for _, name := range h.Fields {
+		if m, ok := schema.mapping(name); !ok || m.Type != TypeText || !m.stored() || name == dataField {
+			return hl, fmt.Errorf("%w: field %s can not be highlighted", ErrInvalidQuery, name)
+		}
+		selected[name] = hl.fields[name]
+	}
+	hl.fields = selected
+	return hl, nil
+}
+
+// highlight returns highlighted values of fields of the document containing words of the query.
+func (p *SimpleProcessor) highlight(hl *highlighter, s *Source) map[string][]string {
+	var res map[string][]string
+	for name, tokens := range hl.fields {
+		if len(tokens) == 0 {
+			continue
+		}
+		var values []interface{}
+		if name == titleField {
+			values = []interface{}{s.Title}
+		} else if vs, ok := s.Fields[name].([]interface{}); ok {
+			values = vs
+		} else {
+			values = []interface{}{s.Fields[name]}
+		}
+		for _, v := range values {
+			text, ok := v.(string)
+			if !ok {
+				continue
+			}
+			if text, ok = p.highlightText(text, tokens, hl.pre, hl.post); ok {
+				if res == nil {
+					res = make(map[string][]string)
+				}
+				res[name] = append(res[name], text)
+			}
+		}
+	}
+	return res
+}
+
+// highlightText wraps words of the text with tokens in the set into the tags, ok is false if no word is wrapped.
+// The text is split into words by filters.Words, the splitting of filters.FilterText, and every word
+// is analyzed separately by the tokenizer and filters of the processor.
+func (p *SimpleProcessor) highlightText(text string, tokens map[string]struct{}, pre, post string) (string, bool) {
+	var b strings.Builder
+	found := false
+	last := 0
+	for _, w := range filters.Words(text) {
+		if !p.matchesAny(w.Text, tokens) {
+			continue
+		}
+		b.WriteString(text[last:w.Start])
+		b.WriteString(pre + w.Text + post)
+		last, found = w.End, true
+	}
+	b.WriteString(text[last:])
+	return b.String(), found
+}
+
+// matchesAny reports whether any token of the analyzed word is in the set.
+func (p *SimpleProcessor) matchesAny(word string, tokens map[string]struct{}) bool {
+	for _, token := range p.tokenizer(word, p.filters...) {
+		if _, ok := tokens[token]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,378 @@
+package collection
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"math"
+	"time"
+
+	"github.com/polyse/database/internal/storage"
+)
+
+// Prefixes of storage buckets of a collection index, each bucket name ends with the collection name.
+var (
+	dataPrefix     = "d-"
+	positionPrefix = "p-"
+	sourcePrefix   = "s-"
+	datePrefix     = "t-"
+	idPrefix       = "i-"
+	urlPrefix      = "u-"
+	tokensPrefix   = "f-"
+	byDatePrefix   = "o-"
+	byExpiryPrefix = "e-"
+	sortPrefix     = "n-"
+	metaPrefix     = "m-"
+
+	idSeqKey = []byte("doc-id-seq")
+)
+
+// Lengths of encoded dates, see encodeDate, and of keys of the buckets ordered by time, see timeKey.
+const (
+	dateLen    = 12
+	timeKeyLen = dateLen + 8
+)
+
+// buckets contains names of the storage buckets of a collection index.
+type buckets struct {
+	data     string // postings by token
+	position string // positions by token and document id
+	source   string // sources by document id
+	date     string // source dates and expiration times by document id
+	id       string // document ids by url
+	url      string // urls by document id
+	tokens   string // tokens by document id, to remove the document from postings
+	byDate   string // document ids ordered by date, see timeKey
+	byExpiry string // document ids ordered by expiration time, see timeKey
+	sort     string // the least and the greatest numeric values of fields by document id and field name
+	meta     string // id sequence, schema and names of indexed fields
+}
+
+func newBuckets(colName string) buckets {
+	return buckets{
+		data:     dataPrefix + colName,
+		position: positionPrefix + colName,
+		source:   sourcePrefix + colName,
+		date:     datePrefix + colName,
+		id:       idPrefix + colName,
+		url:      urlPrefix + colName,
+		tokens:   tokensPrefix + colName,
+		byDate:   byDatePrefix + colName,
+		byExpiry: byExpiryPrefix + colName,
+		sort:     sortPrefix + colName,
+		meta:     metaPrefix + colName,
+	}
+}
+
+// index gives access to postings and sources of a collection inside a storage transaction.
+type index struct {
+	tx storage.Tx
+	b  buckets
+}
+
+// postings returns sorted ids of documents containing the token.
+func (idx *index) postings(token string) ([]uint64, error) {
+	v, err := idx.tx.Get(idx.b.data, []byte(token))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodePostings(v)
+}
+
+// putPostings replaces the postings list of the token, an empty list is deleted.
+func (idx *index) putPostings(token string, ids []uint64) error {
+	if len(ids) == 0 {
+		return idx.tx.Delete(idx.b.data, []byte(token))
+	}
+	return idx.tx.Put(idx.b.data, []byte(token), encodePostings(ids), 0)
+}
+
+// positions returns positions of the token in the document.
+func (idx *index) positions(token string, id uint64) ([]int, error) {
+	v, err := idx.tx.Get(idx.b.position, positionKey(token, id))
+	if err != nil {
+		return nil, err
+	}
+	return decodePositions(v)
+}
+
+func (idx *index) putPositions(token string, id uint64, pos []int) error {
+	return idx.tx.Put(idx.b.position, positionKey(token, id), encodePositions(pos), 0)
+}
+
+func (idx *index) deletePositions(token string, id uint64) error {
+	return idx.tx.Delete(idx.b.position, positionKey(token, id))
+}
+
+// tokens returns the tokens of the document, documents saved before tokens were stored have none.
+func (idx *index) tokens(id uint64) ([]string, error) {
+	v, err := idx.tx.Get(idx.b.tokens, encodeID(id))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeTokens(v)
+}
+
+func (idx *index) putTokens(id uint64, tokens []string) error {
+	return idx.tx.Put(idx.b.tokens, encodeID(id), encodeTokens(tokens), 0)
+}
+
+// docID returns the id assigned to the document url.
+func (idx *index) docID(url string) (uint64, bool, error) {
+	v, err := idx.tx.Get(idx.b.id, []byte(url))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	id, err := decodeID(v)
+	return id, err == nil, err
+}
+
+// assignID returns the id of the document, assigning a new one if the document has none.
+func (idx *index) assignID(url string) (uint64, error) {
+	id, ok, err := idx.docID(url)
+	if err != nil || ok {
+		return id, err
+	}
+	if id, err = idx.lastID(); err != nil {
+		return 0, err
+	}
+	id++
+	if err = idx.tx.Put(idx.b.meta, idSeqKey, encodeID(id), 0); err != nil {
+		return 0, err
+	}
+	if err = idx.tx.Put(idx.b.id, []byte(url), encodeID(id), 0); err != nil {
+		return 0, err
+	}
+	if err = idx.tx.Put(idx.b.url, encodeID(id), []byte(url), 0); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// lastID returns the last assigned id, 0 if no id is assigned yet.
+func (idx *index) lastID() (uint64, error) {
+	v, err := idx.tx.Get(idx.b.meta, idSeqKey)
+	if err == storage.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return decodeID(v)
+}
+
+// urlsAfter returns urls of at most limit documents with ids greater than after in the order of ids.
+// Storage scans are unbounded, so ids are scanned in windows of the number of missing documents up to
+// the last assigned id. A window is doubled after an empty one, so gaps of deleted documents are skipped quickly.
+func (idx *index) urlsAfter(after uint64, limit int) ([]storage.Entry, error) {
+	last, err := idx.lastID()
+	if err != nil {
+		return nil, err
+	}
+	var res []storage.Entry
+	window := uint64(limit)
+	for from := after + 1; len(res) < limit && from <= last && from > after; {
+		to := from + window - 1
+		if to > last || to < from {
+			to = last
+		}
+		es, err := idx.tx.RangeScan(idx.b.url, encodeID(from), encodeID(to))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, es...)
+		if len(es) == 0 {
+			window *= 2
+		} else {
+			window = uint64(limit - len(res))
+		}
+		from = to + 1
+	}
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// deleteDocument deletes the source, the tokens and the url of the document, postings are not changed.
+func (idx *index) deleteDocument(id uint64, url string) error {
+	if err := idx.deleteTimeKeys(id); err != nil {
+		return err
+	}
+	key := encodeID(id)
+	for _, bucket := range []string{idx.b.source, idx.b.date, idx.b.url, idx.b.tokens} {
+		if err := idx.tx.Delete(bucket, key); err != nil {
+			return err
+		}
+	}
+	return idx.tx.Delete(idx.b.id, []byte(url))
+}
+
+// ids returns sorted ids of all documents with urls starting with the prefix.
+func (idx *index) ids(urlPrefix string) ([]uint64, error) {
+	es, err := idx.tx.PrefixScan(idx.b.id, []byte(urlPrefix), -1)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint64, 0, len(es))
+	for _, e := range es {
+		id, err := decodeID(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return uniqueIDs(ids), nil
+}
+
+// url returns the url of the document with the given id.
+func (idx *index) url(id uint64) (string, error) {
+	v, err := idx.tx.Get(idx.b.url, encodeID(id))
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+func (idx *index) source(id uint64) (s Source, err error) {
+	v, err := idx.tx.Get(idx.b.source, encodeID(id))
+	if err != nil {
+		return s, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(v)).Decode(&s)
+	return s, err
+}
+
+// putSource saves the source with its date and expiration time, which are used to rank documents
+// and to skip expired ones without loading sources, see times.
+// The document is also added to the indexes ordered by date and expiration time, replacing the previous source.
+func (idx *index) putSource(id uint64, src Source) error {
+	if err := idx.deleteTimeKeys(id); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(src); err != nil {
+		return err
+	}
+	if err := idx.tx.Put(idx.b.source, encodeID(id), b.Bytes(), 0); err != nil {
+		return err
+	}
+	times := encodeDate(src.Date)
+	if src.Expires != nil {
+		times = append(times, encodeDate(*src.Expires)...)
+	}
+	if err := idx.tx.Put(idx.b.date, encodeID(id), times, 0); err != nil {
+		return err
+	}
+	if err := idx.tx.Put(idx.b.byDate, timeKey(src.Date, id), encodeID(id), 0); err != nil {
+		return err
+	}
+	if src.Expires == nil {
+		return nil
+	}
+	return idx.tx.Put(idx.b.byExpiry, timeKey(*src.Expires, id), encodeID(id), 0)
+}
+
+// deleteTimeKeys removes the saved source of the document from the indexes ordered by date and expiration time.
+func (idx *index) deleteTimeKeys(id uint64) error {
+	s, err := idx.source(id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	if err = idx.tx.Delete(idx.b.byDate, timeKey(s.Date, id)); err != nil {
+		return err
+	}
+	if s.Expires == nil {
+		return nil
+	}
+	return idx.tx.Delete(idx.b.byExpiry, timeKey(*s.Expires, id))
+}
+
+// expired returns ids of at most limit documents expired at the given time.
+func (idx *index) expired(now time.Time, limit int) ([]uint64, error) {
+	return idx.timeRange(idx.b.byExpiry, now, limit)
+}
+
+// olderThan returns ids of at most limit documents with the date before the given time.
+func (idx *index) olderThan(date time.Time, limit int) ([]uint64, error) {
+	return idx.timeRange(idx.b.byDate, date.Add(-time.Nanosecond), limit)
+}
+
+// timeRange returns ids of at most limit documents with the time key not after the given time.
+func (idx *index) timeRange(bucket string, t time.Time, limit int) ([]uint64, error) {
+	es, err := idx.tx.RangeScan(bucket, make([]byte, timeKeyLen), timeKey(t, math.MaxUint64))
+	if err != nil {
+		return nil, err
+	}
+	if limit >= 0 && len(es) > limit {
+		es = es[:limit]
+	}
+	ids := make([]uint64, 0, len(es))
+	for _, e := range es {
+		id, err := decodeID(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// date returns the date of the source, see times.
+func (idx *index) date(id uint64) (time.Time, error) {
+	date, _, err := idx.times(id)
+	return date, err
+}
+
+// times returns the date and the expiration time of the source, the expiration time is nil if the document
+// does not expire. It falls back to the source itself for sources saved before dates were stored separately.
+func (idx *index) times(id uint64) (time.Time, *time.Time, error) {
+	v, err := idx.tx.Get(idx.b.date, encodeID(id))
+	if err == nil {
+		switch len(v) {
+		case dateLen:
+			return decodeDate(v), nil, nil
+		case 2 * dateLen:
+			expires := decodeDate(v[dateLen:])
+			return decodeDate(v[:dateLen]), &expires, nil
+		}
+	}
+	s, err := idx.source(id)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	return s.Date, s.Expires, nil
+}
+
+// timeKey returns the key ordering documents by time and then by id: the encoded time and the document id.
+func timeKey(t time.Time, id uint64) []byte {
+	b := make([]byte, timeKeyLen)
+	copy(b, encodeDate(t))
+	binary.BigEndian.PutUint64(b[dateLen:], id)
+	return b
+}
+
+// encodeDate encodes the date as seconds since the epoch with the flipped sign bit followed by nanoseconds,
+// so encoded dates are ordered by time. Unlike nanoseconds since the epoch, it does not overflow for any date.
+func encodeDate(date time.Time) []byte {
+	b := make([]byte, dateLen)
+	binary.BigEndian.PutUint64(b, uint64(date.Unix())^1<<63)
+	binary.BigEndian.PutUint32(b[8:], uint32(date.Nanosecond()))
+	return b
+}
+
+func decodeDate(b []byte) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint64(b)^1<<63), int64(binary.BigEndian.Uint32(b[8:])))
+}
@@ -3,8 +3,10 @@
 package collection
 
 import (
+	"context"
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
@@ -57,3 +59,32 @@ func (spm *Manager) GetProcessor(colName string) (Processor, error) {
 	}
 	return nil, ErrCollectionNotExist
 }
+
+// RunSweeper deletes expired documents of all collections every interval until the context is done.
+// The sweeper is disabled if the interval is not positive, expired documents are kept hidden from reads then.
+func (spm *Manager) RunSweeper(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		log.Info().Msg("sweeper of expired documents is disabled")
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			spm.RLock()
+			procs := make([]Processor, 0, len(spm.processors))
+			for _, proc := range spm.processors {
+				procs = append(procs, proc)
+			}
+			spm.RUnlock()
+			for _, proc := range procs {
+				if _, err := proc.Sweep(ctx, now); err != nil && ctx.Err() == nil {
+					log.Err(err).Str("collection", proc.GetCollectionName()).Msg("can not delete expired documents")
+				}
+			}
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package collection
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/polyse/database/pkg/filters"
 
@@ -81,6 +83,7 @@ func (pts *processorManagerTestSuite) TestSimpleProcessorManager_AddProcessors()
 	pts.prm.AddProcessor(
 		NewSimpleProcessor(
 			nil,
+			ProcessorConfig{},
 			"testCollection3",
 			filters.FilterText,
 			filters.StemmAndToLower,
@@ -95,11 +98,17 @@ func (pts *processorManagerTestSuite) TestSimpleProcessorManager_GetProcessor()
 		"testCollection",
 	)
 	pts.NoError(err)
-	pts.NoError(p.ProcessAndInsertString([]RawData{{Url: "test", Data: "data"}}))
-	pts.tr.AssertCalled(pts.T(), "ProcessAndInsertString", []RawData{{Url: "test", Data: "data"}})
+	pts.NoError(p.ProcessAndInsertString(context.Background(), []RawData{{Url: "test", Data: "data"}}))
+	pts.tr.AssertCalled(pts.T(), "ProcessAndInsertString", context.Background(), []RawData{{Url: "test", Data: "data"}})
 	pts.tr2.AssertNotCalled(pts.T(), "ProcessAndInsertString", mock.Anything, mock.Anything)
 }
 
+func (pts *processorManagerTestSuite) TestSimpleProcessorManager_RunSweeperDisabled() {
+	// A sweeper without a positive interval returns at once and does not call the processors.
+	pts.prm.RunSweeper(context.Background(), 0)
+	pts.tr.AssertNotCalled(pts.T(), "Sweep", mock.Anything, mock.Anything)
+}
+
 // Processor is an autogenerated mock type for the Processor type
 type MockProcessor struct {
 	mock.Mock
@@ -119,13 +128,50 @@ func (_m *MockProcessor) GetCollectionName() string {
 	return r0
 }
 
-// ProcessAndGet provides a mock function with given fields: query
-func (_m *MockProcessor) ProcessAndGet(query string, limit, offset int) ([]ResponseData, error) {
-	ret := _m.Called(query, limit, offset)
+// Analyze provides a mock function with given fields: text
+func (_m *MockProcessor) Analyze(text string) filters.Analysis {
+	ret := _m.Called(text)
+
+	var r0 filters.Analysis
+	if rf, ok := ret.Get(0).(func(string) filters.Analysis); ok {
+		r0 = rf(text)
+	} else {
+		r0 = ret.Get(0).(filters.Analysis)
+	}
+
+	return r0
+}
+
+// ProcessAndGet provides a mock function with given fields: ctx, query, limit, offset
+func (_m *MockProcessor) ProcessAndGet(ctx context.Context, query string, limit, offset int) ([]ResponseData, error) {
+	ret := _m.Called(ctx, query, limit, offset)
+
+	var r0 []ResponseData
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, int) []ResponseData); ok {
+		r0 = rf(ctx, query, limit, offset)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]ResponseData)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int, int) error); ok {
+		r1 = rf(ctx, query, limit, offset)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Search provides a mock function with given fields: ctx, q
+func (_m *MockProcessor) Search(ctx context.Context, q SearchQuery) ([]ResponseData, error) {
+	ret := _m.Called(ctx, q)
 
 	var r0 []ResponseData
-	if rf, ok := ret.Get(0).(func(string, int, int) []ResponseData); ok {
-		r0 = rf(query, limit, offset)
+	if rf, ok := ret.Get(0).(func(context.Context, SearchQuery) []ResponseData); ok {
+		r0 = rf(ctx, q)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]ResponseData)
@@ -133,8 +179,252 @@ func (_m *MockProcessor) ProcessAndGet(query string, limit, offset int) ([]Respo
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, int, int) error); ok {
-		r1 = rf(query, limit, offset)
+	if rf, ok := ret.Get(1).(func(context.Context, SearchQuery) error); ok {
+		r1 = rf(ctx, q)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SearchStructured provides a mock function with given fields: ctx, q
+func (_m *MockProcessor) SearchStructured(ctx context.Context, q StructuredQuery) (SearchResult, error) {
+	ret := _m.Called(ctx, q)
+
+	var r0 SearchResult
+	if rf, ok := ret.Get(0).(func(context.Context, StructuredQuery) SearchResult); ok {
+		r0 = rf(ctx, q)
+	} else {
+		r0 = ret.Get(0).(SearchResult)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, StructuredQuery) error); ok {
+		r1 = rf(ctx, q)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteByQuery provides a mock function with given fields: ctx, q
+func (_m *MockProcessor) DeleteByQuery(ctx context.Context, q DeleteQuery) (int, error) {
+	ret := _m.Called(ctx, q)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, DeleteQuery) int); ok {
+		r0 = rf(ctx, q)
+	} else {
+		r0 = ret.Int(0)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, DeleteQuery) error); ok {
+		r1 = rf(ctx, q)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Schema provides a mock function with given fields: ctx
+func (_m *MockProcessor) Schema(ctx context.Context) (Schema, error) {
+	ret := _m.Called(ctx)
+
+	var r0 Schema
+	if rf, ok := ret.Get(0).(func(context.Context) Schema); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(Schema)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetSchema provides a mock function with given fields: ctx, s
+func (_m *MockProcessor) SetSchema(ctx context.Context, s Schema) error {
+	ret := _m.Called(ctx, s)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, Schema) error); ok {
+		r0 = rf(ctx, s)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Sweep provides a mock function with given fields: ctx, now
+func (_m *MockProcessor) Sweep(ctx context.Context, now time.Time) (int, error) {
+	ret := _m.Called(ctx, now)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int); ok {
+		r0 = rf(ctx, now)
+	} else {
+		r0 = ret.Int(0)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Get provides a mock function with given fields: ctx, url
+func (_m *MockProcessor) Get(ctx context.Context, url string) (ResponseData, error) {
+	ret := _m.Called(ctx, url)
+
+	var r0 ResponseData
+	if rf, ok := ret.Get(0).(func(context.Context, string) ResponseData); ok {
+		r0 = rf(ctx, url)
+	} else {
+		r0 = ret.Get(0).(ResponseData)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, url)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MultiGet provides a mock function with given fields: ctx, urls
+func (_m *MockProcessor) MultiGet(ctx context.Context, urls []string) ([]GetResult, error) {
+	ret := _m.Called(ctx, urls)
+
+	var r0 []GetResult
+	if rf, ok := ret.Get(0).(func(context.Context, []string) []GetResult); ok {
+		r0 = rf(ctx, urls)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]GetResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(ctx, urls)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// List provides a mock function with given fields: ctx, cursor, limit
+func (_m *MockProcessor) List(ctx context.Context, cursor string, limit int) (DocumentPage, error) {
+	ret := _m.Called(ctx, cursor, limit)
+
+	var r0 DocumentPage
+	if rf, ok := ret.Get(0).(func(context.Context, string, int) DocumentPage); ok {
+		r0 = rf(ctx, cursor, limit)
+	} else {
+		r0 = ret.Get(0).(DocumentPage)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int) error); ok {
+		r1 = rf(ctx, cursor, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Scroll provides a mock function with given fields: ctx, scrollID, limit, keepAlive
+func (_m *MockProcessor) Scroll(ctx context.Context, scrollID string, limit int, keepAlive time.Duration) (ScrollPage, error) {
+	ret := _m.Called(ctx, scrollID, limit, keepAlive)
+
+	var r0 ScrollPage
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, time.Duration) ScrollPage); ok {
+		r0 = rf(ctx, scrollID, limit, keepAlive)
+	} else {
+		r0 = ret.Get(0).(ScrollPage)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int, time.Duration) error); ok {
+		r1 = rf(ctx, scrollID, limit, keepAlive)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ClearScroll provides a mock function with given fields: scrollID
+func (_m *MockProcessor) ClearScroll(scrollID string) bool {
+	ret := _m.Called(scrollID)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string) bool); ok {
+		r0 = rf(scrollID)
+	} else {
+		r0 = ret.Bool(0)
+	}
+
+	return r0
+}
+
+// ProcessAndInsertBestEffort provides a mock function with given fields: ctx, data
+func (_m *MockProcessor) ProcessAndInsertBestEffort(ctx context.Context, data []RawData) ([]DocumentError, error) {
+	ret := _m.Called(ctx, data)
+
+	var r0 []DocumentError
+	if rf, ok := ret.Get(0).(func(context.Context, []RawData) []DocumentError); ok {
+		r0 = rf(ctx, data)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]DocumentError)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []RawData) error); ok {
+		r1 = rf(ctx, data)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ApplyActions provides a mock function with given fields: ctx, actions
+func (_m *MockProcessor) ApplyActions(ctx context.Context, actions []Action) ([]ActionResult, error) {
+	ret := _m.Called(ctx, actions)
+
+	var r0 []ActionResult
+	if rf, ok := ret.Get(0).(func(context.Context, []Action) []ActionResult); ok {
+		r0 = rf(ctx, actions)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]ActionResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []Action) error); ok {
+		r1 = rf(ctx, actions)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -142,13 +432,13 @@ func (_m *MockProcessor) ProcessAndGet(query string, limit, offset int) ([]Respo
 	return r0, r1
 }
 
-// ProcessAndInsertString provides a mock function with given fields: data
-func (_m *MockProcessor) ProcessAndInsertString(data []RawData) error {
-	ret := _m.Called(data)
+// ProcessAndInsertString provides a mock function with given fields: ctx, data
+func (_m *MockProcessor) ProcessAndInsertString(ctx context.Context, data []RawData) error {
+	ret := _m.Called(ctx, data)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func([]RawData) error); ok {
-		r0 = rf(data)
+	if rf, ok := ret.Get(0).(func(context.Context, []RawData) error); ok {
+		r0 = rf(ctx, data)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -0,0 +1,279 @@
+package collection
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"strings"
+
+	"github.com/polyse/database/internal/storage"
+	"github.com/rs/zerolog/log"
+	"github.com/xujiajun/nutsdb"
+)
+
+var (
+	// legacySourceBucket is the bucket with sources keyed by url shared by all collections in the legacy layout.
+	legacySourceBucket = "sources"
+	// stagingPrefix is the prefix of sets with legacy postings of a collection keyed by url, see stageLegacyPostings.
+	stagingPrefix = "l-"
+	// migrateBatchSize limits the number of legacy postings or documents converted in one transaction.
+	migrateBatchSize = 1000
+)
+
+// Migrate converts data of the collection saved in the legacy layout to the current one.
+// The legacy layout keeps postings of the collection as sets of gob encoded WordInfo in the data bucket
+// and sources of all collections keyed by url in the shared sources bucket.
+// Every document found in postings of the collection is saved again with its legacy source, so it gets an id,
+// its title and host are indexed and it is added to the date order. Legacy sources which are not found
+// in postings of any collection belong to documents indexed without tokens, they are saved to the first
+// migrated collection. A legacy source is removed once no collection has postings of its url left.
+// Data is converted in transactions of at most migrateBatchSize postings or documents, so an interrupted
+// migration continues from the last finished batch. Data already in the current layout is left untouched.
+// Only nutsdb storage can contain old data.
+func (p *SimpleProcessor) Migrate() error {
+	nuts, ok := p.store.(*storage.Nuts)
+	if !ok {
+		return nil
+	}
+	db := nuts.DB()
+	if err := stageLegacyPostings(db); err != nil {
+		return err
+	}
+	staging := stagingPrefix + p.colName
+	urls := stagedURLs(db, staging)
+	if len(urls) > 0 {
+		log.Info().Str("collection", p.colName).Int("documents", len(urls)).Msg("migrating legacy documents")
+	}
+	for len(urls) > 0 {
+		batch := urls
+		if len(batch) > migrateBatchSize {
+			batch = batch[:migrateBatchSize]
+		}
+		urls = urls[len(batch):]
+		if err := db.Update(func(tx *nutsdb.Tx) error {
+			return p.migrateDocuments(db, tx, batch)
+		}); err != nil {
+			return err
+		}
+	}
+	return p.migrateOrphans(db)
+}
+
+// stageLegacyPostings moves legacy postings of all collections to staging sets, which keep tokens
+// and positions of every document of the collection by its url. Documents of other collections are staged too,
+// so a legacy source not found in staging sets has no postings in any collection.
+func stageLegacyPostings(db *nutsdb.DB) error {
+	type setKey struct {
+		bucket, token string
+	}
+	var keys []setKey
+	for bucket, set := range db.SetIdx {
+		if !strings.HasPrefix(bucket, dataPrefix) {
+			continue
+		}
+		for token, members := range set.M {
+			if len(members) > 0 {
+				keys = append(keys, setKey{bucket: bucket, token: token})
+			}
+		}
+	}
+	if len(keys) > 0 {
+		log.Info().Int("tokens", len(keys)).Msg("staging legacy postings")
+	}
+	for len(keys) > 0 {
+		err := db.Update(func(tx *nutsdb.Tx) error {
+			for staged := 0; staged < migrateBatchSize && len(keys) > 0; keys = keys[1:] {
+				k := keys[0]
+				members, err := tx.SMembers(k.bucket, []byte(k.token))
+				if err != nil {
+					return err
+				}
+				staging := stagingPrefix + strings.TrimPrefix(k.bucket, dataPrefix)
+				for _, m := range members {
+					var w WordInfo
+					if err = gob.NewDecoder(bytes.NewReader(m)).Decode(&w); err != nil {
+						return err
+					}
+					if err = tx.SAdd(staging, []byte(w.Url), stagedPosting(k.token, w.Pos)); err != nil {
+						return err
+					}
+				}
+				if err = tx.SRem(k.bucket, []byte(k.token), members...); err != nil {
+					return err
+				}
+				staged += len(members)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateDocuments saves documents with the urls with their staged postings and legacy sources.
+// Documents without a legacy source are skipped.
+func (p *SimpleProcessor) migrateDocuments(db *nutsdb.DB, tx *nutsdb.Tx, urls []string) error {
+	staging := stagingPrefix + p.colName
+	idx := p.index(storage.NewNutsTx(tx))
+	batch := make([]document, 0, len(urls))
+	for _, url := range urls {
+		members, err := tx.SMembers(staging, []byte(url))
+		if err != nil {
+			return err
+		}
+		doc := document{raw: RawData{Url: url}, tokens: make(map[string][]int, len(members))}
+		for _, m := range members {
+			token, pos, err := parseStagedPosting(m)
+			if err != nil {
+				return err
+			}
+			doc.tokens[token] = pos
+		}
+		if err = tx.SRem(staging, []byte(url), members...); err != nil {
+			return err
+		}
+		ok, err := p.legacyDocument(idx, &doc, !stagedElsewhere(db, staging, url))
+		if err != nil {
+			return err
+		}
+		if ok {
+			batch = append(batch, doc)
+		}
+	}
+	return p.writeLegacy(idx, batch)
+}
+
+// migrateOrphans saves documents of legacy sources not found in postings of any collection.
+func (p *SimpleProcessor) migrateOrphans(db *nutsdb.DB) error {
+	var urls []string
+	err := db.View(func(tx *nutsdb.Tx) error {
+		entries, err := tx.GetAll(legacySourceBucket)
+		if err != nil {
+			if err == nutsdb.ErrBucketEmpty {
+				return nil
+			}
+			return err
+		}
+		for _, e := range entries {
+			if url := string(e.Key); !stagedElsewhere(db, "", url) {
+				urls = append(urls, url)
+			}
+		}
+		return nil
+	})
+	if err != nil || len(urls) == 0 {
+		return err
+	}
+	log.Info().Str("collection", p.colName).Int("documents", len(urls)).Msg("migrating legacy sources without postings")
+	for len(urls) > 0 {
+		batch := urls
+		if len(batch) > migrateBatchSize {
+			batch = batch[:migrateBatchSize]
+		}
+		urls = urls[len(batch):]
+		if err = db.Update(func(tx *nutsdb.Tx) error {
+			idx := p.index(storage.NewNutsTx(tx))
+			docs := make([]document, 0, len(batch))
+			for _, url := range batch {
+				doc := document{raw: RawData{Url: url}, tokens: make(map[string][]int)}
+				ok, err := p.legacyDocument(idx, &doc, true)
+				if err != nil {
+					return err
+				}
+				if ok {
+					docs = append(docs, doc)
+				}
+			}
+			return p.writeLegacy(idx, docs)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyDocument loads the legacy source of the document and adds terms of its title and host.
+// The legacy source is removed if remove is set. It returns false if the document has no legacy source
+// or is already saved in the current layout.
+func (p *SimpleProcessor) legacyDocument(idx *index, doc *document, remove bool) (bool, error) {
+	url := doc.raw.Url
+	v, err := idx.tx.Get(legacySourceBucket, []byte(url))
+	if err == storage.ErrNotFound {
+		log.Warn().Str("collection", p.colName).Str("url", url).Msg("legacy document has no source")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err = gob.NewDecoder(bytes.NewReader(v)).Decode(&doc.raw.Source); err != nil {
+		return false, err
+	}
+	if remove {
+		if err = idx.tx.Delete(legacySourceBucket, []byte(url)); err != nil {
+			return false, err
+		}
+	}
+	if _, ok, err := idx.docID(url); err != nil || ok {
+		return false, err
+	}
+	for term, pos := range p.textTerms(titleField, doc.raw.Title) {
+		doc.tokens[term] = pos
+	}
+	for term, pos := range hostTerms(url) {
+		doc.tokens[term] = pos
+	}
+	return true, nil
+}
+
+// writeLegacy saves the legacy documents to the index.
+func (p *SimpleProcessor) writeLegacy(idx *index, docs []document) error {
+	w := newBatchWriter(idx)
+	if _, err := w.write(context.Background(), docs, 0); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// stagedURLs returns urls of documents with staged postings in the bucket.
+func stagedURLs(db *nutsdb.DB, bucket string) []string {
+	set, ok := db.SetIdx[bucket]
+	if !ok {
+		return nil
+	}
+	urls := make([]string, 0, len(set.M))
+	for url, members := range set.M {
+		if len(members) > 0 {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// stagedElsewhere reports whether a staging bucket other than the given one has postings of the url.
+func stagedElsewhere(db *nutsdb.DB, bucket, url string) bool {
+	for name, set := range db.SetIdx {
+		if name == bucket || !strings.HasPrefix(name, stagingPrefix) {
+			continue
+		}
+		if len(set.M[url]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// stagedPosting encodes the token and its positions as a member of a staging set.
+func stagedPosting(token string, pos []int) []byte {
+	return append(append([]byte(token), 0), encodePositions(pos)...)
+}
+
+func parseStagedPosting(b []byte) (string, []int, error) {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return "", nil, errCorruptedPostings
+	}
+	pos, err := decodePositions(b[i+1:])
+	return string(b[:i]), pos, err
+}
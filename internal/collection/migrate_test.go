@@ -0,0 +1,201 @@
+package collection
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"time"
+
+	"github.com/polyse/database/pkg/filters"
+	"github.com/xujiajun/nutsdb"
+)
+
+func (cts *processorTestSuite) TestSimpleProcessor_Migrate() {
+	if cts.memory {
+		cts.T().Skip("only nutsdb storage can contain old data")
+	}
+	now := time.Now()
+	legacy := map[string][]WordInfo{
+		"data1": {{Url: "source1", Pos: []int{0}}},
+		"data2": {{Url: "source1", Pos: []int{1}}, {Url: "source2", Pos: []int{0}}},
+		"data3": {{Url: "http://Example.com:8080/source3", Pos: []int{0}}},
+	}
+	cts.NoError(cts.nutsDb.Update(func(tx *nutsdb.Tx) error {
+		for key, infos := range legacy {
+			for _, info := range infos {
+				var b bytes.Buffer
+				if err := gob.NewEncoder(&b).Encode(info); err != nil {
+					return err
+				}
+				if err := tx.SAdd(dataPrefix+nutColl, []byte(key), b.Bytes()); err != nil {
+					return err
+				}
+			}
+		}
+		for _, url := range []string{"source1", "source2", "http://Example.com:8080/source3"} {
+			var b bytes.Buffer
+			if err := gob.NewEncoder(&b).Encode(Source{Date: now, Title: url}); err != nil {
+				return err
+			}
+			if err := tx.Put(legacySourceBucket, []byte(url), b.Bytes(), 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	proc := cts.proc.(*SimpleProcessor)
+	cts.NoError(proc.Migrate())
+	cts.Equal(map[string][]int{"source1": {0}}, cts.postings("data1"))
+	cts.Equal(map[string][]int{"source1": {1}, "source2": {0}}, cts.postings("data2"))
+
+	res, err := proc.ProcessAndGet(context.Background(), "data1 data2", 10, 0)
+	cts.NoError(err)
+	cts.Equal([]ResponseData{{Url: "source1", Source: Source{Date: now.Round(1 * time.Nanosecond), Title: "source1", Version: 1}}}, res)
+
+	// Titles of migrated documents are searchable.
+	res, err = proc.ProcessAndGet(context.Background(), "title:source2", 10, 0)
+	cts.NoError(err)
+	cts.Equal([]ResponseData{{Url: "source2", Source: Source{Date: now.Round(1 * time.Nanosecond), Title: "source2", Version: 1}}}, res)
+
+	// Hosts of migrated documents can be filtered.
+	res, err = proc.Search(context.Background(), SearchQuery{Filters: []FieldMatch{{Name: hostFilter, Value: "example.com"}}})
+	cts.NoError(err)
+	cts.Len(res, 1)
+	cts.Equal("http://Example.com:8080/source3", res[0].Url)
+
+	cts.NoError(proc.Migrate())
+	cts.Equal(map[string][]int{"source1": {1}, "source2": {0}}, cts.postings("data2"))
+
+	// Tokens of migrated documents are restored, so an update replaces their postings.
+	update := []RawData{{Url: "source1", Data: "data3", Source: Source{Date: now, Title: "source1"}}}
+	cts.NoError(proc.ProcessAndInsertString(context.Background(), update))
+	cts.Empty(cts.postings("data1"))
+	cts.Equal(map[string][]int{"source2": {0}}, cts.postings("data2"))
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_MigrateSharedSources() {
+	if cts.memory {
+		cts.T().Skip("only nutsdb storage can contain old data")
+	}
+	const otherColl = "otherCollection"
+	now := time.Now()
+	cts.NoError(cts.nutsDb.Update(func(tx *nutsdb.Tx) error {
+		legacy := []struct {
+			coll string
+			info WordInfo
+		}{
+			{nutColl, WordInfo{Url: "source1", Pos: []int{0}}},
+			{otherColl, WordInfo{Url: "source2", Pos: []int{0}}},
+		}
+		for _, l := range legacy {
+			var b bytes.Buffer
+			if err := gob.NewEncoder(&b).Encode(l.info); err != nil {
+				return err
+			}
+			if err := tx.SAdd(dataPrefix+l.coll, []byte("data1"), b.Bytes()); err != nil {
+				return err
+			}
+			var s bytes.Buffer
+			if err := gob.NewEncoder(&s).Encode(Source{Date: now, Title: l.info.Url}); err != nil {
+				return err
+			}
+			if err := tx.Put(legacySourceBucket, []byte(l.info.Url), s.Bytes(), 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	// Every collection takes only sources of its own documents.
+	proc := cts.proc.(*SimpleProcessor)
+	cts.NoError(proc.Migrate())
+	res, err := proc.ProcessAndGet(context.Background(), "data1", 10, 0)
+	cts.NoError(err)
+	cts.Equal([]ResponseData{{Url: "source1", Source: Source{Date: now.Round(1 * time.Nanosecond), Title: "source1", Version: 1}}}, res)
+	cts.Equal(uint64(1), cts.documents(proc))
+
+	other := NewSimpleProcessor(
+		cts.store,
+		ProcessorConfig{Workers: 2, ChunkSize: 2},
+		Name(otherColl),
+		filters.FilterText,
+		filters.StemmAndToLower,
+	)
+	cts.NoError(other.Migrate())
+	res, err = other.ProcessAndGet(context.Background(), "data1", 10, 0)
+	cts.NoError(err)
+	cts.Equal([]ResponseData{{Url: "source2", Source: Source{Date: now.Round(1 * time.Nanosecond), Title: "source2", Version: 1}}}, res)
+	cts.Equal(uint64(1), cts.documents(other))
+
+	// Legacy sources are removed after the last collection is migrated.
+	cts.NoError(cts.nutsDb.View(func(tx *nutsdb.Tx) error {
+		entries, err := tx.GetAll(legacySourceBucket)
+		if err != nutsdb.ErrBucketEmpty {
+			cts.Empty(entries)
+		}
+		return nil
+	}))
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_MigrateWithoutPostings() {
+	if cts.memory {
+		cts.T().Skip("only nutsdb storage can contain old data")
+	}
+	now := time.Now()
+	cts.NoError(cts.nutsDb.Update(func(tx *nutsdb.Tx) error {
+		var b bytes.Buffer
+		if err := gob.NewEncoder(&b).Encode(Source{Date: now, Title: "empty"}); err != nil {
+			return err
+		}
+		return tx.Put(legacySourceBucket, []byte("source1"), b.Bytes(), 0)
+	}))
+
+	// Documents indexed without tokens keep their sources.
+	proc := cts.proc.(*SimpleProcessor)
+	cts.NoError(proc.Migrate())
+	cts.Equal(uint64(1), cts.documents(proc))
+	res, err := proc.ProcessAndGet(context.Background(), "title:empty", 10, 0)
+	cts.NoError(err)
+	cts.Equal([]ResponseData{{Url: "source1", Source: Source{Date: now.Round(1 * time.Nanosecond), Title: "empty", Version: 1}}}, res)
+
+	cts.NoError(proc.Migrate())
+	cts.Equal(uint64(1), cts.documents(proc))
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_MigrateBatches() {
+	if cts.memory {
+		cts.T().Skip("only nutsdb storage can contain old data")
+	}
+	defer func(size int) { migrateBatchSize = size }(migrateBatchSize)
+	migrateBatchSize = 2
+
+	now := time.Now()
+	urls := []string{"source1", "source2", "source3", "source4", "source5"}
+	cts.NoError(cts.nutsDb.Update(func(tx *nutsdb.Tx) error {
+		for i, url := range urls {
+			var b bytes.Buffer
+			if err := gob.NewEncoder(&b).Encode(WordInfo{Url: url, Pos: []int{i}}); err != nil {
+				return err
+			}
+			if err := tx.SAdd(dataPrefix+nutColl, []byte("data1"), b.Bytes()); err != nil {
+				return err
+			}
+			var s bytes.Buffer
+			if err := gob.NewEncoder(&s).Encode(Source{Date: now, Title: url}); err != nil {
+				return err
+			}
+			if err := tx.Put(legacySourceBucket, []byte(url), s.Bytes(), 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	// Migration continues after it is interrupted once postings are staged.
+	cts.NoError(stageLegacyPostings(cts.nutsDb))
+	proc := cts.proc.(*SimpleProcessor)
+	cts.NoError(proc.Migrate())
+	cts.Equal(uint64(len(urls)), cts.documents(proc))
+	cts.Equal(map[string][]int{"source1": {0}, "source2": {1}, "source3": {2}, "source4": {3}, "source5": {4}}, cts.postings("data1"))
+}
@@ -0,0 +1,294 @@
+package collection
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/polyse/database/internal/storage"
+)
+
+// numericTermPrefix starts tokens of numeric values of fields. The token of a value ends with the value encoded
+// so that the order of tokens is the order of values, see encodeFloat. Numeric tokens have no postings:
+// the document id is appended to the token to make a key in the postings bucket, so documents are found
+// by scanning a range of keys.
+const numericTermPrefix = "\x02"
+
+// numericTerm returns the token of the numeric value of the field.
+func numericTerm(name string, v float64) string {
+	return numericFieldPrefix(name) + string(encodeFloat(v))
+}
+
+// numericFieldPrefix returns the common prefix of numeric tokens of the field.
+func numericFieldPrefix(name string) string {
+	return numericTermPrefix + name + ":"
+}
+
+func isNumericTerm(token string) bool {
+	return strings.HasPrefix(token, numericTermPrefix)
+}
+
+// encodeFloat encodes the value into 8 bytes ordered as the values: the sign bit of positive values is set,
+// all bits of negative values are flipped.
+func encodeFloat(v float64) []byte {
+	bits := math.Float64bits(v)
+	if bits>>63 == 1 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, bits)
+	return b
+}
+
+func decodeFloat(b []byte) float64 {
+	bits := binary.BigEndian.Uint64(b)
+	if bits>>63 == 1 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+// numericValue returns the numeric value of the normalized value of the field.
+// Dates are numbers of seconds since the epoch, values of other types are not numeric.
+func (m FieldMapping) numericValue(v interface{}) (float64, bool) {
+	switch m.Type {
+	case TypeDate:
+		t, err := parseDate(v.(string))
+		if err != nil {
+			return 0, false
+		}
+		return dateSeconds(t), true
+	case TypeInteger, TypeFloat, "":
+		f, ok := v.(float64)
+		return f, ok
+	default:
+		return 0, false
+	}
+}
+
+// dateSeconds returns the number of seconds since the epoch, unlike UnixNano it does not overflow for any date.
+func dateSeconds(t time.Time) float64 {
+	return float64(t.Unix()) + float64(t.Nanosecond())/1e9
+}
+
+// parseNumber parses the bound of a range on the field, the empty bound or * is open.
+func (m FieldMapping) parseNumber(s string) (v float64, open bool, err error) {
+	if s == "" || s == "*" {
+		return 0, true, nil
+	}
+	if m.Type == TypeDate {
+		t, err := parseDate(s)
+		if err != nil {
+			return 0, false, err
+		}
+		return dateSeconds(t), false, nil
+	}
+	v, err = strconv.ParseFloat(s, 64)
+	return v, false, err
+}
+
+// FieldRange matches documents with a numeric or date value of the field between From and To.
+// An empty bound is open, bounds are included if IncludeFrom and IncludeTo are set.
+type FieldRange struct {
+	Name        string
+	From        string
+	To          string
+	IncludeFrom bool
+	IncludeTo   bool
+}
+
+// numericRange is a range of encoded values of a field.
+type numericRange struct {
+	prefix   string
+	from, to float64
+	// openFrom and openTo are set for open bounds, the bounds are included unless the range is exclusive.
+	openFrom, openTo bool
+	exclFrom, exclTo bool
+}
+
+// checkNumeric checks that the field can have numeric values, the error wraps ErrInvalidField
+// and describes the operation on the field.
+func (s *Schema) checkNumeric(name, operation string) (FieldMapping, error) {
+	m, _ := s.mapping(name)
+	if m.Type != "" && m.Type != TypeInteger && m.Type != TypeFloat && m.Type != TypeDate {
+		return m, fmt.Errorf("%w: %s on %s field %s", ErrInvalidField, operation, m.Type, name)
+	}
+	return m, nil
+}
+
+// numericRange checks bounds of the range on the field, the error wraps ErrInvalidField.
+func (s *Schema) numericRange(r FieldRange) (nr numericRange, err error) {
+	m, err := s.checkNumeric(r.Name, "range")
+	if err != nil {
+		return nr, err
+	}
+	nr = numericRange{prefix: numericFieldPrefix(r.Name), exclFrom: !r.IncludeFrom, exclTo: !r.IncludeTo}
+	if nr.from, nr.openFrom, err = m.parseNumber(r.From); err != nil {
+		return nr, fmt.Errorf("%w: range on %s: %s", ErrInvalidField, r.Name, err)
+	}
+	if nr.to, nr.openTo, err = m.parseNumber(r.To); err != nil {
+		return nr, fmt.Errorf("%w: range on %s: %s", ErrInvalidField, r.Name, err)
+	}
+	return nr, nil
+}
+
+// keys returns the first and the last keys of the postings bucket the range can contain.
+func (r numericRange) keys() (start, end []byte) {
+	start = append([]byte(r.prefix), make([]byte, 8)...)
+	if !r.openFrom {
+		start = append([]byte(r.prefix), encodeFloat(r.from)...)
+	}
+	end = append([]byte(r.prefix), encodeFloat(math.Inf(1))...)
+	if !r.openTo {
+		end = append([]byte(r.prefix), encodeFloat(r.to)...)
+	}
+	return start, append(end, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+}
+
+// contains reports whether the value is in the range.
+func (r numericRange) contains(v float64) bool {
+	if !r.openFrom && (v < r.from || r.exclFrom && v == r.from) {
+		return false
+	}
+	return r.openTo || v < r.to || !r.exclTo && v == r.to
+}
+
+// containsToken reports whether the numeric token of the field is in the range.
+func (r numericRange) containsToken(token string) bool {
+	return strings.HasPrefix(token, r.prefix) && len(token) == len(r.prefix)+8 &&
+		r.contains(decodeFloat([]byte(token[len(r.prefix):])))
+}
+
+// numericIDs returns sorted ids of documents with a value of the field in the range.
+func (idx *index) numericIDs(r numericRange) ([]uint64, error) {
+	start, end := r.keys()
+	es, err := idx.tx.RangeScan(idx.b.data, start, end)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint64, 0, len(es))
+	for _, e := range es {
+		if len(e.Key) != len(r.prefix)+16 || !r.contains(decodeFloat(e.Key[len(r.prefix):len(r.prefix)+8])) {
+			continue
+		}
+		id, err := decodeID(e.Key[len(r.prefix)+8:])
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return uniqueIDs(ids), nil
+}
+
+// putNumeric adds the document to the ordered values of the field.
+func (idx *index) putNumeric(token string, id uint64) error {
+	return idx.tx.Put(idx.b.data, numericKey(token, id), []byte{}, 0)
+}
+
+func (idx *index) deleteNumeric(token string, id uint64) error {
+	return idx.tx.Delete(idx.b.data, numericKey(token, id))
+}
+
+func numericKey(token string, id uint64) []byte {
+	return append([]byte(token), encodeID(id)...)
+}
+
+// SortField orders search results by the numeric or date field, documents without the field are the last.
+// If the field has several values, the least one is used in ascending order and the greatest one in descending order.
+type SortField struct {
+	Name string `json:"field" validate:"required"`
+	Desc bool   `json:"desc"`
+}
+
+// ParseSort parses comma separated names of fields to sort by, a name prefixed by - sorts in descending order:
+//    -rating,published
+func ParseSort(s string) []SortField {
+	var res []SortField
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		desc := strings.HasPrefix(name, "-")
+		if name = strings.TrimPrefix(name, "-"); name != "" {
+			res = append(res, SortField{Name: name, Desc: desc})
+		}
+	}
+	return res
+}
+
+// numericField returns the name of the field of the numeric token.
+func numericField(token string) string {
+	return token[len(numericTermPrefix) : len(token)-9]
+}
+
+// putSortValues saves the least and the greatest values of every numeric field of the document,
+// so documents are sorted without loading their tokens.
+func (idx *index) putSortValues(id uint64, tokens map[string][]int) error {
+	bounds := make(map[string][2]float64)
+	for token := range tokens {
+		if !isNumericTerm(token) {
+			continue
+		}
+		name, v := numericField(token), decodeFloat([]byte(token[len(token)-8:]))
+		b, ok := bounds[name]
+		if !ok {
+			b = [2]float64{v, v}
+		}
+		bounds[name] = [2]float64{math.Min(b[0], v), math.Max(b[1], v)}
+	}
+	for name, b := range bounds {
+		v := append(encodeFloat(b[0]), encodeFloat(b[1])...)
+		if err := idx.tx.Put(idx.b.sort, sortKey(id, name), v, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSortValues deletes values of numeric fields of the document saved with the tokens.
+func (idx *index) deleteSortValues(id uint64, tokens []string) error {
+	deleted := make(map[string]struct{})
+	for _, token := range tokens {
+		if !isNumericTerm(token) {
+			continue
+		}
+		name := numericField(token)
+		if _, ok := deleted[name]; ok {
+			continue
+		}
+		deleted[name] = struct{}{}
+		if err := idx.tx.Delete(idx.b.sort, sortKey(id, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortValues returns values of the sort fields of the document. Values are negated for ascending order
+// and missing values are -Inf, so the greater values are always better.
+func (idx *index) sortValues(id uint64, fields []SortField) ([]float64, error) {
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		v, err := idx.tx.Get(idx.b.sort, sortKey(id, f.Name))
+		switch {
+		case err == storage.ErrNotFound:
+			values[i] = math.Inf(-1)
+		case err != nil:
+			return nil, err
+		case f.Desc:
+			values[i] = decodeFloat(v[8:])
+		default:
+			values[i] = -decodeFloat(v[:8])
+		}
+	}
+	return values, nil
+}
+
+func sortKey(id uint64, name string) []byte {
+	return append(encodeID(id), name...)
+}
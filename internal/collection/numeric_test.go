@@ -0,0 +1,121 @@
+package collection
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeFloat(t *testing.T) {
+	values := []float64{math.Inf(-1), -1e10, -2.5, -1, 0, 1e-10, 1, 2.5, 100, 1e10, math.Inf(1)}
+	for i, v := range values {
+		assert.Equal(t, v, decodeFloat(encodeFloat(v)))
+		if i > 0 {
+			assert.Equal(t, -1, bytes.Compare(encodeFloat(values[i-1]), encodeFloat(v)), "%v < %v", values[i-1], v)
+		}
+	}
+}
+
+func TestFieldMapping_NumericDate(t *testing.T) {
+	m := FieldMapping{Type: TypeDate}
+	for _, d := range []string{"1500-01-01T00:00:00Z", "3000-01-01T00:00:00Z"} {
+		v, ok := m.numericValue(d)
+		assert.True(t, ok)
+		to, _, err := m.parseNumber(d)
+		assert.NoError(t, err)
+		assert.Equal(t, to, v)
+	}
+	early, _ := m.numericValue("1500-01-01T00:00:00Z")
+	late, _ := m.numericValue("3000-01-01T00:00:00Z")
+	assert.Less(t, early, late)
+}
+
+func TestParseSort(t *testing.T) {
+	assert.Equal(t, []SortField{{Name: "rating", Desc: true}, {Name: "published"}}, ParseSort("-rating, published,"))
+	assert.Nil(t, ParseSort(""))
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_NumericRanges() {
+	cts.NoError(cts.proc.SetSchema(context.Background(), Schema{Fields: map[string]FieldMapping{
+		"words":     {Type: TypeInteger},
+		"rating":    {Type: TypeFloat},
+		"published": {Type: TypeDate},
+		"lang":      {Type: TypeKeyword},
+	}}))
+	saveData := []RawData{
+		{Url: "source1", Data: "data1", Source: Source{Fields: Fields{
+			"words": 100, "rating": 4.5, "published": "2020-01-01", "lang": "en",
+		}}},
+		{Url: "source2", Data: "data1 data2", Source: Source{Fields: Fields{
+			"words": 250, "rating": -1.5, "published": "2020-06-01T12:00:00Z",
+		}}},
+		{Url: "source3", Data: "data2", Source: Source{Fields: Fields{
+			"words": []interface{}{10, 1000}, "published": "2021-01-01",
+		}}},
+		{Url: "source4", Data: "data1", Source: Source{Fields: Fields{"pages": 12}}},
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+
+	search := func(q SearchQuery) []string {
+		res, err := cts.proc.Search(context.Background(), q)
+		cts.NoError(err)
+		var urls []string
+		for _, r := range res {
+			urls = append(urls, r.Url)
+		}
+		return urls
+	}
+	urls := func(query string) []string {
+		return search(ParseQuery(query))
+	}
+	cts.ElementsMatch([]string{"source1", "source2"}, urls("words:[100 TO 250]"))
+	cts.ElementsMatch([]string{"source2"}, urls("words:{100 TO 250]"))
+	cts.ElementsMatch([]string{"source1"}, urls("words:[100 TO 250}"))
+	cts.ElementsMatch([]string{"source2", "source3"}, urls("words:[200 TO *]"))
+	cts.ElementsMatch([]string{"source1", "source3"}, urls("words:[* TO 100]"))
+	cts.ElementsMatch([]string{"source2"}, urls("rating:[-2 TO 0]"))
+	cts.ElementsMatch([]string{"source1", "source2"}, urls("published:[2020-01-01 TO 2020-12-31]"))
+	cts.ElementsMatch([]string{"source2", "source3"}, urls("published:{2020-01-01 TO *]"))
+	cts.ElementsMatch([]string{"source4"}, urls("pages:[10 TO 20]"))
+	cts.ElementsMatch([]string{"source1"}, urls("data1 words:[* TO 200] lang:en"))
+	cts.ElementsMatch([]string{"source2", "source3"}, urls("data2 words:[200 TO *]"))
+	cts.Empty(urls("words:[300 TO 900]"))
+
+	// Documents without the sort field are the last.
+	cts.Equal([]string{"source1", "source2", "source4"}, search(SearchQuery{
+		Text: "data1", Sort: ParseSort("-rating"),
+	}))
+	cts.Equal([]string{"source2", "source1", "source4"}, search(SearchQuery{
+		Text: "data1", Sort: ParseSort("rating"),
+	}))
+	cts.Equal([]string{"source3", "source1", "source2"}, search(SearchQuery{
+		Ranges: []FieldRange{{Name: "words", IncludeFrom: true, IncludeTo: true}}, Sort: ParseSort("words"),
+	}))
+	cts.Equal([]string{"source3", "source2"}, search(SearchQuery{
+		Ranges: []FieldRange{{Name: "words", IncludeFrom: true, IncludeTo: true}}, Sort: ParseSort("-words"), Limit: 2,
+	}))
+
+	for _, query := range []string{"lang:[a TO b]", "words:[a TO 10]", "published:[2020 TO *]"} {
+		_, err := cts.proc.ProcessAndGet(context.Background(), query, 10, 0)
+		cts.True(errors.Is(err, ErrInvalidField), query)
+	}
+
+	// Updated and deleted documents are removed from ranges.
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+		{Url: "source2", Data: "data1 data2", Source: Source{Fields: Fields{"words": 50}}},
+	}))
+	cts.ElementsMatch([]string{"source1", "source2", "source3"}, urls("words:[* TO 100]"))
+	cts.ElementsMatch([]string{"source3"}, urls("words:[200 TO *]"))
+	cts.Empty(urls("rating:[-2 TO 0]"))
+	cts.Equal([]string{"source1", "source2", "source4"}, search(SearchQuery{
+		Text: "data1", Sort: ParseSort("rating"),
+	}))
+	n, err := cts.proc.DeleteByQuery(context.Background(), DeleteQuery{Query: "words:[500 TO *]"})
+	cts.NoError(err)
+	cts.Equal(1, n)
+	cts.ElementsMatch([]string{"source1", "source2"}, urls("words:[* TO 100]"))
+}
@@ -0,0 +1,228 @@
+package collection
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// postingsVersion is the version of the postings encoding written by this package.
+//
+// Encoded postings list layout:
+//    [version byte][uvarint count][uvarint first id][uvarint delta]...
+// Document ids are sorted in ascending order, each id is stored as the delta from the previous one.
+// Positions of a term in a document are stored separately under the positionKey,
+// encoded the same way without the version byte.
+const postingsVersion byte = 1
+
+var errCorruptedPostings = errors.New("corrupted postings")
+
+// posting describes positions of a token in the document with the given id.
+type posting struct {
+	id  uint64
+	pos []int
+}
+
+// encodePostings encodes sorted document ids.
+func encodePostings(ids []uint64) []byte {
+	b := make([]byte, 1, 1+binary.MaxVarintLen64*(len(ids)+1))
+	b[0] = postingsVersion
+	b = appendUvarint(b, uint64(len(ids)))
+	var prev uint64
+	for _, id := range ids {
+		b = appendUvarint(b, id-prev)
+		prev = id
+	}
+	return b
+}
+
+// decodePostings decodes document ids encoded by encodePostings.
+func decodePostings(b []byte) ([]uint64, error) {
+	if len(b) == 0 {
+		return nil, errCorruptedPostings
+	}
+	if b[0] != postingsVersion {
+		return nil, fmt.Errorf("unsupported postings version %d", b[0])
+	}
+	b = b[1:]
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return nil, errCorruptedPostings
+	}
+	b = b[n:]
+	ids := make([]uint64, 0, count)
+	var prev uint64
+	for i := uint64(0); i < count; i++ {
+		delta, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errCorruptedPostings
+		}
+		b = b[n:]
+		prev += delta
+		ids = append(ids, prev)
+	}
+	return ids, nil
+}
+
+// encodePositions encodes ascending positions of a token in a document.
+func encodePositions(pos []int) []byte {
+	b := make([]byte, 0, binary.MaxVarintLen64*(len(pos)+1))
+	b = appendUvarint(b, uint64(len(pos)))
+	prev := 0
+	for _, p := range pos {
+		b = appendUvarint(b, uint64(p-prev))
+		prev = p
+	}
+	return b
+}
+
+// decodePositions decodes positions encoded by encodePositions.
+func decodePositions(b []byte) ([]int, error) {
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return nil, errCorruptedPostings
+	}
+	b = b[n:]
+	pos := make([]int, 0, count)
+	prev := 0
+	for i := uint64(0); i < count; i++ {
+		delta, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errCorruptedPostings
+		}
+		b = b[n:]
+		prev += int(delta)
+		pos = append(pos, prev)
+	}
+	return pos, nil
+}
+
+// mergePostings returns the sorted union of two sorted lists of document ids.
+func mergePostings(a, b []uint64) []uint64 {
+	res := make([]uint64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			res = append(res, a[i])
+			i++
+		case a[i] > b[j]:
+			res = append(res, b[j])
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	res = append(res, a[i:]...)
+	return append(res, b[j:]...)
+}
+
+// subtractPostings returns ids of the sorted list a which are not in the sorted list b.
+func subtractPostings(a, b []uint64) []uint64 {
+	res := make([]uint64, 0, len(a))
+	j := 0
+	for _, id := range a {
+		for j < len(b) && b[j] < id {
+			j++
+		}
+		if j < len(b) && b[j] == id {
+			continue
+		}
+		res = append(res, id)
+	}
+	return res
+}
+
+// intersectPostings returns ids found in both sorted lists.
+func intersectPostings(a, b []uint64) []uint64 {
+	res := make([]uint64, 0, len(a))
+	j := 0
+	for _, id := range a {
+		for j < len(b) && b[j] < id {
+			j++
+		}
+		if j < len(b) && b[j] == id {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// uniqueIDs sorts ids and removes duplicates in place.
+func uniqueIDs(ids []uint64) []uint64 {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	res := ids[:0]
+	for _, id := range ids {
+		if len(res) == 0 || id != res[len(res)-1] {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// positionKey returns the key of the positions of the token in the document.
+func positionKey(token string, id uint64) []byte {
+	b := make([]byte, 0, len(token)+9)
+	b = append(b, token...)
+	b = append(b, 0)
+	return append(b, encodeID(id)...)
+}
+
+// parsePositionKey returns the token and the document id of the positions key.
+func parsePositionKey(key []byte) (string, uint64, error) {
+	if len(key) < 9 || key[len(key)-9] != 0 {
+		return "", 0, fmt.Errorf("wrong positions key %q", key)
+	}
+	id, err := decodeID(key[len(key)-8:])
+	return string(key[:len(key)-9]), id, err
+}
+
+// encodeTokens encodes tokens of a document, each token is prefixed by its uvarint length.
+func encodeTokens(tokens []string) []byte {
+	size := 0
+	for _, t := range tokens {
+		size += len(t) + binary.MaxVarintLen64
+	}
+	b := make([]byte, 0, size)
+	for _, t := range tokens {
+		b = appendUvarint(b, uint64(len(t)))
+		b = append(b, t...)
+	}
+	return b
+}
+
+// decodeTokens decodes tokens encoded by encodeTokens.
+func decodeTokens(b []byte) ([]string, error) {
+	var tokens []string
+	for len(b) > 0 {
+		l, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < l {
+			return nil, errCorruptedPostings
+		}
+		tokens = append(tokens, string(b[n:n+int(l)]))
+		b = b[n+int(l):]
+	}
+	return tokens, nil
+}
+
+func encodeID(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
+
+func decodeID(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("wrong document id length %d", len(b))
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
@@ -0,0 +1,71 @@
+package collection
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostings_EncodeDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  []uint64
+	}{
+		{name: "Empty", ids: []uint64{}},
+		{name: "One", ids: []uint64{42}},
+		{name: "Many", ids: []uint64{1, 2, 300, 70000, 1 << 40}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodePostings(encodePostings(tt.ids))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ids, got)
+		})
+	}
+}
+
+func TestPostings_DecodeErrors(t *testing.T) {
+	_, err := decodePostings(nil)
+	assert.Equal(t, errCorruptedPostings, err)
+	_, err = decodePostings([]byte{postingsVersion + 1, 0})
+	assert.Error(t, err)
+	_, err = decodePostings(encodePostings([]uint64{1, 2})[:3])
+	assert.Equal(t, errCorruptedPostings, err)
+}
+
+func TestPositions_EncodeDecode(t *testing.T) {
+	pos := []int{0, 3, 4, 1000}
+	got, err := decodePositions(encodePositions(pos))
+	assert.NoError(t, err)
+	assert.Equal(t, pos, got)
+}
+
+func TestMergePostings(t *testing.T) {
+	assert.Equal(t, []uint64{1, 2, 3, 5, 8}, mergePostings([]uint64{1, 3, 5}, []uint64{2, 3, 8}))
+	assert.Equal(t, []uint64{1}, mergePostings(nil, []uint64{1}))
+}
+
+func TestSubtractPostings(t *testing.T) {
+	assert.Equal(t, []uint64{1, 4}, subtractPostings([]uint64{1, 2, 3, 4}, []uint64{0, 2, 3, 5}))
+	assert.Equal(t, []uint64{}, subtractPostings([]uint64{1}, []uint64{1}))
+}
+
+func TestTokens(t *testing.T) {
+	tokens := []string{"", "data", "данные"}
+	got, err := decodeTokens(encodeTokens(tokens))
+	assert.NoError(t, err)
+	assert.Equal(t, tokens, got)
+
+	_, err = decodeTokens([]byte{5, 'a'})
+	assert.Equal(t, errCorruptedPostings, err)
+
+	token, id, err := parsePositionKey(positionKey("data", 7))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", token)
+	assert.Equal(t, uint64(7), id)
+}
+
+func TestIntersectPostings(t *testing.T) {
+	assert.Equal(t, []uint64{2, 3}, intersectPostings([]uint64{1, 2, 3, 4}, []uint64{0, 2, 3, 5}))
+	assert.Equal(t, []uint64{}, intersectPostings([]uint64{1}, nil))
+}
@@ -1,52 +1,105 @@
 package collection
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
+	"errors"
 	"fmt"
+	"runtime"
 	"sort"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/polyse/database/internal/storage"
 	"github.com/polyse/database/pkg/filters"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/xujiajun/nutsdb"
 )
 
 var (
-	dataPrefix   = "d-"
-	sourceBucket = "sources"
+	// ErrEmptyURL error to return if a document has no url.
+	ErrEmptyURL = errors.New("document url is empty")
+	// ErrVersionConflict error to return if the version of the saved document differs from the expected one.
+	ErrVersionConflict = errors.New("document version conflict")
+	// ErrDocumentNotFound error to return if the document does not exist.
+	ErrDocumentNotFound = errors.New("document does not exist")
 )
 
 // Processor  an interface designed to process and filter incoming data for subsequent
 // storing them in a given database collection.
 type Processor interface {
-	ProcessAndInsertString(data []RawData) error
-	ProcessAndGet(query string, limit, offset int) ([]ResponseData, error)
+	ProcessAndInsertString(ctx context.Context, data []RawData) error
+	ProcessAndInsertBestEffort(ctx context.Context, data []RawData) ([]DocumentError, error)
+	ApplyActions(ctx context.Context, actions []Action) ([]ActionResult, error)
+	ProcessAndGet(ctx context.Context, query string, limit, offset int) ([]ResponseData, error)
+	Search(ctx context.Context, q SearchQuery) ([]ResponseData, error)
+	SearchStructured(ctx context.Context, q StructuredQuery) (SearchResult, error)
+	Get(ctx context.Context, url string) (ResponseData, error)
+	MultiGet(ctx context.Context, urls []string) ([]GetResult, error)
+	List(ctx context.Context, cursor string, limit int) (DocumentPage, error)
+	Scroll(ctx context.Context, scrollID string, limit int, keepAlive time.Duration) (ScrollPage, error)
+	ClearScroll(scrollID string) bool
+	DeleteByQuery(ctx context.Context, q DeleteQuery) (int, error)
+	Schema(ctx context.Context) (Schema, error)
+	SetSchema(ctx context.Context, s Schema) error
+	Sweep(ctx context.Context, now time.Time) (int, error)
+	Analyze(text string) filters.Analysis
 	GetCollectionName() string
 }
 
 // SimpleProcessor simple implementation of the Processor interface.
 type SimpleProcessor struct {
-	tokenizer  filters.Tokenizer
-	filters    []filters.Filter
-	colName    string
-	bucketName string
-	db         *nutsdb.DB
-	l          zerolog.Logger
+	tokenizer filters.Tokenizer
+	filters   []filters.Filter
+	colName   string
+	buckets   buckets
+	store     storage.Storage
+	workers   int
+	chunkSize int
+	// filterCache keeps ids of documents matching search filters.
+	filterCache *filterCache
+	// scrolls keeps snapshots of ids of documents of open scrolls.
+	scrolls scrolls
+	l       zerolog.Logger
 }
 
 // Config describes the basic database configuration.
 type Config struct {
-	File string
+	File    string
+	Storage string
 }
 
-// Source structure for domain\article\site\source description
+// ProcessorConfig describes how documents of a collection are processed.
+type ProcessorConfig struct {
+	// Workers is the number of goroutines analyzing documents of a batch.
+	Workers int
+	// ChunkSize is the number of documents analyzed, written to storage or deleted at once.
+	ChunkSize int
+	// FilterCacheSize is the number of search filters ids of matching documents are cached for,
+	// filters are not cached if it is negative.
+	FilterCacheSize int
+}
+
+func (c *ProcessorConfig) checkConfig() {
+	if c.Workers <= 0 {
+		c.Workers = runtime.NumCPU()
+	}
+	if c.ChunkSize <= 0 {
+		c.ChunkSize = 1000
+	}
+	if c.FilterCacheSize == 0 {
+		c.FilterCacheSize = 1000
+	}
+}
+
+// Source structure for domain\article\site\source description.
+// Version and Expires are set by the database: the version starts from 1 and is incremented on every save
+// of the document, Expires is the time the document expires at if it is saved with a ttl.
 type Source struct {
-	Date  time.Time `json:"date" validate:"required"`
-	Title string    `json:"title" validate:"required"`
+	Date    time.Time  `json:"date" validate:"required"`
+	Title   string     `json:"title" validate:"required"`
+	Fields  Fields     `json:"fields,omitempty"`
+	Version uint64     `json:"version"`
+	Expires *time.Time `json:"expires,omitempty"`
 }
 
 // ResponseData structure to return search result.
@@ -55,40 +108,98 @@ type ResponseData struct {
 	Url string `json:"url"`
 }
 
-// RawData structure for json data description
+// GetResult is a document requested by MultiGet, Source is nil if the document does not exist.
+type GetResult struct {
+	*Source
+	Url   string `json:"url"`
+	Found bool   `json:"found"`
+}
+
+// RawData structure for json data description.
+// If IfVersion is set, the document is saved only if its current version equals to it,
+// version 0 means that the document must not exist.
+// If TTL is set, the document is deleted by Sweep after TTL seconds.
 type RawData struct {
-	Source `json:"source" validate:"required,dive"`
-	Url    string `json:"url" validate:"required,url"`
-	Data   string `json:"data" validate:"required"`
+	Source    `json:"source" validate:"required,dive"`
+	Url       string  `json:"url" validate:"required,url"`
+	Data      string  `json:"data" validate:"required"`
+	IfVersion *uint64 `json:"if_version,omitempty"`
+	TTL       int64   `json:"ttl,omitempty" validate:"gte=0"`
 }
 
-// WordInfo structure for describing positions of tokens in the text at a given url
+// WordInfo structure for describing positions of tokens in the text at a given url.
+// It is the legacy gob encoded postings format, used only to migrate old collections.
 type WordInfo struct {
 	Url string
 	Pos []int
 }
 
+// DocumentError describes why a document of a batch was rejected.
+type DocumentError struct {
+	Index int
+	Url   string
+	Err   error
+}
+
+func (e *DocumentError) Error() string {
+	return fmt.Sprintf("document %d with url %q rejected: %s", e.Index, e.Url, e.Err)
+}
+
+// BatchError is returned if documents of a batch were rejected.
+type BatchError struct {
+	Rejected []DocumentError
+}
+
+func (e *BatchError) Error() string {
+	if len(e.Rejected) == 1 {
+		return e.Rejected[0].Error()
+	}
+	return fmt.Sprintf("%d documents rejected, first: %s", len(e.Rejected), e.Rejected[0].Error())
+}
+
+// ChunkError is returned in best effort mode if processing stops after some chunks are already saved.
+// Documents before Processed are saved unless they are rejected, the others are not saved.
+type ChunkError struct {
+	Processed int
+	Err       error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("processing stopped after %d documents: %s", e.Processed, e.Err)
+}
+
+// Unwrap returns the error that stopped processing.
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
 // Name is type to describe collection name in database
 type Name string
 
 // NewSimpleProcessor function-constructor to SimpleProcessor
 func NewSimpleProcessor(
-	db *nutsdb.DB,
+	store storage.Storage,
+	procCfg ProcessorConfig,
 	colName Name,
 	tokenizer filters.Tokenizer,
 	textFilters ...filters.Filter,
 ) *SimpleProcessor {
+	procCfg.checkConfig()
 	return &SimpleProcessor{
-		db:         db,
-		filters:    textFilters,
-		tokenizer:  tokenizer,
-		colName:    string(colName),
-		bucketName: dataPrefix + string(colName),
+		store:       store,
+		filters:     textFilters,
+		tokenizer:   tokenizer,
+		colName:     string(colName),
+		buckets:     newBuckets(string(colName)),
+		workers:     procCfg.Workers,
+		chunkSize:   procCfg.ChunkSize,
+		filterCache: newFilterCache(procCfg.FilterCacheSize),
 	}
 }
 
 // ProcessAndInsertString changes the input data using the filters specified in this processor,
 // and also saves them in a given collection of data bases.
+// Processing stops before saving if the context is done.
 //
 // Input format:
 //    [
@@ -105,65 +216,421 @@ func NewSimpleProcessor(
 //        "data"  : "data2 data3"
 //      }
 //    ]
-// Format after processing:
+// Format after processing, where 1 and 2 are ids assigned to source1 and source2:
 //    {
-//      "data1" : ["{"url" : "source1", "pos" : [0]}"],
-//      "data2" : ["{"url" : "source1", "pos" : [1, 2]}", "{"url" : "source2", "pos" : [0]}"],
-//      "data3" : ["{"url" : "source2", "pos" : [1]}"],
+//      "data1" : [{"id" : 1, "pos" : [0]}],
+//      "data2" : [{"id" : 1, "pos" : [1]}, {"id" : 2, "pos" : [0]}],
+//      "data3" : [{"id" : 1, "pos" : [2]}, {"id" : 2, "pos" : [1]}],
 //    }
-func (p *SimpleProcessor) ProcessAndInsertString(data []RawData) error {
+// Document ids of each token are saved as a compact postings list, see encodePostings.
+//
+// Documents are analyzed and written in chunks, postings of every chunk are applied before the next one
+// is analyzed, so only one chunk of analyzed documents and postings changes is kept by the processor.
+// The whole batch is saved in a single transaction: if saving fails, nothing is saved and the error is returned.
+// If any documents are rejected, nothing is saved and *BatchError listing all of them is returned.
+// The storage keeps all changes of the transaction until it is committed, so memory used by the storage
+// still grows with the batch, and the memory storage blocks all readers until the whole batch is saved.
+// Use ProcessAndInsertBestEffort, which commits every chunk separately, when memory has to be bounded
+// by the chunk size.
+func (p *SimpleProcessor) ProcessAndInsertString(ctx context.Context, data []RawData) error {
 	log.Debug().
 		Str("collection in processor", p.GetCollectionName()).
 		Msg("processing data")
-	parsed := make(map[string][]*WordInfo)
-	dataCh := make(chan map[string]*WordInfo, len(data))
-	errCh := make(chan error, 1)
-	var wg sync.WaitGroup
+	schema, err := p.Schema(ctx)
+	if err != nil {
+		return err
+	}
+	return p.update(func(tx storage.Tx) error {
+		w := newBatchWriter(p.index(tx))
+		var rejected []DocumentError
+		if err := p.processChunks(ctx, &schema, data, func(offset int, docs []document) error {
+			rejected = append(rejected, rejectedDocuments(docs, offset)...)
+			if len(rejected) > 0 {
+				// Nothing will be saved, the rest of the batch is only checked to report all rejected documents.
+				conflicts, err := w.check(docs, offset)
+				rejected = append(rejected, conflicts...)
+				return err
+			}
+			conflicts, err := w.write(ctx, docs, offset)
+			rejected = append(rejected, conflicts...)
+			if err != nil || len(rejected) > 0 {
+				return err
+			}
+			return w.flush()
+		}); err != nil {
+			return err
+		}
+		if len(rejected) > 0 {
+			sort.Slice(rejected, func(i, j int) bool { return rejected[i].Index < rejected[j].Index })
+			return &BatchError{Rejected: rejected}
+		}
+		return nil
+	})
+}
+
+// ProcessAndInsertBestEffort works like ProcessAndInsertString, but saves all documents that are not rejected
+// and reports the rejected ones. Every chunk of documents is saved in its own transaction.
+// If saving a chunk fails or the context is done, *ChunkError is returned and the remaining documents are not saved,
+// only rejected documents of the saved chunks are reported then.
+func (p *SimpleProcessor) ProcessAndInsertBestEffort(ctx context.Context, data []RawData) ([]DocumentError, error) {
+	log.Debug().
+		Str("collection in processor", p.GetCollectionName()).
+		Msg("processing data in best effort mode")
+	schema, err := p.Schema(ctx)
+	if err != nil {
+		return nil, &ChunkError{Err: err}
+	}
+	var rejected []DocumentError
+	processed := 0
+	err = p.processChunks(ctx, &schema, data, func(offset int, docs []document) error {
+		chunkRejected := rejectedDocuments(docs, offset)
+		if len(chunkRejected) < len(docs) {
+			var conflicts []DocumentError
+			if err := p.update(func(tx storage.Tx) error {
+				w := newBatchWriter(p.index(tx))
+				var err error
+				if conflicts, err = w.write(ctx, docs, offset); err != nil {
+					return err
+				}
+				return w.flush()
+			}); err != nil {
+				return err
+			}
+			chunkRejected = append(chunkRejected, conflicts...)
+			sort.Slice(chunkRejected, func(i, j int) bool { return chunkRejected[i].Index < chunkRejected[j].Index })
+		}
+		rejected = append(rejected, chunkRejected...)
+		processed = offset + len(docs)
+		return nil
+	})
+	if err != nil {
+		return rejected, &ChunkError{Processed: processed, Err: err}
+	}
+	return rejected, nil
+}
 
-	for k := range data {
+// processChunks analyzes documents chunk by chunk and passes every analyzed chunk with the index
+// of its first document to the save function. Processing stops on the first error or if the context is done.
+func (p *SimpleProcessor) processChunks(
+	ctx context.Context,
+	schema *Schema,
+	data []RawData,
+	save func(int, []document) error,
+) error {
+	for offset := 0; offset < len(data); offset += p.chunkSize {
+		end := offset + p.chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		docs := p.analyzeDocuments(ctx, schema, data[offset:end])
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := save(offset, docs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rejectedDocuments returns errors of all documents that can not be saved,
+// offset is added to indexes of the documents.
+func rejectedDocuments(docs []document, offset int) []DocumentError {
+	var rejected []DocumentError
+	for i := range docs {
+		if docs[i].err != nil {
+			rejected = append(rejected, DocumentError{Index: offset + i, Url: docs[i].raw.Url, Err: docs[i].err})
+		}
+	}
+	return rejected
+}
+
+// document is an incoming document prepared for saving, fields are checked fields to save.
+type document struct {
+	raw    RawData
+	fields Fields
+	tokens map[string][]int
+	err    error
+}
+
+// analyzeDocuments splits documents into tokens using the pool of workers. Documents that can not be saved get an error.
+// Analysis stops early if the context is done.
+func (p *SimpleProcessor) analyzeDocuments(ctx context.Context, schema *Schema, data []RawData) []document {
+	docs := make([]document, len(data))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < p.workers && w < len(data); w++ {
 		wg.Add(1)
-		go func(wr *sync.WaitGroup, data RawData, errChan chan<- error, dataChan chan<- map[string]*WordInfo) {
+		go func() {
 			defer wg.Done()
-			p.asyncProcessData(data, errCh, dataCh)
-		}(&wg, data[k], errCh, dataCh)
-	}
-	go func(wg *sync.WaitGroup, dataChan chan map[string]*WordInfo, errChan chan error) {
-		wg.Wait()
-		close(errCh)
-		close(dataCh)
-	}(&wg, dataCh, errCh)
-ReadLoop:
-	for {
-		select {
-		case d, ok := <-dataCh:
-			if !ok {
-				break ReadLoop
+			for i := range jobs {
+				docs[i] = p.analyzeDocument(schema, data[i])
 			}
-			for i := range d {
-				if parsed[i] == nil {
-					parsed[i] = []*WordInfo{d[i]}
-				} else {
-					parsed[i] = append(parsed[i], d[i])
-				}
+		}()
+	}
+	for i := range data {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return docs
+}
+
+func (p *SimpleProcessor) analyzeDocument(schema *Schema, data RawData) document {
+	if data.Url == "" {
+		return document{raw: data, err: ErrEmptyURL}
+	}
+	fields, terms, err := p.analyzeFields(schema, data.Fields)
+	if err != nil {
+		return document{raw: data, err: err}
+	}
+	clearText := p.tokenizer(data.Data, p.filters...)
+	tokens := buildIndexForOneSource(clearText)
+	for term, pos := range terms {
+		tokens[term] = pos
+	}
+	for term, pos := range p.textTerms(titleField, data.Title) {
+		tokens[term] = pos
+	}
+	for term, pos := range hostTerms(data.Url) {
+		tokens[term] = pos
+	}
+	return document{raw: data, fields: fields, tokens: tokens}
+}
+
+// batchWriter writes documents into the index inside a transaction.
+// Sources, positions and tokens are written at once, while ids of the documents are collected
+// for every token and applied to postings by flush, so each postings list is rewritten only once per flush.
+// A saved document replaces the previous version: its old tokens are removed from postings.
+type batchWriter struct {
+	idx     *index
+	added   map[string][]uint64
+	removed map[string][]uint64
+	// written contains ids of documents written by this writer since the last flush.
+	written map[uint64]struct{}
+}
+
+func newBatchWriter(idx *index) *batchWriter {
+	return &batchWriter{
+		idx:     idx,
+		added:   make(map[string][]uint64),
+		removed: make(map[string][]uint64),
+		written: make(map[uint64]struct{}),
+	}
+}
+
+// write writes all documents without an error and returns documents rejected because of a version conflict,
+// offset is added to their indexes.
+func (w *batchWriter) write(ctx context.Context, docs []document, offset int) ([]DocumentError, error) {
+	var rejected []DocumentError
+	for i := range docs {
+		if docs[i].err != nil {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := w.writeDocument(&docs[i]); err != nil {
+			if errors.Is(err, ErrVersionConflict) {
+				rejected = append(rejected, DocumentError{Index: offset + i, Url: docs[i].raw.Url, Err: err})
+				continue
 			}
-		case err, ok := <-errCh:
-			if ok {
-				return err
+			return nil, err
+		}
+	}
+	return rejected, nil
+}
+
+// writeDocument writes the document and returns its new version.
+func (w *batchWriter) writeDocument(doc *document) (uint64, error) {
+	id, ok, version, err := w.version(doc.raw)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		if err = w.unindex(id); err != nil {
+			return 0, err
+		}
+	} else if id, err = w.idx.assignID(doc.raw.Url); err != nil {
+		return 0, err
+	}
+	src := doc.raw.Source
+	src.Version = version + 1
+	src.Fields = doc.fields
+	src.Expires = nil
+	if ttl := doc.raw.TTL; ttl > 0 {
+		expires := time.Now().Add(time.Duration(ttl) * time.Second)
+		src.Expires = &expires
+	}
+	if err = w.idx.putSource(id, src); err != nil {
+		return 0, fmt.Errorf("can not save source %s, error %s", doc.raw.Url, err)
+	}
+	tokens := make([]string, 0, len(doc.tokens))
+	for token, pos := range doc.tokens {
+		tokens = append(tokens, token)
+		if err = w.addToken(token, id, pos); err != nil {
+			return 0, err
+		}
+	}
+	sort.Strings(tokens)
+	if err = w.idx.putTokens(id, tokens); err != nil {
+		return 0, err
+	}
+	if err = w.idx.putSortValues(id, doc.tokens); err != nil {
+		return 0, err
+	}
+	w.written[id] = struct{}{}
+	return src.Version, nil
+}
+
+// check returns documents rejected because of a version conflict without writing anything.
+func (w *batchWriter) check(docs []document, offset int) ([]DocumentError, error) {
+	var rejected []DocumentError
+	for i := range docs {
+		if docs[i].err != nil {
+			continue
+		}
+		if _, _, _, err := w.version(docs[i].raw); err != nil {
+			if !errors.Is(err, ErrVersionConflict) {
+				return nil, err
 			}
+			rejected = append(rejected, DocumentError{Index: offset + i, Url: docs[i].raw.Url, Err: err})
 		}
 	}
+	return rejected, nil
+}
 
-	return p.saveData(parsed)
+// version returns the id and the current version of the document if it exists.
+// An error wrapping ErrVersionConflict is returned if the version differs from the expected one,
+// the expected version of a missing document is 0.
+func (w *batchWriter) version(raw RawData) (id uint64, ok bool, version uint64, err error) {
+	if id, ok, err = w.idx.docID(raw.Url); err != nil {
+		return 0, false, 0, err
+	}
+	if ok {
+		s, err := w.idx.source(id)
+		if err != nil {
+			return 0, false, 0, err
+		}
+		version = s.Version
+	}
+	if raw.IfVersion != nil && *raw.IfVersion != version {
+		return 0, false, 0, fmt.Errorf("%w: expected version %d, current version %d",
+			ErrVersionConflict, *raw.IfVersion, version)
+	}
+	return id, ok, version, nil
 }
 
-func (p *SimpleProcessor) asyncProcessData(data RawData, errChan chan<- error, dataChan chan<- map[string]*WordInfo) {
-	if err := p.saveSource(data.Url, Source{Date: data.Date, Title: data.Title}); err != nil {
-		errChan <- fmt.Errorf("can not save source %s, error %s", data.Url, err)
-		return
+// remove deletes the saved document and schedules removing it from postings.
+func (w *batchWriter) remove(id uint64, url string) error {
+	if err := w.unindex(id); err != nil {
+		return err
 	}
-	clearText := p.tokenizer(data.Data, p.filters...)
-	sourceMap := buildIndexForOneSource(data.Url, clearText)
-	dataChan <- sourceMap
+	delete(w.written, id)
+	return w.idx.deleteDocument(id, url)
+}
+
+// unindex removes positions and tokens of the saved document and schedules removing it from postings.
+func (w *batchWriter) unindex(id uint64) error {
+	tokens, err := w.idx.tokens(id)
+	if err != nil {
+		return err
+	}
+	_, written := w.written[id]
+	for _, token := range tokens {
+		if err = w.removeToken(token, id, written); err != nil {
+			return err
+		}
+	}
+	return w.idx.deleteSortValues(id, tokens)
+}
+
+// addToken saves positions of the token in the document and schedules adding the document to postings.
+func (w *batchWriter) addToken(token string, id uint64, pos []int) error {
+	if isNumericTerm(token) {
+		return w.idx.putNumeric(token, id)
+	}
+	if err := w.idx.putPositions(token, id, pos); err != nil {
+		return err
+	}
+	w.added[token] = append(w.added[token], id)
+	return nil
+}
+
+// removeToken removes positions of the token in the document and schedules removing the document from postings.
+func (w *batchWriter) removeToken(token string, id uint64, written bool) error {
+	if isNumericTerm(token) {
+		return w.idx.deleteNumeric(token, id)
+	}
+	if err := w.idx.deletePositions(token, id); err != nil {
+		return err
+	}
+	w.removed[token] = append(w.removed[token], id)
+	if written {
+		// The document was written by this writer, so it is not in the stored postings yet.
+		w.added[token] = removeID(w.added[token], id)
+	}
+	return nil
+}
+
+// flush applies collected changes to postings.
+func (w *batchWriter) flush() error {
+	log.Debug().Int("tokens", len(w.added)).Int("removed tokens", len(w.removed)).Msg("start inserting data")
+	for token, ids := range w.removed {
+		if _, ok := w.added[token]; ok {
+			continue
+		}
+		if err := w.updatePostings(token, nil, ids); err != nil {
+			return err
+		}
+	}
+	for token, ids := range w.added {
+		if err := w.updatePostings(token, ids, w.removed[token]); err != nil {
+			return err
+		}
+	}
+	if err := w.idx.putFieldNames(w.added); err != nil {
+		return err
+	}
+	w.added = make(map[string][]uint64)
+	w.removed = make(map[string][]uint64)
+	w.written = make(map[uint64]struct{})
+	return nil
+}
+
+// updatePostings removes and then adds ids to postings of the token.
+func (w *batchWriter) updatePostings(token string, added, removed []uint64) error {
+	ids, err := w.idx.postings(token)
+	if err != nil {
+		return err
+	}
+	if len(removed) > 0 {
+		ids = subtractPostings(ids, uniqueIDs(removed))
+	}
+	if len(added) > 0 {
+		ids = mergePostings(ids, uniqueIDs(added))
+	}
+	if err = w.idx.putPostings(token, ids); err != nil {
+		log.Err(err).
+			Str("key", token).
+			Msg("can not save postings to database")
+		return err
+	}
+	return nil
+}
+
+// removeID removes all occurrences of the id from the list.
+func removeID(ids []uint64, id uint64) []uint64 {
+	res := ids[:0]
+	for _, v := range ids {
+		if v != id {
+			res = append(res, v)
+		}
+	}
+	return res
 }
 
 // GetCollectionName returns the name of the collection specified for this processor.
@@ -171,88 +638,175 @@ func (p *SimpleProcessor) GetCollectionName() string {
 	return p.colName
 }
 
+// index returns the index of the collection inside the transaction.
+func (p *SimpleProcessor) index(tx storage.Tx) *index {
+	return &index{tx: tx, b: p.buckets}
+}
+
+// Analyze shows how the text is split into tokens and changed by each filter of this processor.
+func (p *SimpleProcessor) Analyze(text string) filters.Analysis {
+	return filters.Analyze(text, p.tokenizer, p.filters...)
+}
+
 // ProcessAndGet processes the incoming request, dividing it into tokens and filtering,
 // after which it finds documents in the specified collection with the maximum number of words from the search query.
-// Supports pagination.
-func (p *SimpleProcessor) ProcessAndGet(query string, limit, offset int) ([]ResponseData, error) {
-	if limit < 1 {
-		limit = 10
+// Words are searched in the data and the title of documents, words of field-scoped conditions on text fields,
+// such as title:golang, only in the field. Found documents are ordered by the sum of boosts of the fields
+// the words are found in and then by date, see Schema.
+// Conditions on other metadata fields, see ParseQuery, must match exactly and do not affect the order.
+// A query without words finds all documents matching its conditions, the most recent first.
+// Supports pagination. The search is aborted with the context error if the context is done.
+func (p *SimpleProcessor) ProcessAndGet(ctx context.Context, query string, limit, offset int) ([]ResponseData, error) {
+	q := ParseQuery(query)
+	q.Limit, q.Offset = limit, offset
+	return p.Search(ctx, q)
+}
+
+// Search works like ProcessAndGet with the parsed query, found documents can be ordered by fields.
+// An error wrapping ErrInvalidField is returned if a range of the query is wrong.
+func (p *SimpleProcessor) Search(ctx context.Context, q SearchQuery) ([]ResponseData, error) {
+	if q.Limit < 1 {
+		q.Limit = 10
 	}
-	if offset < 0 {
-		offset = 0
+	if q.Offset < 0 {
+		q.Offset = 0
 	}
-	clearText := p.tokenizer(query, p.filters...)
-	return p.findByWords(clearText, limit, offset)
+	return p.findByWords(ctx, q)
 }
 
-func buildIndexForOneSource(src string, words []string) map[string]*WordInfo {
-	sourceMap := make(map[string]*WordInfo)
-	for i := range words {
-		if sourceMap[words[i]] == nil {
-			sourceMap[words[i]] = &WordInfo{Url: src, Pos: []int{i}}
-		} else {
-			sourceMap[words[i]].Pos = append(sourceMap[words[i]].Pos, i)
+// Get returns the document with the given url, or ErrDocumentNotFound. Expired documents are not found.
+func (p *SimpleProcessor) Get(ctx context.Context, url string) (res ResponseData, err error) {
+	err = p.store.View(func(tx storage.Tx) error {
+		idx := p.index(tx)
+		schema, err := idx.schema()
+		if err != nil {
+			return err
 		}
-	}
-	return sourceMap
+		id, ok, err := idx.docID(url)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrDocumentNotFound
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		res.Url = url
+		if res.Source, err = idx.source(id); err != nil {
+			return err
+		}
+		if expiredSource(&schema, &res.Source, time.Now()) {
+			return ErrDocumentNotFound
+		}
+		return nil
+	})
+	return res, err
 }
 
-func (p *SimpleProcessor) saveSource(key string, src Source) error {
-	var b bytes.Buffer
-	enc := gob.NewEncoder(&b)
+// MultiGet returns documents with the given urls in the same order, all of them are read in a single transaction.
+// Expired documents are not found.
+func (p *SimpleProcessor) MultiGet(ctx context.Context, urls []string) (res []GetResult, err error) {
+	err = p.store.View(func(tx storage.Tx) error {
+		idx := p.index(tx)
+		schema, err := idx.schema()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		res = make([]GetResult, len(urls))
+		for i, url := range urls {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			res[i].Url = url
+			id, ok, err := idx.docID(url)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			s, err := idx.source(id)
+			if err != nil {
+				return err
+			}
+			if expiredSource(&schema, &s, now) {
+				continue
+			}
+			res[i].Source, res[i].Found = &s, true
+		}
+		return nil
+	})
+	return res, err
+}
 
-	err := enc.Encode(src)
-	if err != nil {
-		return err
+// buildIndexForOneSource returns positions of each token in the document.
+func buildIndexForOneSource(words []string) map[string][]int {
+	sourceMap := make(map[string][]int)
+	for i := range words {
+		sourceMap[words[i]] = append(sourceMap[words[i]], i)
 	}
-	return p.db.Update(func(tx *nutsdb.Tx) error {
-		return tx.Put(sourceBucket, []byte(key), b.Bytes(), 0)
-	})
+	return sourceMap
 }
 
-func (p *SimpleProcessor) findByWords(keys []string, limit, offset int) (res []ResponseData, err error) {
+func (p *SimpleProcessor) findByWords(ctx context.Context, q SearchQuery) (res []ResponseData, err error) {
+	limit, offset := q.Limit, q.Offset
 	log.Debug().
-		Strs("search words", keys).
+		Str("search text", q.Text).
+		Interface("fields", q.Fields).
+		Interface("ranges", q.Ranges).
+		Interface("filters", q.Filters).
+		Interface("sort", q.Sort).
 		Int("limit", limit).
 		Int("offset", offset).
 		Msg("start searching")
-	if err = p.db.View(func(tx *nutsdb.Tx) error {
-		src, err := findKeys(tx, p.bucketName, keys)
+	gen := p.filterCache.generation()
+	if err = p.store.View(func(tx storage.Tx) error {
+		idx := p.index(tx)
+		schema, err := idx.schema()
+		if err != nil {
+			return err
+		}
+		if q, err = resolveQuery(idx, &schema, q); err != nil {
+			return err
+		}
+		aq, err := p.analyzeQuery(&schema, q)
+		if err != nil {
+			return err
+		}
+		if aq.sets, err = p.filterSets(ctx, idx, gen, aq.filterTerms); err != nil {
+			return err
+		}
+		wps, err := findWords(ctx, idx, aq.words)
+		if err != nil {
+			return err
+		}
+		lists, err := aq.filter(ctx, idx, unions(wps))
+		if err != nil {
+			return err
+		}
+		exp := newExpiry(&schema, time.Now())
+		ids, err := maxLiveKeys(lists, func(id uint64) (bool, error) { return exp.live(idx, id) })
 		if err != nil {
 			return err
 		}
-		src = maxKeys(src)
 		log.Debug().
-			Strs("search words", keys).
-			Interface("sources", src).
-			Msg("start collect source information")
-		res, err = findSources(tx, src)
+			Str("search text", q.Text).
+			Int("found", len(ids)).
+			Msg("start ranking sources")
+		hits, err := rankPage(ctx, idx, exp, ids, limit, offset, q.Sort, func(id uint64) float64 { return score(wps, id) })
 		if err != nil {
 			return err
 		}
-		return nil
+		res, err = findSources(ctx, idx, hits)
+		return err
 	}); err != nil {
 		return nil, err
 	}
 
 	log.Debug().
-		Strs("search words", keys).
-		Int("raw length", len(res)).
-		Interface("result", res).
-		Msg("data found")
-
-	sort.Slice(res, func(i, j int) bool {
-		return res[i].Date.After(res[j].Date)
-	})
-	if offset >= len(res) {
-		offset = 0
-	}
-	if limit+offset > len(res) {
-		limit = len(res) - offset
-	}
-	res = res[offset : limit+offset]
-	log.Debug().
-		Strs("search words", keys).
+		Str("search text", q.Text).
 		Int("limit", limit).
 		Int("offset", offset).
 		Interface("pagination result", res).
@@ -260,116 +814,129 @@ func (p *SimpleProcessor) findByWords(keys []string, limit, offset int) (res []R
 	return res, nil
 }
 
-func findKeys(tx *nutsdb.Tx, bucketName string, keys []string) (map[string][]string, error) {
-	keys = clearDoubleKeys(keys)
-	src := make(map[string][]string)
-	for i := range keys {
-		d, err := tx.SMembers(bucketName, []byte(keys[i]))
-		if err != nil {
-			if err.Error() == "set not exists" ||
-				strings.HasPrefix(err.Error(), "not found bucket:"+bucketName+",key:") {
-				log.Debug().Err(err).Str("bucket", bucketName).Str("key", keys[i]).Msg("key not found")
-				continue
-			}
+// rankPage returns hits of the page of found documents, see rankSources.
+// The first page is returned if the offset is out of found documents.
+func rankPage(
+	ctx context.Context,
+	idx *index,
+	exp expiry,
+	ids []uint64,
+	limit, offset int,
+	sortFields []SortField,
+	score func(uint64) float64,
+) ([]hit, error) {
+	if offset >= len(ids) {
+		offset = 0
+	}
+	hits, err := rankSources(ctx, idx, exp, ids, limit+offset, sortFields, score)
+	if err != nil || offset >= len(hits) {
+		return nil, err
+	}
+	return hits[offset:], nil
+}
+
+// rankSources returns the k sources ordered by the sort fields, the score and then the most recent ones
+// without loading the sources themselves. Expired documents are skipped.
+func rankSources(
+	ctx context.Context,
+	idx *index,
+	exp expiry,
+	ids []uint64,
+	k int,
+	sortFields []SortField,
+	score func(uint64) float64,
+) ([]hit, error) {
+	top := newTopK(k)
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
 			return nil, err
 		}
-		if err = prepareSet(src, d, keys[i]); err != nil {
+		date, expires, err := idx.times(id)
+		if err != nil {
 			return nil, err
 		}
-	}
-	return src, nil
-}
-
-func clearDoubleKeys(keys []string) []string {
-	clearMap := make(map[string]struct{})
-	var result []string
-	for i := range keys {
-		if _, ok := clearMap[keys[i]]; !ok {
-			clearMap[keys[i]] = struct{}{}
-			result = append(result, keys[i])
+		if exp.expired(date, expires) {
+			continue
 		}
+		h := hit{id: id, score: score(id), date: date}
+		if len(sortFields) > 0 {
+			if h.sort, err = idx.sortValues(id, sortFields); err != nil {
+				return nil, err
+			}
+		}
+		top.push(h)
 	}
-	return result
+	return top.sorted(), nil
 }
 
-func prepareSet(src map[string][]string, data [][]byte, word string) error {
-	for j := range data {
-		var s WordInfo
-		r := bytes.NewReader(data[j])
-		dec := gob.NewDecoder(r)
-		if err := dec.Decode(&s); err != nil {
-			return err
-		}
-		if src[s.Url] == nil {
-			src[s.Url] = []string{word}
-		} else {
-			src[s.Url] = append(src[s.Url], word)
-		}
-	}
-	return nil
+// maxKeys walks sorted lists of document ids at once and returns sorted ids
+// found in the maximum number of lists.
+func maxKeys(lists [][]uint64) []uint64 {
+	ids, _ := maxLiveKeys(lists, func(uint64) (bool, error) { return true, nil })
+	return ids
 }
 
-func maxKeys(input map[string][]string) map[string][]string {
-	output := make(map[string][]string)
+// maxLiveKeys returns sorted ids found in the maximum number of lists among the ids for which live
+// returns true, see maxKeys. Only ids found in at least as many lists as the returned ones are checked.
+func maxLiveKeys(lists [][]uint64, live func(uint64) (bool, error)) ([]uint64, error) {
+	var output []uint64
 	max := 0
-	for k := range input {
-		if len(input[k]) == max {
-			output[k] = input[k]
+	heads := make([]int, len(lists))
+	for {
+		var min uint64
+		found := false
+		for i := range lists {
+			if heads[i] < len(lists[i]) && (!found || lists[i][heads[i]] < min) {
+				min = lists[i][heads[i]]
+				found = true
+			}
+		}
+		if !found {
+			return output, nil
+		}
+		count := 0
+		for i := range lists {
+			if heads[i] < len(lists[i]) && lists[i][heads[i]] == min {
+				count++
+				heads[i]++
+			}
+		}
+		if count < max {
+			continue
+		}
+		ok, err := live(min)
+		if err != nil {
+			return nil, err
 		}
-		if len(input[k]) > max {
-			max = len(input[k])
-			output = make(map[string][]string)
-			output[k] = input[k]
+		if !ok {
+			continue
 		}
+		if count > max {
+			max = count
+			output = output[:0]
+		}
+		output = append(output, min)
 	}
-	return output
 }
 
-func findSources(tx *nutsdb.Tx, src map[string][]string) (res []ResponseData, err error) {
-	res = make([]ResponseData, 0, len(src))
-	for i := range src {
-		e, err := tx.Get(sourceBucket, []byte(i))
+func findSources(ctx context.Context, idx *index, hits []hit) (res []ResponseData, err error) {
+	res = make([]ResponseData, 0, len(hits))
+	for i := range hits {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		s, err := idx.source(hits[i].id)
 		if err != nil {
 			return nil, err
 		}
-		var s Source
-		r := bytes.NewReader(e.Value)
-		dec := gob.NewDecoder(r)
-		if err = dec.Decode(&s); err != nil {
+		url, err := idx.url(hits[i].id)
+		if err != nil {
 			return nil, err
 		}
 		res = append(res, ResponseData{
 			Source: s,
-			Url:    i,
+			Url:    url,
 		})
 	}
 	return res, nil
 }
-
-func (p *SimpleProcessor) saveData(ent map[string][]*WordInfo) error {
-
-	p.l.Debug().Interface("data", ent).Msg("start inserting data")
-
-	return p.db.Update(func(tx *nutsdb.Tx) error {
-		for i := range ent {
-			vals := ent[i]
-			data := make([][]byte, 0, len(vals))
-			for j := range vals {
-				var b bytes.Buffer
-				enc := gob.NewEncoder(&b)
-				if err := enc.Encode(vals[j]); err != nil {
-					return err
-				}
-				data = append(data, b.Bytes())
-
-			}
-			if err := tx.SAdd(p.bucketName, []byte(i), data...); err != nil {
-				p.l.Err(err).
-					Str("key", i).
-					Msg("can not SADD to database")
-				return err
-			}
-		}
-		return nil
-	})
-}
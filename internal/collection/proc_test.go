@@ -1,14 +1,17 @@
 package collection
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
+	"errors"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
+	"github.com/polyse/database/internal/storage"
 	"github.com/polyse/database/pkg/filters"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"github.com/xujiajun/nutsdb"
 )
@@ -18,35 +21,94 @@ var (
 	nutColl = "testCollection"
 )
 
+func TestMaxKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		lists [][]uint64
+		want  []uint64
+	}{
+		{
+			name:  "Intersection",
+			lists: [][]uint64{{1, 2, 5, 9}, {2, 3, 9}, {2, 9, 10}},
+			want:  []uint64{2, 9},
+		},
+		{
+			name:  "Partial match",
+			lists: [][]uint64{{1, 4}, {2, 4, 6}, {6, 7}},
+			want:  []uint64{4, 6},
+		},
+		{
+			name:  "No lists",
+			lists: nil,
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maxKeys(tt.lists); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("maxKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_maxLiveKeys(t *testing.T) {
+	var checked []uint64
+	live := func(id uint64) (bool, error) {
+		checked = append(checked, id)
+		return id != 4, nil
+	}
+	// The document found in most lists is expired, so documents found in fewer lists are returned.
+	got, err := maxLiveKeys([][]uint64{{1, 4}, {2, 4, 6}, {4, 6, 7}}, live)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{6}, got)
+	assert.Equal(t, []uint64{1, 2, 4, 6}, checked)
+}
+
 type processorTestSuite struct {
 	suite.Suite
 	proc   Processor
+	store  storage.Storage
 	nutsDb *nutsdb.DB
+	memory bool
 }
 
 func TestStartConnectionSuit(t *testing.T) {
 	suite.Run(t, new(processorTestSuite))
 }
 
+func TestStartMemorySuit(t *testing.T) {
+	suite.Run(t, &processorTestSuite{memory: true})
+}
+
 func (cts *processorTestSuite) SetupTest() {
-	opt := nutsdb.DefaultOptions
-	opt.Dir = dbDir
-	nutsDb, err := nutsdb.Open(opt)
-	if err != nil {
-		panic(err)
+	if cts.memory {
+		cts.store = storage.NewMemory()
+	} else {
+		opt := nutsdb.DefaultOptions
+		opt.Dir = dbDir
+		nutsDb, err := nutsdb.Open(opt)
+		if err != nil {
+			panic(err)
+		}
+		cts.nutsDb = nutsDb
+		cts.store = storage.NewNuts(nutsDb)
 	}
 	proc := NewSimpleProcessor(
-		nutsDb,
+		cts.store,
+		ProcessorConfig{Workers: 2, ChunkSize: 2},
 		Name(nutColl),
 		filters.FilterText,
 		filters.StemmAndToLower,
 		filters.StopWords,
 	)
 	cts.proc = proc
-	cts.nutsDb = nutsDb
 }
 
 func (cts *processorTestSuite) TearDownTest() {
+	if cts.memory {
+		return
+	}
 	if err := cts.nutsDb.Close(); err != nil {
 		panic(err)
 	}
@@ -56,35 +118,16 @@ func (cts *processorTestSuite) TearDownTest() {
 }
 
 func (cts *processorTestSuite) TestConnection_NewConnection() {
+	if cts.memory {
+		cts.T().Skip("memory storage has no files")
+	}
 	cts.DirExists(dbDir)
 }
 
 func (cts *processorTestSuite) TestNutsRepository_Save1() {
 	saveData := []RawData{{Url: "test", Data: "data1 data2"}}
-	cts.NoError(cts.proc.ProcessAndInsertString(saveData))
-	if err := cts.nutsDb.View(
-		func(tx *nutsdb.Tx) error {
-			key := []byte("data1")
-			bucket := dataPrefix + nutColl
-			e, err := tx.SMembers(bucket, key)
-			if err != nil {
-				return err
-			}
-			data := make([]WordInfo, 0, len(e))
-			for i := range e {
-				var s WordInfo
-				r := bytes.NewReader(e[i])
-				dec := gob.NewDecoder(r)
-				if err := dec.Decode(&s); err != nil {
-					return err
-				}
-				data = append(data, s)
-			}
-			cts.ElementsMatch([]WordInfo{{Url: "test", Pos: []int{0}}}, data)
-			return nil
-		}); err != nil {
-		panic(err)
-	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	cts.Equal(map[string][]int{"test": {0}}, cts.postings("data1"))
 }
 
 func (cts *processorTestSuite) TestNutsRepository_Save2() {
@@ -109,53 +152,14 @@ func (cts *processorTestSuite) TestNutsRepository_Save2() {
 			},
 		},
 	}
-	cts.NoError(cts.proc.ProcessAndInsertString(saveData))
-	if err := cts.nutsDb.View(
-		func(tx *nutsdb.Tx) error {
-			key := []byte("data2")
-			bucket := dataPrefix + nutColl
-			e, err := tx.SMembers(bucket, key)
-			if err != nil {
-				return err
-			}
-			data := make([]WordInfo, 0, len(e))
-			for i := range e {
-				var s WordInfo
-				r := bytes.NewReader(e[i])
-				dec := gob.NewDecoder(r)
-				if err := dec.Decode(&s); err != nil {
-					return err
-				}
-				data = append(data, s)
-			}
-			cts.ElementsMatch([]WordInfo{{Url: "source1", Pos: []int{1, 2}}, {Url: "source2", Pos: []int{1}}}, data)
-			return nil
-		}); err != nil {
-		panic(err)
-	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	cts.Equal(map[string][]int{"source1": {1, 2}, "source2": {1}}, cts.postings("data2"))
 
-	if err := cts.nutsDb.View(
-		func(tx *nutsdb.Tx) error {
-			key := []byte("source1")
-			bucket := sourceBucket
-			e, err := tx.Get(bucket, key)
-			if err != nil {
-				return err
-			}
-			var s Source
-			r := bytes.NewReader(e.Value)
-			dec := gob.NewDecoder(r)
-			if err := dec.Decode(&s); err != nil {
-				return err
-			}
-			cts.Equal(Source{
-				Date:  now.Round(1 * time.Nanosecond),
-				Title: "Test Title",
-			}, s)
-			return nil
-		}); err != nil {
-		panic(err)
-	}
+	cts.Equal(Source{
+		Date:    now.Round(1 * time.Nanosecond),
+		Title:   "Test Title",
+		Version: 1,
+	}, cts.source("source1"))
 	saveData = []RawData{
 		{
 			Url:  "source1",
@@ -166,56 +170,17 @@ func (cts *processorTestSuite) TestNutsRepository_Save2() {
 			},
 		},
 	}
-	cts.NoError(cts.proc.ProcessAndInsertString(saveData))
-
-	if err := cts.nutsDb.View(
-		func(tx *nutsdb.Tx) error {
-			key := []byte("data5")
-			bucket := dataPrefix + nutColl
-			e, err := tx.SMembers(bucket, key)
-			if err != nil {
-				return err
-			}
-			data := make([]WordInfo, 0, len(e))
-			for i := range e {
-				var s WordInfo
-				r := bytes.NewReader(e[i])
-				dec := gob.NewDecoder(r)
-				if err := dec.Decode(&s); err != nil {
-					return err
-				}
-				data = append(data, s)
-			}
-			cts.ElementsMatch([]WordInfo{{Url: "source1", Pos: []int{0}}}, data)
-
-			return nil
-		}); err != nil {
-		panic(err)
-	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
 
-	if err := cts.nutsDb.View(
-		func(tx *nutsdb.Tx) error {
-			key := []byte("source1")
-			bucket := sourceBucket
-			e, err := tx.Get(bucket, key)
-			if err != nil {
-				return err
-			}
-			var s Source
-			r := bytes.NewReader(e.Value)
-			dec := gob.NewDecoder(r)
-			if err := dec.Decode(&s); err != nil {
-				return err
-			}
-			cts.Equal(Source{
-				Date:  now.Round(1 * time.Nanosecond),
-				Title: "Test Title New",
-			}, s)
+	cts.Equal(map[string][]int{"source1": {0}}, cts.postings("data5"))
+	cts.Equal(map[string][]int{"source2": {1}}, cts.postings("data2"))
+	cts.Empty(cts.postings("data1"))
 
-			return nil
-		}); err != nil {
-		panic(err)
-	}
+	cts.Equal(Source{
+		Date:    now.Round(1 * time.Nanosecond),
+		Title:   "Test Title New",
+		Version: 2,
+	}, cts.source("source1"))
 }
 
 func (cts *processorTestSuite) TestNutsRepository_Get() {
@@ -239,21 +204,23 @@ func (cts *processorTestSuite) TestNutsRepository_Get() {
 			},
 		},
 	}
-	cts.NoError(cts.proc.ProcessAndInsertString(saveData))
-	res, err := cts.proc.ProcessAndGet("data2", 100, 0)
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	res, err := cts.proc.ProcessAndGet(context.Background(), "data2", 100, 0)
 	cts.NoError(err)
 	cts.ElementsMatch(res, []ResponseData{
 		{
 			Source: Source{
-				Date:  now.Round(1 * time.Nanosecond),
-				Title: "Test Title",
+				Date:    now.Round(1 * time.Nanosecond),
+				Title:   "Test Title",
+				Version: 1,
 			},
 			Url: "source1",
 		},
 		{
 			Source: Source{
-				Date:  now.Round(1 * time.Nanosecond),
-				Title: "Test Second Title",
+				Date:    now.Round(1 * time.Nanosecond),
+				Title:   "Test Second Title",
+				Version: 1,
 			},
 			Url: "source2",
 		},
@@ -281,14 +248,15 @@ func (cts *processorTestSuite) TestNutsRepository_Get2() {
 			},
 		},
 	}
-	cts.NoError(cts.proc.ProcessAndInsertString(saveData))
-	res, err := cts.proc.ProcessAndGet("data3 data2", 100, 0)
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	res, err := cts.proc.ProcessAndGet(context.Background(), "data3 data2", 100, 0)
 	cts.NoError(err)
 	cts.ElementsMatch(res, []ResponseData{
 		{
 			Source: Source{
-				Date:  now.Round(1 * time.Nanosecond),
-				Title: "Test Second Title",
+				Date:    now.Round(1 * time.Nanosecond),
+				Title:   "Test Second Title",
+				Version: 1,
 			},
 			Url: "source2",
 		},
@@ -324,29 +292,32 @@ func (cts *processorTestSuite) TestNutsRepository_Get3() {
 			},
 		},
 	}
-	cts.NoError(cts.proc.ProcessAndInsertString(saveData))
-	res, err := cts.proc.ProcessAndGet("data2", 100, 0)
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	res, err := cts.proc.ProcessAndGet(context.Background(), "data2", 100, 0)
 	cts.NoError(err)
 	cts.Equal(res, []ResponseData{
 		{
 			Source: Source{
-				Date:  now.Round(1 * time.Nanosecond),
-				Title: "Test Second Title",
+				Date:    now.Round(1 * time.Nanosecond),
+				Title:   "Test Second Title",
+				Version: 1,
 			},
 			Url: "source2",
 		},
 		{
 			Url: "source3",
 			Source: Source{
-				Date:  now.Add(-10 * time.Minute).Round(1 * time.Nanosecond),
-				Title: "Test Second Title",
+				Date:    now.Add(-10 * time.Minute).Round(1 * time.Nanosecond),
+				Title:   "Test Second Title",
+				Version: 1,
 			},
 		},
 		{
 			Url: "source1",
 			Source: Source{
-				Date:  now.Add(-1 * time.Hour).Round(1 * time.Nanosecond),
-				Title: "Test Title",
+				Date:    now.Add(-1 * time.Hour).Round(1 * time.Nanosecond),
+				Title:   "Test Title",
+				Version: 1,
 			},
 		},
 	})
@@ -381,16 +352,210 @@ func (cts *processorTestSuite) TestNutsRepository_Get4() {
 			},
 		},
 	}
-	cts.NoError(cts.proc.ProcessAndInsertString(saveData))
-	res, err := cts.proc.ProcessAndGet("data2", 1, 1)
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	res, err := cts.proc.ProcessAndGet(context.Background(), "data2", 1, 1)
 	cts.NoError(err)
 	cts.Equal(res, []ResponseData{
 		{
 			Url: "source3",
 			Source: Source{
-				Date:  now.Add(-10 * time.Minute).Round(1 * time.Nanosecond),
-				Title: "Test Second Title",
+				Date:    now.Add(-10 * time.Minute).Round(1 * time.Nanosecond),
+				Title:   "Test Second Title",
+				Version: 1,
 			},
 		},
 	})
 }
+
+func (cts *processorTestSuite) TestSimpleProcessor_Analyze() {
+	res := cts.proc.Analyze("The barking dogs")
+	cts.Equal([]string{"tokenizer", "StemmAndToLower", "StopWords"}, res.Stages)
+	cts.Equal([]filters.Token{
+		{Position: 0, Forms: []string{"The", "the"}, DroppedBy: "StopWords", IndexPosition: -1},
+		{Position: 1, Forms: []string{"barking", "bark", "bark"}, IndexPosition: 0},
+		{Position: 2, Forms: []string{"dogs", "dog", "dog"}, IndexPosition: 1},
+	}, res.Tokens)
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_InsertAtomic() {
+	saveData := []RawData{{Url: "source1", Data: "data1"}, {Url: "", Data: "data1"}}
+	err := cts.proc.ProcessAndInsertString(context.Background(), saveData)
+	cts.Equal(&BatchError{Rejected: []DocumentError{{Index: 1, Url: "", Err: ErrEmptyURL}}}, err)
+
+	res, err := cts.proc.ProcessAndGet(context.Background(), "data1", 10, 0)
+	cts.NoError(err)
+	cts.Empty(res)
+	cts.Equal(uint64(0), cts.documents(cts.proc.(*SimpleProcessor)))
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_InsertBestEffort() {
+	saveData := []RawData{{Url: "", Data: "data1"}, {Url: "source1", Data: "data1"}, {Url: "source1", Data: "data1 data2"}}
+	rejected, err := cts.proc.ProcessAndInsertBestEffort(context.Background(), saveData)
+	cts.NoError(err)
+	cts.Equal([]DocumentError{{Index: 0, Url: "", Err: ErrEmptyURL}}, rejected)
+	cts.Equal(map[string][]int{"source1": {0}}, cts.postings("data1"))
+	cts.Equal(map[string][]int{"source1": {1}}, cts.postings("data2"))
+
+	rejected, err = cts.proc.ProcessAndInsertBestEffort(context.Background(), saveData[:1])
+	cts.NoError(err)
+	cts.Len(rejected, 1)
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_InsertChunks() {
+	saveData := []RawData{
+		{Url: "source1", Data: "data1"},
+		{Url: "source2", Data: "data1"},
+		{Url: "source3", Data: "data1"},
+		{Url: "", Data: "data1"},
+		{Url: "source5", Data: "data1"},
+	}
+	err := cts.proc.ProcessAndInsertString(context.Background(), saveData)
+	cts.Equal(&BatchError{Rejected: []DocumentError{{Index: 3, Url: "", Err: ErrEmptyURL}}}, err)
+	cts.Empty(cts.postings("data1"))
+
+	rejected, err := cts.proc.ProcessAndInsertBestEffort(context.Background(), saveData)
+	cts.NoError(err)
+	cts.Equal([]DocumentError{{Index: 3, Url: "", Err: ErrEmptyURL}}, rejected)
+	cts.Equal(map[string][]int{"source1": {0}, "source2": {0}, "source3": {0}, "source5": {0}}, cts.postings("data1"))
+
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData[:3]))
+	cts.Equal(uint64(4), cts.documents(cts.proc.(*SimpleProcessor)))
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_Versions() {
+	version := func(v uint64) *uint64 { return &v }
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+		{Url: "source1", Data: "data1", IfVersion: version(0)},
+		{Url: "source1", Data: "data2"},
+	}))
+	res, err := cts.proc.Get(context.Background(), "source1")
+	cts.NoError(err)
+	cts.Equal(uint64(2), res.Version)
+	cts.Empty(cts.postings("data1"))
+	cts.Equal(map[string][]int{"source1": {0}}, cts.postings("data2"))
+
+	err = cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+		{Url: "source2", Data: "data2"},
+		{Url: "source1", Data: "data3", IfVersion: version(1)},
+	})
+	batchErr, ok := err.(*BatchError)
+	cts.Require().True(ok)
+	cts.Len(batchErr.Rejected, 1)
+	cts.Equal(1, batchErr.Rejected[0].Index)
+	cts.True(errors.Is(batchErr.Rejected[0].Err, ErrVersionConflict))
+	cts.Equal(map[string][]int{"source1": {0}}, cts.postings("data2"))
+
+	rejected, err := cts.proc.ProcessAndInsertBestEffort(context.Background(), []RawData{
+		{Url: "source2", Data: "data2", IfVersion: version(0)},
+		{Url: "source1", Data: "data3", IfVersion: version(1)},
+		{Url: "source1", Data: "data4", IfVersion: version(2)},
+	})
+	cts.NoError(err)
+	cts.Len(rejected, 1)
+	cts.Equal(1, rejected[0].Index)
+	cts.Equal(map[string][]int{"source2": {0}}, cts.postings("data2"))
+	cts.Equal(map[string][]int{"source1": {0}}, cts.postings("data4"))
+
+	_, err = cts.proc.Get(context.Background(), "source3")
+	cts.Equal(ErrDocumentNotFound, err)
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_MultiGet() {
+	now := time.Now()
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+		{Url: "source1", Data: "data1", Source: Source{Date: now, Title: "first"}},
+		{Url: "source2", Data: "data2", Source: Source{Date: now, Title: "second"}},
+	}))
+	res, err := cts.proc.MultiGet(context.Background(), []string{"source2", "source3", "source1"})
+	cts.NoError(err)
+	cts.Equal([]GetResult{
+		{Url: "source2", Found: true, Source: &Source{Date: now.Round(0), Title: "second", Version: 1}},
+		{Url: "source3"},
+		{Url: "source1", Found: true, Source: &Source{Date: now.Round(0), Title: "first", Version: 1}},
+	}, res)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cts.proc.MultiGet(ctx, []string{"source1"})
+	cts.Equal(context.Canceled, err)
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_ProcessAndInsertStringChunks() {
+	// The chunk size is 2, so source1 is written again after postings of the first chunk are flushed.
+	saveData := []RawData{
+		{Url: "source1", Data: "data1 data2"},
+		{Url: "source2", Data: "data2"},
+		{Url: "source1", Data: "data3"},
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	cts.Empty(cts.postings("data1"))
+	cts.Equal(map[string][]int{"source2": {0}}, cts.postings("data2"))
+	cts.Equal(map[string][]int{"source1": {0}}, cts.postings("data3"))
+	cts.Equal(uint64(2), cts.documents(cts.proc.(*SimpleProcessor)))
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_DocumentCount() {
+	saveData := []RawData{{Url: "source1", Data: "data1"}, {Url: "source2", Data: "data2"}}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData[:1]))
+	cts.Equal(uint64(2), cts.documents(cts.proc.(*SimpleProcessor)))
+}
+
+func (cts *processorTestSuite) TestNutsRepository_GetCanceled() {
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{{Url: "source1", Data: "data1"}}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cts.proc.ProcessAndGet(ctx, "data1", 10, 0)
+	cts.Equal(context.Canceled, err)
+	cts.Equal(context.Canceled, cts.proc.ProcessAndInsertString(ctx, []RawData{{Url: "source2", Data: "data2"}}))
+	_, err = cts.proc.ProcessAndInsertBestEffort(ctx, []RawData{{Url: "source2", Data: "data2"}})
+	cts.Equal(&ChunkError{Processed: 0, Err: context.Canceled}, err)
+}
+
+// documents returns the number of documents saved by the processor.
+func (cts *processorTestSuite) documents(proc *SimpleProcessor) uint64 {
+	var ids []storage.Entry
+	cts.NoError(proc.store.View(func(tx storage.Tx) (err error) {
+		ids, err = tx.PrefixScan(proc.index(tx).b.id, nil, -1)
+		return err
+	}))
+	return uint64(len(ids))
+}
+
+// postings returns positions of the token in the test collection by document url.
+func (cts *processorTestSuite) postings(token string) map[string][]int {
+	res := make(map[string][]int)
+	cts.NoError(cts.store.View(func(tx storage.Tx) error {
+		idx := cts.proc.(*SimpleProcessor).index(tx)
+		ids, err := idx.postings(token)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			url, err := idx.url(id)
+			if err != nil {
+				return err
+			}
+			if res[url], err = idx.positions(token, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	return res
+}
+
+// source returns the saved source of the document.
+func (cts *processorTestSuite) source(url string) (s Source) {
+	cts.NoError(cts.store.View(func(tx storage.Tx) error {
+		idx := cts.proc.(*SimpleProcessor).index(tx)
+		id, ok, err := idx.docID(url)
+		if err != nil {
+			return err
+		}
+		cts.True(ok)
+		s, err = idx.source(id)
+		return err
+	}))
+	return s
+}
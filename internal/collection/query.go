@@ -0,0 +1,143 @@
+package collection
+
+import (
+	"strings"
+)
+
+// SearchQuery describes a search request.
+type SearchQuery struct {
+	// Text is the full text query, documents containing the maximum number of its tokens are found.
+	Text string
+	// Fields are conditions on metadata fields, found documents must match all of them.
+	Fields []FieldMatch
+	// Ranges are ranges of numeric and date fields, found documents must match all of them.
+	Ranges []FieldRange
+	// Filters are exact conditions on fields which are not text or on the host of the url (_host),
+	// found documents must match all of them. Unlike Fields they do not change the relevance,
+	// and documents matching them are cached.
+	Filters []FieldMatch
+	// Sort are fields to order found documents by before the relevance.
+	Sort []SortField
+	// Limit and Offset select the page of found documents.
+	Limit  int
+	Offset int
+
+	// parsed are words of the query string which look like conditions, see ParseQuery.
+	parsed []parsedCondition
+}
+
+// parsedCondition is a word of the query string of the form name:value or the words of a range.
+type parsedCondition struct {
+	text  string
+	field FieldMatch
+	rng   *FieldRange
+}
+
+// FieldMatch matches documents with the metadata field equal to the value or containing it, if the field is an array.
+type FieldMatch struct {
+	Name  string
+	Value string
+}
+
+// ParseQuery splits the query string into the full text query, conditions on metadata fields and ranges.
+// Words of the form name:value are conditions, name:[from TO to] are ranges, the rest is the text.
+// A word is a condition only if the name starts with a letter and is a field of the schema or a field
+// of indexed documents, or if the name is the host filter (_host). Other words, such as 10:30 or
+// http://example.com, are the text. Names are checked by the search, so the parsed query keeps such words
+// apart from Fields and Ranges until then.
+// Bounds in square brackets are included, bounds in curly brackets are excluded, * is an open bound:
+//    golang lang:en tags:go words:[100 TO *] published:{2020-01-01 TO 2021-01-01}
+func ParseQuery(q string) SearchQuery {
+	var res SearchQuery
+	var text []string
+	words := strings.Fields(q)
+	for i := 0; i < len(words); i++ {
+		f, ok := ParseFilter(words[i])
+		if !ok || !isLetter(f.Name[0]) && f.Name != hostFilter {
+			text = append(text, words[i])
+			continue
+		}
+		if f.Value[0] == '[' || f.Value[0] == '{' {
+			if r, ok := parseRange(f.Name, f.Value, words[i+1:]); ok {
+				res.parsed = append(res.parsed, parsedCondition{text: strings.Join(words[i:i+3], " "), field: f, rng: &r})
+				i += 2
+				continue
+			}
+		}
+		res.parsed = append(res.parsed, parsedCondition{text: words[i], field: f})
+	}
+	res.Text = strings.Join(text, " ")
+	return res
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// resolveQuery moves parsed conditions on known fields to the conditions of the query and the rest to the text,
+// see ParseQuery.
+func resolveQuery(idx *index, schema *Schema, q SearchQuery) (SearchQuery, error) {
+	text := []string{q.Text}
+	for _, c := range q.parsed {
+		if c.field.Name == hostFilter && c.rng == nil {
+			q.Filters = append(q.Filters, c.field)
+			continue
+		}
+		known := c.field.Name == dataField || c.field.Name == titleField
+		if _, ok := schema.Fields[c.field.Name]; ok {
+			known = true
+		}
+		if !known {
+			var err error
+			if known, err = idx.fieldIndexed(c.field.Name); err != nil {
+				return q, err
+			}
+		}
+		switch {
+		case !known:
+			text = append(text, c.text)
+		case c.rng != nil:
+			q.Ranges = append(q.Ranges, *c.rng)
+		default:
+			q.Fields = append(q.Fields, c.field)
+		}
+	}
+	q.Text = strings.TrimSpace(strings.Join(text, " "))
+	q.parsed = nil
+	return q, nil
+}
+
+// ParseFilter parses the condition of the form name:value, ok is false if the name or the value is missing.
+func ParseFilter(s string) (f FieldMatch, ok bool) {
+	n := strings.IndexByte(s, ':')
+	if n <= 0 || n == len(s)-1 || !fieldNameRe.MatchString(s[:n]) {
+		return f, false
+	}
+	return FieldMatch{Name: s[:n], Value: s[n+1:]}, true
+}
+
+// parseRange parses the range of the field from the opening bracket with the lower bound
+// and the next two words: TO and the upper bound with the closing bracket.
+func parseRange(name, from string, next []string) (FieldRange, bool) {
+	if len(next) < 2 || next[0] != "TO" || len(from) < 2 || len(next[1]) < 2 {
+		return FieldRange{}, false
+	}
+	to := next[1]
+	r := FieldRange{
+		Name:        name,
+		From:        from[1:],
+		To:          to[:len(to)-1],
+		IncludeFrom: from[0] == '[',
+		IncludeTo:   to[len(to)-1] == ']',
+	}
+	if !r.IncludeTo && to[len(to)-1] != '}' {
+		return FieldRange{}, false
+	}
+	if r.From == "*" {
+		r.From = ""
+	}
+	if r.To == "*" {
+		r.To = ""
+	}
+	return r, true
+}
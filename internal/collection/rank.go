@@ -0,0 +1,80 @@
+package collection
+
+import (
+	"container/heap"
+	"time"
+)
+
+// hit describes a document matching a search query.
+type hit struct {
+	id uint64
+	// sort are values of the sort fields, greater values are better, see sortValues.
+	sort  []float64
+	score float64
+	date  time.Time
+}
+
+// better reports whether the hit h should be returned before the hit o.
+func (h hit) better(o hit) bool {
+	for i := range h.sort {
+		if h.sort[i] != o.sort[i] {
+			return h.sort[i] > o.sort[i]
+		}
+	}
+	if h.score != o.score {
+		return h.score > o.score
+	}
+	if !h.date.Equal(o.date) {
+		return h.date.After(o.date)
+	}
+	return h.id < o.id
+}
+
+// hitHeap is a min-heap of hits, the worst hit is on top.
+type hitHeap []hit
+
+func (h hitHeap) Len() int            { return len(h) }
+func (h hitHeap) Less(i, j int) bool  { return h[j].better(h[i]) }
+func (h hitHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *hitHeap) Push(x interface{}) { *h = append(*h, x.(hit)) }
+func (h *hitHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// topK keeps the k best hits pushed to it without storing the rest.
+type topK struct {
+	k    int
+	hits hitHeap
+}
+
+func newTopK(k int) *topK {
+	return &topK{k: k, hits: make(hitHeap, 0, k)}
+}
+
+// push adds the hit if it is better than the worst one kept.
+func (t *topK) push(h hit) {
+	if t.k <= 0 {
+		return
+	}
+	if len(t.hits) < t.k {
+		heap.Push(&t.hits, h)
+		return
+	}
+	if h.better(t.hits[0]) {
+		t.hits[0] = h
+		heap.Fix(&t.hits, 0)
+	}
+}
+
+// sorted returns kept hits from the best to the worst.
+func (t *topK) sorted() []hit {
+	res := make([]hit, len(t.hits))
+	for i := len(res) - 1; i >= 0; i-- {
+		res[i] = heap.Pop(&t.hits).(hit)
+	}
+	return res
+}
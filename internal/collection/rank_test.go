@@ -0,0 +1,93 @@
+package collection
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopK(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		k    int
+		hits []hit
+		want []hit
+	}{
+		{
+			name: "Keeps best hits",
+			k:    2,
+			hits: []hit{
+				{id: 1, date: now.Add(-time.Hour)},
+				{id: 2, date: now},
+				{id: 3, date: now.Add(-time.Minute)},
+			},
+			want: []hit{
+				{id: 2, date: now},
+				{id: 3, date: now.Add(-time.Minute)},
+			},
+		},
+		{
+			name: "Equal dates ordered by id",
+			k:    3,
+			hits: []hit{
+				{id: 3, date: now},
+				{id: 1, date: now},
+				{id: 2, date: now},
+			},
+			want: []hit{
+				{id: 1, date: now},
+				{id: 2, date: now},
+				{id: 3, date: now},
+			},
+		},
+		{
+			name: "Score before date",
+			k:    3,
+			hits: []hit{
+				{id: 1, score: 1, date: now},
+				{id: 2, score: 2, date: now.Add(-time.Hour)},
+				{id: 3, score: 1, date: now.Add(time.Hour)},
+			},
+			want: []hit{
+				{id: 2, score: 2, date: now.Add(-time.Hour)},
+				{id: 3, score: 1, date: now.Add(time.Hour)},
+				{id: 1, score: 1, date: now},
+			},
+		},
+		{
+			name: "Empty",
+			k:    0,
+			hits: []hit{{id: 1, date: now}},
+			want: []hit{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top := newTopK(tt.k)
+			for _, h := range tt.hits {
+				top.push(h)
+			}
+			assert.Equal(t, tt.want, top.sorted())
+		})
+	}
+}
+
+func TestDate_EncodeDecode(t *testing.T) {
+	dates := []time.Time{
+		{},
+		time.Date(1500, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1969, 12, 31, 23, 59, 59, 999, time.UTC),
+		time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 5, 12, 10, 0, 0, 1, time.UTC),
+		time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for i, date := range dates {
+		assert.True(t, date.Equal(decodeDate(encodeDate(date))), date)
+		if i > 0 {
+			assert.Equal(t, -1, bytes.Compare(encodeDate(dates[i-1]), encodeDate(date)), date)
+		}
+	}
+}
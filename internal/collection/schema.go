@@ -0,0 +1,325 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/polyse/database/internal/storage"
+)
+
+var (
+	// ErrInvalidSchema error to return if a schema has a wrong field name or type.
+	ErrInvalidSchema = errors.New("invalid schema")
+
+	schemaKey = []byte("schema")
+)
+
+// FieldType is the type of a metadata field, it defines how values of the field are checked and indexed.
+type FieldType string
+
+// Field types of a schema.
+const (
+	// TypeText fields are analyzed like the data of documents, they match by any of their tokens.
+	TypeText FieldType = "text"
+	// TypeKeyword fields are strings matching exactly.
+	TypeKeyword FieldType = "keyword"
+	// TypeDate fields are RFC 3339 dates, they are saved in UTC.
+	TypeDate FieldType = "date"
+	// TypeInteger fields are whole numbers.
+	TypeInteger FieldType = "integer"
+	// TypeFloat fields are numbers.
+	TypeFloat FieldType = "float"
+	// TypeBool fields are booleans.
+	TypeBool FieldType = "bool"
+)
+
+// dateLayouts are layouts of dates accepted in documents and search queries.
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02"}
+
+// FieldMapping describes a field of a schema. Fields are indexed and stored unless Index or Store is false:
+// a field which is not indexed can not be searched, a field which is not stored is not returned with the document.
+// Boost multiplies the score of matches in a text field, it is 1 by default and 2 for the title.
+type FieldMapping struct {
+	Type  FieldType `json:"type"`
+	Index *bool     `json:"index,omitempty"`
+	Store *bool     `json:"store,omitempty"`
+	Boost float64   `json:"boost,omitempty"`
+}
+
+func (m FieldMapping) indexed() bool {
+	return m.Index == nil || *m.Index
+}
+
+func (m FieldMapping) stored() bool {
+	return m.Store == nil || *m.Store
+}
+
+// normalize checks the scalar value of the field and returns it in the saved form and its term.
+// Fields missing in the schema have no type, they accept any scalar value.
+func (m FieldMapping) normalize(v interface{}) (interface{}, string, error) {
+	switch v := v.(type) {
+	case int:
+		return m.normalize(float64(v))
+	case int64:
+		return m.normalize(float64(v))
+	}
+	wrong := fmt.Errorf("%w: %T value for %s field", ErrInvalidField, v, m.Type)
+	switch m.Type {
+	case TypeText, TypeKeyword:
+		s, ok := v.(string)
+		if !ok {
+			return nil, "", wrong
+		}
+		return s, s, nil
+	case TypeDate:
+		s, ok := v.(string)
+		if !ok {
+			return nil, "", wrong
+		}
+		t, err := parseDate(s)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidField, err)
+		}
+		s = t.Format(time.RFC3339Nano)
+		return s, s, nil
+	case TypeInteger, TypeFloat:
+		f, ok := v.(float64)
+		if !ok {
+			return nil, "", wrong
+		}
+		if m.Type == TypeInteger && (f != math.Trunc(f) || math.Abs(f) > 1<<53) {
+			return nil, "", fmt.Errorf("%w: %v is not an integer", ErrInvalidField, f)
+		}
+		s, _ := formatFieldValue(f)
+		return f, s, nil
+	case TypeBool:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, "", wrong
+		}
+		return b, strconv.FormatBool(b), nil
+	default:
+		s, ok := formatFieldValue(v)
+		if !ok {
+			return nil, "", fmt.Errorf("%w: %T value", ErrInvalidField, v)
+		}
+		return v, s, nil
+	}
+}
+
+// parse converts the value of a search condition to the type of the field.
+func (m FieldMapping) parse(s string) (interface{}, error) {
+	switch m.Type {
+	case TypeInteger, TypeFloat:
+		return strconv.ParseFloat(s, 64)
+	case TypeBool:
+		return strconv.ParseBool(s)
+	default:
+		return s, nil
+	}
+}
+
+// Schema describes metadata fields of documents of a collection. Fields missing in the schema are indexed
+// as keywords of any scalar type, unless the schema is strict, then documents with such fields are rejected.
+// The data and the title of documents are text fields, they can be added to the schema only to set boosts.
+// Retention is the retention policy of the collection as a duration like "720h": documents with the date older
+// than it are expired, they are not returned by reads and are deleted by the sweeper. Documents are kept forever
+// if it is empty.
+type Schema struct {
+	Fields    map[string]FieldMapping `json:"fields"`
+	Strict    bool                    `json:"strict"`
+	Retention string                  `json:"retention,omitempty"`
+}
+
+// validate checks names and types of the fields and the retention period.
+func (s *Schema) validate() error {
+	if s.Retention != "" {
+		if d, err := time.ParseDuration(s.Retention); err != nil || d <= 0 {
+			return fmt.Errorf("%w: retention must be a positive duration, got %q", ErrInvalidSchema, s.Retention)
+		}
+	}
+	for name, m := range s.Fields {
+		if !fieldNameRe.MatchString(name) || name == hostFilter {
+			return fmt.Errorf("%w: wrong field name %q", ErrInvalidSchema, name)
+		}
+		switch m.Type {
+		case TypeText, TypeKeyword, TypeDate, TypeInteger, TypeFloat, TypeBool:
+		default:
+			return fmt.Errorf("%w: field %s has unknown type %q", ErrInvalidSchema, name, m.Type)
+		}
+		if m.Boost < 0 {
+			return fmt.Errorf("%w: field %s has negative boost", ErrInvalidSchema, name)
+		}
+		if (name == dataField || name == titleField) && (m.Type != TypeText || m.Index != nil || m.Store != nil) {
+			return fmt.Errorf("%w: only boost of the %s field can be set", ErrInvalidSchema, name)
+		}
+	}
+	return nil
+}
+
+// mapping returns the mapping of the field, ok is false if the field is not allowed.
+func (s *Schema) mapping(name string) (m FieldMapping, ok bool) {
+	if m, ok = s.Fields[name]; ok {
+		return m, true
+	}
+	if name == dataField || name == titleField {
+		return FieldMapping{Type: TypeText}, true
+	}
+	return FieldMapping{}, !s.Strict && fieldNameRe.MatchString(name)
+}
+
+// retention returns the retention period of the collection, 0 if documents are kept forever.
+func (s *Schema) retention() time.Duration {
+	d, _ := time.ParseDuration(s.Retention)
+	return d
+}
+
+// boost returns the boost of matches in the field.
+func (s *Schema) boost(name string) float64 {
+	if m := s.Fields[name]; m.Boost > 0 {
+		return m.Boost
+	}
+	if name == titleField {
+		return defaultTitleBoost
+	}
+	return defaultBoost
+}
+
+// Schema returns the schema of the collection, the schema of a new collection is empty.
+func (p *SimpleProcessor) Schema(ctx context.Context) (s Schema, err error) {
+	err = p.store.View(func(tx storage.Tx) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s, err = p.index(tx).schema()
+		return err
+	})
+	return s, err
+}
+
+// SetSchema checks and saves the schema of the collection. Documents are checked and indexed
+// using the schema from the next save, saved documents are not changed.
+func (p *SimpleProcessor) SetSchema(ctx context.Context, s Schema) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	return p.store.Update(func(tx storage.Tx) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return p.index(tx).putSchema(s)
+	})
+}
+
+// analyzeFields checks fields of the document against the schema and returns fields to save and their tokens.
+// Text fields get tokens of their text, other fields get tokens of their values.
+// Positions of tokens continue through values of arrays.
+func (p *SimpleProcessor) analyzeFields(schema *Schema, fields Fields) (Fields, map[string][]int, error) {
+	var stored Fields
+	terms := make(map[string][]int)
+	for name, v := range fields {
+		if name == dataField || name == titleField || name == hostFilter {
+			return nil, nil, fmt.Errorf("%w: field name %q is reserved", ErrInvalidField, name)
+		}
+		m, ok := schema.mapping(name)
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: field %q is not in the schema", ErrInvalidField, name)
+		}
+		values, isArray := v.([]interface{})
+		if !isArray {
+			values = []interface{}{v}
+		}
+		saved := make([]interface{}, 0, len(values))
+		pos := 0
+		for _, v := range values {
+			v, term, err := m.normalize(v)
+			if err != nil {
+				return nil, nil, fmt.Errorf("field %s: %w", name, err)
+			}
+			saved = append(saved, v)
+			if !m.indexed() {
+				continue
+			}
+			if f, ok := m.numericValue(v); ok {
+				terms[numericTerm(name, f)] = nil
+			}
+			if m.Type != TypeText {
+				terms[fieldTerm(name, term)] = append(terms[fieldTerm(name, term)], pos)
+				pos++
+				continue
+			}
+			for _, token := range p.tokenizer(term, p.filters...) {
+				terms[fieldTerm(name, token)] = append(terms[fieldTerm(name, token)], pos)
+				pos++
+			}
+		}
+		if !m.stored() {
+			continue
+		}
+		if stored == nil {
+			stored = make(Fields, len(fields))
+		}
+		if isArray {
+			stored[name] = saved
+		} else {
+			stored[name] = saved[0]
+		}
+	}
+	return stored, terms, nil
+}
+
+// fieldTerms returns tokens a document must contain to match the condition on the field.
+func (p *SimpleProcessor) fieldTerms(schema *Schema, f FieldMatch) []string {
+	m, _ := schema.mapping(f.Name)
+	v, err := m.parse(f.Value)
+	if err != nil {
+		return []string{fieldTerm(f.Name, f.Value)}
+	}
+	_, term, err := m.normalize(v)
+	if err != nil {
+		return []string{fieldTerm(f.Name, f.Value)}
+	}
+	if m.Type != TypeText {
+		return []string{fieldTerm(f.Name, term)}
+	}
+	var terms []string
+	for _, token := range p.tokenizer(term, p.filters...) {
+		terms = append(terms, fieldTerm(f.Name, token))
+	}
+	return terms
+}
+
+// schema returns the saved schema of the collection.
+func (idx *index) schema() (s Schema, err error) {
+	v, err := idx.tx.Get(idx.b.meta, schemaKey)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return s, nil
+		}
+		return s, err
+	}
+	err = json.Unmarshal(v, &s)
+	return s, err
+}
+
+func (idx *index) putSchema(s Schema) error {
+	v, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return idx.tx.Put(idx.b.meta, schemaKey, v, 0)
+}
+
+func parseDate(s string) (t time.Time, err error) {
+	for _, layout := range dateLayouts {
+		if t, err = time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return t, err
+}
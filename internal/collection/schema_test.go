@@ -0,0 +1,91 @@
+package collection
+
+import (
+	"context"
+	"errors"
+)
+
+func (cts *processorTestSuite) TestSimpleProcessor_Schema() {
+	no := false
+	schema := Schema{
+		Strict: true,
+		Fields: map[string]FieldMapping{
+			"author":    {Type: TypeText},
+			"lang":      {Type: TypeKeyword},
+			"published": {Type: TypeDate},
+			"words":     {Type: TypeInteger, Store: &no},
+			"rating":    {Type: TypeFloat},
+			"draft":     {Type: TypeBool},
+			"cover":     {Type: TypeKeyword, Index: &no},
+		},
+	}
+	cts.True(errors.Is(cts.proc.SetSchema(context.Background(), Schema{
+		Fields: map[string]FieldMapping{"lang": {Type: "string"}},
+	}), ErrInvalidSchema))
+	for _, retention := range []string{"month", "-1h", "0s"} {
+		cts.True(errors.Is(cts.proc.SetSchema(context.Background(), Schema{Retention: retention}), ErrInvalidSchema))
+	}
+	s, err := cts.proc.Schema(context.Background())
+	cts.NoError(err)
+	cts.Equal(Schema{}, s)
+	cts.NoError(cts.proc.SetSchema(context.Background(), schema))
+	s, err = cts.proc.Schema(context.Background())
+	cts.NoError(err)
+	cts.Equal(schema, s)
+
+	saveData := []RawData{
+		{Url: "source1", Data: "data1", Source: Source{Fields: Fields{
+			"author":    "John Smith",
+			"lang":      "en",
+			"published": "2020-05-12T03:00:00+03:00",
+			"words":     120,
+			"rating":    4.5,
+			"draft":     false,
+			"cover":     "cover.png",
+		}}},
+		{Url: "source2", Data: "data1", Source: Source{Fields: Fields{"author": []interface{}{"Jane Smith", "John Doe"}}}},
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	res, err := cts.proc.Get(context.Background(), "source1")
+	cts.NoError(err)
+	cts.Equal(Fields{
+		"author":    "John Smith",
+		"lang":      "en",
+		"published": "2020-05-12T00:00:00Z",
+		"rating":    4.5,
+		"draft":     false,
+		"cover":     "cover.png",
+	}, res.Fields)
+
+	urls := func(query string) []string {
+		res, err := cts.proc.ProcessAndGet(context.Background(), query, 10, 0)
+		cts.NoError(err)
+		var urls []string
+		for _, r := range res {
+			urls = append(urls, r.Url)
+		}
+		return urls
+	}
+	cts.ElementsMatch([]string{"source1", "source2"}, urls("author:smith"))
+	cts.Equal([]string{"source2"}, urls("author:doe"))
+	cts.Equal([]string{"source1"}, urls("published:2020-05-12"))
+	cts.Equal([]string{"source1"}, urls("words:120.0"))
+	cts.Equal([]string{"source1"}, urls("rating:4.50 draft:false"))
+	cts.Empty(urls("cover:cover.png"))
+	cts.Empty(urls("words:abc"))
+
+	rejected, err := cts.proc.ProcessAndInsertBestEffort(context.Background(), []RawData{
+		{Url: "source3", Data: "data1", Source: Source{Fields: Fields{"words": 1.5}}},
+		{Url: "source4", Data: "data1", Source: Source{Fields: Fields{"published": "yesterday"}}},
+		{Url: "source5", Data: "data1", Source: Source{Fields: Fields{"draft": "no"}}},
+		{Url: "source6", Data: "data1", Source: Source{Fields: Fields{"unknown": "value"}}},
+		{Url: "source7", Data: "data1", Source: Source{Fields: Fields{"lang": []interface{}{"en", 1.0}}}},
+		{Url: "source8", Data: "data1", Source: Source{Fields: Fields{"words": 3}}},
+	})
+	cts.NoError(err)
+	cts.Len(rejected, 5)
+	for _, r := range rejected {
+		cts.True(errors.Is(r.Err, ErrInvalidField), r.Err.Error())
+	}
+	cts.Equal([]string{"source8"}, urls("words:3"))
+}
@@ -0,0 +1,267 @@
+package collection
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/polyse/database/internal/storage"
+)
+
+// maxOpenScrolls limits the number of scrolls open at once in a collection.
+const maxOpenScrolls = 100
+
+var (
+	// ErrInvalidCursor error to return if the cursor of a listing is malformed.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrScrollNotFound error to return if the scroll does not exist or has expired.
+	ErrScrollNotFound = errors.New("scroll does not exist or has expired")
+	// ErrTooManyScrolls error to return if a new scroll can not be opened because of the limit.
+	ErrTooManyScrolls = errors.New("too many open scrolls")
+)
+
+// DocumentPage is a page of documents of a collection listed in the order they were added in.
+// Next is the cursor of the next page, it is empty on the last page.
+type DocumentPage struct {
+	Docs []ResponseData `json:"docs"`
+	Next string         `json:"next,omitempty"`
+}
+
+// ScrollPage is a page of documents of a scroll. Total is the number of documents in the collection when
+// the scroll was opened, expired documents not yet swept included. ScrollID is empty on the last page, the scroll is closed then.
+type ScrollPage struct {
+	ScrollID string         `json:"scroll_id,omitempty"`
+	Total    int            `json:"total"`
+	Docs     []ResponseData `json:"docs"`
+}
+
+// List returns at most limit documents added after the document of the cursor, an empty cursor starts
+// from the first document. Documents added while listing are returned on later pages, re-saved documents keep
+// their place. Expired documents are skipped, so a page may have less than limit documents. An error wrapping ErrInvalidCursor is returned if the cursor is malformed.
+func (p *SimpleProcessor) List(ctx context.Context, cursor string, limit int) (page DocumentPage, err error) {
+	if limit < 1 {
+		limit = 10
+	}
+	var after uint64
+	if cursor != "" {
+		if after, err = strconv.ParseUint(cursor, 10, 64); err != nil || after == math.MaxUint64 {
+			return page, fmt.Errorf("%w %q", ErrInvalidCursor, cursor)
+		}
+	}
+	err = p.store.View(func(tx storage.Tx) error {
+		idx := p.index(tx)
+		schema, err := idx.schema()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		es, err := idx.urlsAfter(after, limit+1)
+		if err != nil {
+			return err
+		}
+		more := len(es) > limit
+		if more {
+			es = es[:limit]
+		}
+		page.Docs = make([]ResponseData, 0, len(es))
+		var id uint64
+		for _, e := range es {
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+			if id, err = decodeID(e.Key); err != nil {
+				return err
+			}
+			s, err := idx.source(id)
+			if err != nil {
+				return err
+			}
+			if expiredSource(&schema, &s, now) {
+				continue
+			}
+			page.Docs = append(page.Docs, ResponseData{Source: s, Url: string(e.Value)})
+		}
+		if more {
+			page.Next = strconv.FormatUint(id, 10)
+		}
+		return nil
+	})
+	return page, err
+}
+
+// Scroll returns the next page of at most limit documents of the scroll and keeps the scroll open for keepAlive.
+// An empty scroll id opens a new scroll over all documents of the collection. The set of documents of a scroll
+// is fixed when it is opened: documents added later are not returned, deleted and expired ones are skipped
+// and updated ones are returned in their current version. ErrScrollNotFound is returned if the scroll has expired.
+func (p *SimpleProcessor) Scroll(
+	ctx context.Context,
+	scrollID string,
+	limit int,
+	keepAlive time.Duration,
+) (page ScrollPage, err error) {
+	if limit < 1 {
+		limit = 10
+	}
+	if scrollID == "" {
+		var ids []uint64
+		if err = p.store.View(func(tx storage.Tx) error {
+			es, err := tx.PrefixScan(p.index(tx).b.url, nil, -1)
+			if err != nil {
+				return err
+			}
+			ids = make([]uint64, len(es))
+			for i, e := range es {
+				if ids[i], err = decodeID(e.Key); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return page, err
+		}
+		if scrollID, err = p.scrolls.open(ids, keepAlive); err != nil {
+			return page, err
+		}
+	}
+	ids, pos, total, err := p.scrolls.take(scrollID, limit)
+	if err != nil {
+		return page, err
+	}
+	page.Total = total
+	page.Docs = make([]ResponseData, 0, len(ids))
+	if err = p.store.View(func(tx storage.Tx) error {
+		idx := p.index(tx)
+		schema, err := idx.schema()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			url, err := idx.url(id)
+			if err == storage.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			s, err := idx.source(id)
+			if err != nil {
+				return err
+			}
+			if expiredSource(&schema, &s, now) {
+				continue
+			}
+			page.Docs = append(page.Docs, ResponseData{Source: s, Url: url})
+		}
+		return nil
+	}); err != nil {
+		return page, err
+	}
+	if p.scrolls.advance(scrollID, pos, len(ids), keepAlive) {
+		page.ScrollID = scrollID
+	}
+	return page, nil
+}
+
+// ClearScroll closes the scroll, it reports whether the scroll was open.
+func (p *SimpleProcessor) ClearScroll(scrollID string) bool {
+	return p.scrolls.close(scrollID)
+}
+
+// scroll is a snapshot of ids of documents and the position of the next page in it.
+type scroll struct {
+	ids     []uint64
+	pos     int
+	expires time.Time
+}
+
+// scrolls keeps open scrolls of a collection in memory until they expire.
+type scrolls struct {
+	mu   sync.Mutex
+	byID map[string]*scroll
+}
+
+// open opens a scroll over the ids and returns its id.
+func (s *scrolls) open(ids []uint64, keepAlive time.Duration) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropExpired(time.Now())
+	if len(s.byID) >= maxOpenScrolls {
+		return "", ErrTooManyScrolls
+	}
+	if s.byID == nil {
+		s.byID = make(map[string]*scroll)
+	}
+	s.byID[id] = &scroll{ids: ids, expires: time.Now().Add(keepAlive)}
+	return id, nil
+}
+
+// take returns at most limit ids of the next page of the scroll, its position and the number of all ids.
+// The scroll is moved to the next page by advance, so a failed page can be requested again.
+func (s *scrolls) take(id string, limit int) ([]uint64, int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropExpired(time.Now())
+	sc, ok := s.byID[id]
+	if !ok {
+		return nil, 0, 0, ErrScrollNotFound
+	}
+	end := sc.pos + limit
+	if end > len(sc.ids) {
+		end = len(sc.ids)
+	}
+	return sc.ids[sc.pos:end], sc.pos, len(sc.ids), nil
+}
+
+// advance moves the scroll past n ids from the position and extends it for keepAlive.
+// The scroll is closed if no ids are left, advance reports whether it is still open.
+func (s *scrolls) advance(id string, pos, n int, keepAlive time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.byID[id]
+	if !ok {
+		return false
+	}
+	if sc.pos == pos {
+		sc.pos += n
+	}
+	if sc.pos >= len(sc.ids) {
+		delete(s.byID, id)
+		return false
+	}
+	sc.expires = time.Now().Add(keepAlive)
+	return true
+}
+
+// close closes the scroll, it reports whether the scroll was open.
+func (s *scrolls) close(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.byID[id]
+	delete(s.byID, id)
+	return ok
+}
+
+// dropExpired closes scrolls not extended in time, it must be called with the lock held.
+func (s *scrolls) dropExpired(now time.Time) {
+	for id, sc := range s.byID {
+		if now.After(sc.expires) {
+			delete(s.byID, id)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/polyse/database/internal/storage"
+)
+
+func (cts *processorTestSuite) TestSimpleProcessor_List() {
+	var saveData []RawData
+	for i := 1; i <= 5; i++ {
+		saveData = append(saveData, RawData{Url: fmt.Sprintf("source%d", i), Data: "data"})
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	_, err := cts.proc.DeleteByQuery(context.Background(), DeleteQuery{UrlPrefix: "source2"})
+	cts.NoError(err)
+
+	var urls []string
+	cursor := ""
+	for i := 0; i == 0 || cursor != ""; i++ {
+		cts.Require().Less(i, 3)
+		page, err := cts.proc.List(context.Background(), cursor, 2)
+		cts.NoError(err)
+		for _, d := range page.Docs {
+			urls = append(urls, d.Url)
+		}
+		cursor = page.Next
+		if i == 0 {
+			// Re-saved documents keep their place, new documents are listed last.
+			cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+				{Url: "source1", Data: "data"},
+				{Url: "source6", Data: "data"},
+			}))
+		}
+	}
+	cts.Equal([]string{"source1", "source3", "source4", "source5", "source6"}, urls)
+
+	_, err = cts.proc.List(context.Background(), "first", 2)
+	cts.True(errors.Is(err, ErrInvalidCursor))
+}
+
+// scanCountingTx counts entries returned by range scans.
+type scanCountingTx struct {
+	storage.Tx
+	scanned int
+}
+
+func (tx *scanCountingTx) RangeScan(bucket string, start, end []byte) ([]storage.Entry, error) {
+	es, err := tx.Tx.RangeScan(bucket, start, end)
+	tx.scanned += len(es)
+	return es, err
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_ListScansPage() {
+	var saveData []RawData
+	for i := 1; i <= 100; i++ {
+		saveData = append(saveData, RawData{Url: fmt.Sprintf("source%03d", i), Data: "data"})
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	_, err := cts.proc.DeleteByQuery(context.Background(), DeleteQuery{UrlPrefix: "source01"})
+	cts.NoError(err)
+
+	cts.NoError(cts.store.View(func(tx storage.Tx) error {
+		ctx := &scanCountingTx{Tx: tx}
+		idx := cts.proc.(*SimpleProcessor).index(ctx)
+		es, err := idx.urlsAfter(0, 3)
+		cts.NoError(err)
+		cts.Len(es, 3)
+		cts.Equal(3, ctx.scanned)
+
+		// Gaps of deleted documents are skipped by growing windows.
+		ctx.scanned = 0
+		es, err = idx.urlsAfter(8, 3)
+		cts.NoError(err)
+		cts.Require().Len(es, 3)
+		cts.Equal("source009", string(es[0].Value))
+		cts.Equal("source020", string(es[1].Value))
+		cts.Equal("source021", string(es[2].Value))
+		cts.LessOrEqual(ctx.scanned, 7)
+
+		es, err = idx.urlsAfter(99, 3)
+		cts.NoError(err)
+		cts.Equal("source100", string(es[0].Value))
+		cts.Len(es, 1)
+		return nil
+	}))
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_Scroll() {
+	var saveData []RawData
+	for i := 1; i <= 5; i++ {
+		saveData = append(saveData, RawData{Url: fmt.Sprintf("source%d", i), Data: "data"})
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+
+	page, err := cts.proc.Scroll(context.Background(), "", 2, time.Minute)
+	cts.NoError(err)
+	cts.Equal(5, page.Total)
+	cts.Len(page.Docs, 2)
+	cts.NotEmpty(page.ScrollID)
+	scrollID := page.ScrollID
+
+	// The scroll sees documents of the moment it was opened in their current version.
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+		{Url: "source4", Data: "data"},
+		{Url: "source6", Data: "data"},
+	}))
+	_, err = cts.proc.DeleteByQuery(context.Background(), DeleteQuery{UrlPrefix: "source3"})
+	cts.NoError(err)
+	page, err = cts.proc.Scroll(context.Background(), scrollID, 2, time.Minute)
+	cts.NoError(err)
+	cts.Equal(scrollID, page.ScrollID)
+	cts.Len(page.Docs, 1)
+	cts.Equal("source4", page.Docs[0].Url)
+	cts.Equal(uint64(2), page.Docs[0].Version)
+	page, err = cts.proc.Scroll(context.Background(), scrollID, 2, time.Minute)
+	cts.NoError(err)
+	cts.Empty(page.ScrollID)
+	cts.Len(page.Docs, 1)
+	cts.Equal("source5", page.Docs[0].Url)
+	_, err = cts.proc.Scroll(context.Background(), scrollID, 2, time.Minute)
+	cts.Equal(ErrScrollNotFound, err)
+
+	page, err = cts.proc.Scroll(context.Background(), "", 2, time.Minute)
+	cts.NoError(err)
+	cts.Equal(5, page.Total)
+	cts.True(cts.proc.ClearScroll(page.ScrollID))
+	cts.False(cts.proc.ClearScroll(page.ScrollID))
+
+	// Scrolls expire if they are not extended in time.
+	page, err = cts.proc.Scroll(context.Background(), "", 2, time.Minute)
+	cts.NoError(err)
+	page, err = cts.proc.Scroll(context.Background(), page.ScrollID, 2, -time.Second)
+	cts.NoError(err)
+	_, err = cts.proc.Scroll(context.Background(), page.ScrollID, 2, time.Minute)
+	cts.Equal(ErrScrollNotFound, err)
+}
@@ -0,0 +1,256 @@
+package collection
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Names of the term spaces of the document text and title. They can be used in field-scoped queries
+// and in the schema to set boosts, but not as names of metadata fields.
+const (
+	dataField  = "data"
+	titleField = "title"
+)
+
+// Default boosts of matches in term spaces.
+const (
+	defaultBoost      = 1.0
+	defaultTitleBoost = 2.0
+)
+
+// queryWord is a token of the search query with its terms in every term space it is searched in
+// and boosts of matches in these spaces.
+type queryWord struct {
+	terms  []string
+	boosts []float64
+}
+
+// analyzedQuery is a search query prepared for searching in the index.
+type analyzedQuery struct {
+	// words are searched in the index, found documents contain the maximum number of them.
+	words []queryWord
+	// terms are tokens of exact conditions on fields, found documents contain all of them.
+	terms []string
+	// ranges are ranges of values of fields, found documents match all of them.
+	ranges []numericRange
+	// filterTerms are tokens of filters, found documents contain all of them.
+	filterTerms []string
+	// sets are ids of documents matching every filter, see SimpleProcessor.filterSets.
+	sets [][]uint64
+}
+
+// analyzeQuery splits the query into words, tokens of conditions on fields which are not text, ranges and filters.
+// Words of the text are searched in the data and the title, words of conditions on text fields only in the field.
+// An error wrapping ErrInvalidField is returned if a range or a filter is wrong.
+func (p *SimpleProcessor) analyzeQuery(schema *Schema, q SearchQuery) (aq analyzedQuery, err error) {
+	seen := make(map[string]struct{})
+	add := func(w queryWord) {
+		key := strings.Join(w.terms, "\x00")
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			aq.words = append(aq.words, w)
+		}
+	}
+	for _, token := range p.tokenizer(q.Text, p.filters...) {
+		add(queryWord{
+			terms:  []string{token, fieldTerm(titleField, token)},
+			boosts: []float64{schema.boost(dataField), schema.boost(titleField)},
+		})
+	}
+	for _, f := range q.Fields {
+		if m, _ := schema.mapping(f.Name); m.Type != TypeText {
+			aq.terms = append(aq.terms, p.fieldTerms(schema, f)...)
+			continue
+		}
+		for _, token := range p.tokenizer(f.Value, p.filters...) {
+			term := fieldTerm(f.Name, token)
+			if f.Name == dataField {
+				term = token
+			}
+			add(queryWord{terms: []string{term}, boosts: []float64{schema.boost(f.Name)}})
+		}
+	}
+	for _, r := range q.Ranges {
+		nr, err := schema.numericRange(r)
+		if err != nil {
+			return aq, err
+		}
+		aq.ranges = append(aq.ranges, nr)
+	}
+	for _, f := range q.Filters {
+		term, err := p.filterTerm(schema, f)
+		if err != nil {
+			return aq, err
+		}
+		aq.filterTerms = append(aq.filterTerms, term)
+	}
+	return aq, nil
+}
+
+// filter keeps ids of documents matching all exact conditions, ranges and filters in the lists found by words.
+// If the query has no words, the only list of all documents matching the conditions is returned.
+// Filter sets must be loaded before.
+func (aq *analyzedQuery) filter(ctx context.Context, idx *index, lists [][]uint64) ([][]uint64, error) {
+	if len(aq.terms) == 0 && len(aq.ranges) == 0 && len(aq.sets) == 0 {
+		return lists, nil
+	}
+	var matched []uint64
+	first := true
+	intersect := func(ids []uint64) {
+		if first {
+			matched, first = ids, false
+		} else {
+			matched = intersectPostings(matched, ids)
+		}
+	}
+	for _, ids := range aq.sets {
+		intersect(ids)
+	}
+	for _, term := range aq.terms {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ids, err := idx.postings(term)
+		if err != nil {
+			return nil, err
+		}
+		intersect(ids)
+	}
+	for _, r := range aq.ranges {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ids, err := idx.numericIDs(r)
+		if err != nil {
+			return nil, err
+		}
+		intersect(ids)
+	}
+	if len(aq.words) == 0 {
+		return [][]uint64{matched}, nil
+	}
+	res := lists[:0]
+	for _, list := range lists {
+		if list = intersectPostings(list, matched); len(list) > 0 {
+			res = append(res, list)
+		}
+	}
+	return res, nil
+}
+
+// matchTokens reports whether sorted tokens of the document match all exact conditions, ranges and filters
+// and returns the number of words they contain.
+func (aq *analyzedQuery) matchTokens(tokens []string) (int, bool) {
+	for _, term := range aq.filterTerms {
+		if !containsToken(tokens, term) {
+			return 0, false
+		}
+	}
+	for _, term := range aq.terms {
+		if !containsToken(tokens, term) {
+			return 0, false
+		}
+	}
+	for _, r := range aq.ranges {
+		found := false
+		for i := sort.SearchStrings(tokens, r.prefix); i < len(tokens) && strings.HasPrefix(tokens[i], r.prefix); i++ {
+			if r.containsToken(tokens[i]) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	count := 0
+	for _, w := range aq.words {
+		for _, term := range w.terms {
+			if containsToken(tokens, term) {
+				count++
+				break
+			}
+		}
+	}
+	return count, true
+}
+
+// wordPostings are postings of every term of a query word and their union.
+type wordPostings struct {
+	word  queryWord
+	lists [][]uint64
+	union []uint64
+}
+
+// findWords returns postings of the words found in the index, words not found in any term space are skipped.
+func findWords(ctx context.Context, idx *index, words []queryWord) ([]wordPostings, error) {
+	res := make([]wordPostings, 0, len(words))
+	for _, w := range words {
+		wp := wordPostings{word: w, lists: make([][]uint64, len(w.terms))}
+		for i, term := range w.terms {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			ids, err := idx.postings(term)
+			if err != nil {
+				return nil, err
+			}
+			wp.lists[i] = ids
+			wp.union = mergePostings(wp.union, ids)
+		}
+		if len(wp.union) == 0 {
+			log.Debug().Str("collection", idx.b.data).Strs("terms", w.terms).Msg("key not found")
+			continue
+		}
+		res = append(res, wp)
+	}
+	return res, nil
+}
+
+// unions returns unions of postings of every word.
+func unions(wps []wordPostings) [][]uint64 {
+	lists := make([][]uint64, len(wps))
+	for i := range wps {
+		lists[i] = wps[i].union
+	}
+	return lists
+}
+
+// score returns the sum of the best boosts of term spaces of every word the document matches in.
+func score(wps []wordPostings, id uint64) float64 {
+	total := 0.0
+	for _, wp := range wps {
+		best := 0.0
+		for i, list := range wp.lists {
+			if wp.word.boosts[i] > best && containsID(list, id) {
+				best = wp.word.boosts[i]
+			}
+		}
+		total += best
+	}
+	return total
+}
+
+// containsID reports whether the sorted list contains the id.
+func containsID(list []uint64, id uint64) bool {
+	i := sort.Search(len(list), func(i int) bool { return list[i] >= id })
+	return i < len(list) && list[i] == id
+}
+
+// containsToken reports whether the sorted tokens contain the token.
+func containsToken(tokens []string, token string) bool {
+	i := sort.SearchStrings(tokens, token)
+	return i < len(tokens) && tokens[i] == token
+}
+
+// textTerms returns tokens of the text in the term space of the field with their positions.
+func (p *SimpleProcessor) textTerms(name, text string) map[string][]int {
+	terms := make(map[string][]int)
+	for i, token := range p.tokenizer(text, p.filters...) {
+		terms[fieldTerm(name, token)] = append(terms[fieldTerm(name, token)], i)
+	}
+	return terms
+}
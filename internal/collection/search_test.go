@@ -0,0 +1,56 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func (cts *processorTestSuite) TestSimpleProcessor_SearchTitle() {
+	now := time.Now()
+	saveData := []RawData{
+		{Url: "source1", Data: "data1 golang", Source: Source{Date: now, Title: "notes"}},
+		{Url: "source2", Data: "data1", Source: Source{Date: now.Add(-time.Hour), Title: "golang"}},
+		{Url: "source3", Data: "data2", Source: Source{Date: now.Add(time.Hour), Title: "golang"}},
+		{Url: "source4", Data: "golang", Source: Source{Date: now.Add(time.Hour)}},
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+
+	urls := func(query string) []string {
+		res, err := cts.proc.ProcessAndGet(context.Background(), query, 10, 0)
+		cts.NoError(err)
+		var urls []string
+		for _, r := range res {
+			urls = append(urls, r.Url)
+		}
+		return urls
+	}
+	// Title matches rank above body matches, then documents are ordered by date.
+	cts.Equal([]string{"source3", "source2", "source4", "source1"}, urls("golang"))
+	cts.Equal([]string{"source2", "source1"}, urls("golang data1"))
+	cts.Equal([]string{"source3", "source2"}, urls("title:golang"))
+	cts.Equal([]string{"source4", "source1"}, urls("data:golang"))
+	cts.Equal([]string{"source2"}, urls("title:golang data1"))
+
+	// Boosts are set by the schema.
+	cts.NoError(cts.proc.SetSchema(context.Background(), Schema{Fields: map[string]FieldMapping{
+		"data":  {Type: TypeText, Boost: 3},
+		"title": {Type: TypeText, Boost: 1},
+	}}))
+	cts.Equal([]string{"source4", "source1", "source3", "source2"}, urls("golang"))
+	cts.True(errors.Is(cts.proc.SetSchema(context.Background(), Schema{Fields: map[string]FieldMapping{
+		"title": {Type: TypeKeyword},
+	}}), ErrInvalidSchema))
+
+	// Updated titles replace the old ones.
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+		{Url: "source3", Data: "data2", Source: Source{Date: now, Title: "rust"}},
+	}))
+	cts.Equal([]string{"source2"}, urls("title:golang"))
+
+	rejected, err := cts.proc.ProcessAndInsertBestEffort(context.Background(), []RawData{
+		{Url: "source5", Data: "data1", Source: Source{Fields: Fields{"title": "golang"}}},
+	})
+	cts.NoError(err)
+	cts.Len(rejected, 1)
+}
@@ -0,0 +1,348 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/polyse/database/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrInvalidQuery error to return if a structured query is malformed.
+	ErrInvalidQuery = errors.New("invalid query")
+)
+
+// StructuredQuery is a search request made of boolean clauses. Found documents match all Must and Filter clauses,
+// none of MustNot clauses and at least one Should clause if there are no Must and Filter clauses.
+// Documents are ordered by the sort fields, then by the sum of scores of the matched Must and Should
+// match clauses and then by date. Filter clauses and term and range clauses do not change the relevance,
+// term clauses of filters are cached like filters of SearchQuery.
+//
+// Input format:
+//    {
+//      "must":     [{"match": {"query": "golang generics"}}],
+//      "should":   [{"match": {"field": "author", "query": "pike"}}],
+//      "must_not": [{"term": {"field": "draft", "value": true}}],
+//      "filter":   [{"term": {"field": "lang", "value": "en"}}, {"range": {"field": "words", "gte": 100}}],
+//      "sort":     [{"field": "rating", "desc": true}],
+//      "aggs":     {"langs": {"terms": {"field": "lang"}}},
+//      "highlight": {"fields": ["title"]},
+//      "limit": 10,
+//      "offset": 0
+//    }
+type StructuredQuery struct {
+	Must      []Clause               `json:"must" validate:"dive"`
+	Should    []Clause               `json:"should" validate:"dive"`
+	MustNot   []Clause               `json:"must_not" validate:"dive"`
+	Filter    []Clause               `json:"filter" validate:"dive"`
+	Sort      []SortField            `json:"sort" validate:"dive"`
+	Aggs      map[string]Aggregation `json:"aggs" validate:"dive"`
+	Highlight *Highlight             `json:"highlight"`
+	Limit     int                    `json:"limit" validate:"gte=0"`
+	Offset    int                    `json:"offset" validate:"gte=0"`
+}
+
+// Clause is a condition of a structured query, exactly one of its members must be set.
+type Clause struct {
+	Match *MatchClause `json:"match"`
+	Term  *TermClause  `json:"term"`
+	Range *RangeClause `json:"range"`
+}
+
+// MatchClause matches documents containing any token of the query, or all of them if the operator is "and".
+// Tokens are searched in the data and the title unless the field is set. Values of fields which are not text
+// match exactly, like conditions of ParseQuery.
+type MatchClause struct {
+	Field    string `json:"field"`
+	Query    string `json:"query" validate:"required"`
+	Operator string `json:"operator" validate:"omitempty,oneof=and or"`
+}
+
+// TermClause matches documents with the value of the field which is not text or with the url host (_host).
+type TermClause struct {
+	Field string `json:"field" validate:"required"`
+	Value Scalar `json:"value" validate:"required"`
+}
+
+// RangeClause matches documents with a numeric or date value of the field in the range,
+// a missing bound is open.
+type RangeClause struct {
+	Field string  `json:"field" validate:"required"`
+	Gt    *Scalar `json:"gt"`
+	Gte   *Scalar `json:"gte"`
+	Lt    *Scalar `json:"lt"`
+	Lte   *Scalar `json:"lte"`
+}
+
+// Scalar is a string, number or boolean value of a structured query in the string form.
+type Scalar string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Scalar) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	str, ok := formatFieldValue(v)
+	if !ok {
+		return fmt.Errorf("%w: %s is not a scalar value", ErrInvalidQuery, b)
+	}
+	*s = Scalar(str)
+	return nil
+}
+
+// fieldRange converts the clause to the range of the field.
+func (r *RangeClause) fieldRange() (FieldRange, error) {
+	if r.Gt != nil && r.Gte != nil || r.Lt != nil && r.Lte != nil {
+		return FieldRange{}, fmt.Errorf("%w: range on %s has two bounds of one side", ErrInvalidQuery, r.Field)
+	}
+	fr := FieldRange{Name: r.Field, IncludeFrom: r.Gt == nil, IncludeTo: r.Lt == nil}
+	for _, b := range []struct {
+		bound *Scalar
+		to    *string
+	}{{r.Gt, &fr.From}, {r.Gte, &fr.From}, {r.Lt, &fr.To}, {r.Lte, &fr.To}} {
+		if b.bound != nil {
+			*b.to = string(*b.bound)
+		}
+	}
+	return fr, nil
+}
+
+// SearchHit is a found document with its score and highlighted values of fields.
+type SearchHit struct {
+	ResponseData
+	Score     float64             `json:"score"`
+	Highlight map[string][]string `json:"highlight,omitempty"`
+}
+
+// SearchResult is the page of documents found by a structured query, the total number of found documents
+// and aggregations of all of them. Expired documents are not returned but are counted until they are swept.
+type SearchResult struct {
+	Total int                          `json:"total"`
+	Hits  []SearchHit                  `json:"hits"`
+	Aggs  map[string]AggregationResult `json:"aggs,omitempty"`
+}
+
+// analyzedClause is a clause prepared for searching in the index.
+type analyzedClause struct {
+	analyzedQuery
+	// and is set if documents must contain all words.
+	and bool
+}
+
+// analyzedStructured is a structured query prepared for searching in the index.
+type analyzedStructured struct {
+	must, should, mustNot, filter []analyzedClause
+	aggs                          []analyzedAgg
+	highlight                     highlighter
+}
+
+// SearchStructured finds documents matching the structured query and returns the page of them with the total
+// number of found documents and aggregations. The search is aborted with the context error if the context is done.
+// An error wrapping ErrInvalidQuery or ErrInvalidField is returned if the query is wrong.
+func (p *SimpleProcessor) SearchStructured(ctx context.Context, q StructuredQuery) (res SearchResult, err error) {
+	if q.Limit < 1 {
+		q.Limit = 10
+	}
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	log.Debug().
+		Str("collection", p.colName).
+		Interface("query", q).
+		Msg("start structured searching")
+	gen := p.filterCache.generation()
+	err = p.store.View(func(tx storage.Tx) error {
+		idx := p.index(tx)
+		schema, err := idx.schema()
+		if err != nil {
+			return err
+		}
+		sq, err := p.analyzeStructured(&schema, q)
+		if err != nil {
+			return err
+		}
+		ids, scoring, err := p.findStructured(ctx, idx, gen, &sq)
+		if err != nil {
+			return err
+		}
+		hits, err := rankPage(ctx, idx, newExpiry(&schema, time.Now()), ids, q.Limit, q.Offset, q.Sort, func(id uint64) float64 {
+			total := 0.0
+			for _, wps := range scoring {
+				total += score(wps, id)
+			}
+			return total
+		})
+		if err != nil {
+			return err
+		}
+		docs, err := findSources(ctx, idx, hits)
+		if err != nil {
+			return err
+		}
+		res.Total = len(ids)
+		res.Hits = make([]SearchHit, len(docs))
+		for i := range docs {
+			res.Hits[i] = SearchHit{
+				ResponseData: docs[i],
+				Score:        hits[i].score,
+				Highlight:    p.highlight(&sq.highlight, &docs[i].Source),
+			}
+		}
+		res.Aggs, err = aggregate(ctx, idx, sq.aggs, ids)
+		return err
+	})
+	return res, err
+}
+
+// analyzeStructured checks the query against the schema and analyzes its clauses.
+func (p *SimpleProcessor) analyzeStructured(schema *Schema, q StructuredQuery) (sq analyzedStructured, err error) {
+	if len(q.Must)+len(q.Should)+len(q.Filter) == 0 {
+		return sq, fmt.Errorf("%w: no must, should or filter clauses", ErrInvalidQuery)
+	}
+	for _, c := range []struct {
+		clauses []Clause
+		to      *[]analyzedClause
+	}{{q.Must, &sq.must}, {q.Should, &sq.should}, {q.MustNot, &sq.mustNot}, {q.Filter, &sq.filter}} {
+		for _, clause := range c.clauses {
+			ac, err := p.analyzeClause(schema, clause)
+			if err != nil {
+				return sq, err
+			}
+			*c.to = append(*c.to, ac)
+		}
+	}
+	for _, f := range q.Sort {
+		if _, err = schema.checkNumeric(f.Name, "sort"); err != nil {
+			return sq, err
+		}
+	}
+	if sq.aggs, err = analyzeAggs(schema, q.Aggs); err != nil {
+		return sq, err
+	}
+	matched := append(append([]Clause(nil), q.Must...), q.Should...)
+	sq.highlight, err = p.analyzeHighlight(schema, q.Highlight, matched)
+	return sq, err
+}
+
+func (p *SimpleProcessor) analyzeClause(schema *Schema, c Clause) (ac analyzedClause, err error) {
+	switch {
+	case c.Match != nil && c.Term == nil && c.Range == nil:
+		q := SearchQuery{Text: c.Match.Query}
+		if c.Match.Field != "" {
+			q = SearchQuery{Fields: []FieldMatch{{Name: c.Match.Field, Value: c.Match.Query}}}
+		}
+		ac.analyzedQuery, err = p.analyzeQuery(schema, q)
+		ac.and = c.Match.Operator == "and"
+	case c.Term != nil && c.Match == nil && c.Range == nil:
+		term, err := p.filterTerm(schema, FieldMatch{Name: c.Term.Field, Value: string(c.Term.Value)})
+		if err != nil {
+			return ac, err
+		}
+		ac.filterTerms = []string{term}
+	case c.Range != nil && c.Match == nil && c.Term == nil:
+		fr, err := c.Range.fieldRange()
+		if err != nil {
+			return ac, err
+		}
+		nr, err := schema.numericRange(fr)
+		if err != nil {
+			return ac, err
+		}
+		ac.ranges = []numericRange{nr}
+	default:
+		return ac, fmt.Errorf("%w: clause must have one of match, term or range", ErrInvalidQuery)
+	}
+	return ac, err
+}
+
+// findStructured returns sorted ids of documents matching the query and postings of words of scoring clauses.
+func (p *SimpleProcessor) findStructured(
+	ctx context.Context,
+	idx *index,
+	gen uint64,
+	sq *analyzedStructured,
+) (ids []uint64, scoring [][]wordPostings, err error) {
+	var required [][]uint64
+	for _, c := range []struct {
+		clauses  []analyzedClause
+		required bool
+		scoring  bool
+	}{{sq.must, true, true}, {sq.filter, true, false}, {sq.should, false, true}} {
+		for i := range c.clauses {
+			found, wps, err := p.findClause(ctx, idx, gen, &c.clauses[i])
+			if err != nil {
+				return nil, nil, err
+			}
+			if c.scoring {
+				scoring = append(scoring, wps)
+			}
+			if c.required {
+				required = append(required, found)
+			} else if len(sq.must)+len(sq.filter) == 0 {
+				ids = mergePostings(ids, found)
+			}
+		}
+	}
+	for i, list := range required {
+		if i == 0 {
+			ids = list
+		} else {
+			ids = intersectPostings(ids, list)
+		}
+	}
+	for i := range sq.mustNot {
+		found, _, err := p.findClause(ctx, idx, gen, &sq.mustNot[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = subtractPostings(ids, found)
+	}
+	return ids, scoring, nil
+}
+
+// findClause returns sorted ids of documents matching the clause and postings of its words.
+func (p *SimpleProcessor) findClause(
+	ctx context.Context,
+	idx *index,
+	gen uint64,
+	c *analyzedClause,
+) ([]uint64, []wordPostings, error) {
+	if len(c.words)+len(c.terms)+len(c.ranges)+len(c.filterTerms) == 0 {
+		return nil, nil, nil
+	}
+	var err error
+	if c.sets, err = p.filterSets(ctx, idx, gen, c.filterTerms); err != nil {
+		return nil, nil, err
+	}
+	wps, err := findWords(ctx, idx, c.words)
+	if err != nil {
+		return nil, nil, err
+	}
+	var lists [][]uint64
+	if len(c.words) > 0 {
+		if len(wps) == 0 || c.and && len(wps) < len(c.words) {
+			return nil, nil, nil
+		}
+		var list []uint64
+		for i, wp := range wps {
+			switch {
+			case i == 0:
+				list = wp.union
+			case c.and:
+				list = intersectPostings(list, wp.union)
+			default:
+				list = mergePostings(list, wp.union)
+			}
+		}
+		lists = [][]uint64{list}
+	}
+	if lists, err = c.filter(ctx, idx, lists); err != nil || len(lists) == 0 {
+		return nil, nil, err
+	}
+	return lists[0], wps, nil
+}
@@ -0,0 +1,176 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStructuredQuery_Unmarshal(t *testing.T) {
+	var q StructuredQuery
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"must": [{"match": {"query": "golang", "operator": "and"}}],
+		"filter": [{"term": {"field": "draft", "value": false}}, {"range": {"field": "words", "gt": 100, "lte": "200"}}],
+		"sort": [{"field": "rating", "desc": true}]
+	}`), &q))
+	assert.Equal(t, StructuredQuery{
+		Must: []Clause{{Match: &MatchClause{Query: "golang", Operator: "and"}}},
+		Filter: []Clause{
+			{Term: &TermClause{Field: "draft", Value: "false"}},
+			{Range: &RangeClause{Field: "words", Gt: scalar("100"), Lte: scalar("200")}},
+		},
+		Sort: []SortField{{Name: "rating", Desc: true}},
+	}, q)
+	fr, err := q.Filter[1].Range.fieldRange()
+	assert.NoError(t, err)
+	assert.Equal(t, FieldRange{Name: "words", From: "100", To: "200", IncludeTo: true}, fr)
+
+	assert.True(t, errors.Is(json.Unmarshal([]byte(`{"term": {"field": "a", "value": [1]}}`), &Clause{}), ErrInvalidQuery))
+	_, err = (&RangeClause{Field: "words", Gt: scalar("1"), Gte: scalar("2")}).fieldRange()
+	assert.True(t, errors.Is(err, ErrInvalidQuery))
+}
+
+func scalar(s string) *Scalar {
+	v := Scalar(s)
+	return &v
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_SearchStructured() {
+	cts.NoError(cts.proc.SetSchema(context.Background(), Schema{Fields: map[string]FieldMapping{
+		"author": {Type: TypeText},
+		"lang":   {Type: TypeKeyword},
+		"words":  {Type: TypeInteger},
+	}}))
+	now := time.Now()
+	saveData := []RawData{
+		{Url: "source1", Data: "golang generics", Source: Source{
+			Date: now, Title: "Generics in Go", Fields: Fields{"author": "Rob Pike", "lang": "en", "words": 100},
+		}},
+		{Url: "source2", Data: "golang channels", Source: Source{
+			Date: now.Add(time.Hour), Title: "Channels", Fields: Fields{"author": "John Doe", "lang": "de", "words": 300},
+		}},
+		{Url: "source3", Data: "rust generics", Source: Source{
+			Date: now.Add(2 * time.Hour), Title: "Rust traits", Fields: Fields{"lang": "en", "words": 200},
+		}},
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+
+	search := func(q StructuredQuery) (urls []string, res SearchResult) {
+		res, err := cts.proc.SearchStructured(context.Background(), q)
+		cts.NoError(err)
+		for _, h := range res.Hits {
+			urls = append(urls, h.Url)
+		}
+		return urls, res
+	}
+	match := func(field, query, operator string) Clause {
+		return Clause{Match: &MatchClause{Field: field, Query: query, Operator: operator}}
+	}
+	term := func(field, value string) Clause {
+		return Clause{Term: &TermClause{Field: field, Value: Scalar(value)}}
+	}
+
+	urls, res := search(StructuredQuery{Must: []Clause{match("", "golang generics", "")}})
+	cts.Equal([]string{"source1", "source3", "source2"}, urls)
+	cts.Equal(3, res.Total)
+	cts.Equal(defaultBoost+defaultTitleBoost, res.Hits[0].Score)
+
+	urls, _ = search(StructuredQuery{Must: []Clause{match("", "golang generics", "and")}})
+	cts.Equal([]string{"source1"}, urls)
+
+	// Should clauses only change the relevance if there are must clauses.
+	urls, _ = search(StructuredQuery{
+		Must:   []Clause{match("", "golang", "")},
+		Should: []Clause{match("author", "doe", "")},
+	})
+	cts.Equal([]string{"source2", "source1"}, urls)
+	urls, _ = search(StructuredQuery{Should: []Clause{match("", "channels", ""), match("", "traits", "")}})
+	cts.Equal([]string{"source3", "source2"}, urls)
+
+	// Filters and must not clauses do not change the relevance.
+	urls, res = search(StructuredQuery{
+		Must:    []Clause{match("", "generics", "")},
+		MustNot: []Clause{match("", "rust", "")},
+		Filter:  []Clause{term("lang", "en"), {Range: &RangeClause{Field: "words", Lte: scalar("150")}}},
+	})
+	cts.Equal([]string{"source1"}, urls)
+	cts.Equal(defaultTitleBoost, res.Hits[0].Score)
+	urls, _ = search(StructuredQuery{
+		Filter: []Clause{{Range: &RangeClause{Field: "words", Gt: scalar("100")}}},
+		Sort:   []SortField{{Name: "words"}},
+		Limit:  1,
+		Offset: 1,
+	})
+	cts.Equal([]string{"source2"}, urls)
+
+	// Aggregations summarize all found documents.
+	_, res = search(StructuredQuery{
+		Filter: []Clause{{Range: &RangeClause{Field: "words"}}},
+		Aggs: map[string]Aggregation{
+			"langs": {Terms: &TermsAggregation{Field: "lang"}},
+			"top":   {Terms: &TermsAggregation{Field: "lang", Size: 1}},
+			"words": {Stats: &StatsAggregation{Field: "words"}},
+		},
+		Limit: 1,
+	})
+	cts.Len(res.Hits, 1)
+	cts.Equal(map[string]AggregationResult{
+		"langs": {Buckets: []AggregationBucket{{Value: "en", Count: 2}, {Value: "de", Count: 1}}},
+		"top":   {Buckets: []AggregationBucket{{Value: "en", Count: 2}}},
+		"words": {Stats: &NumericStats{Count: 3, Min: 100, Max: 300, Sum: 600, Avg: 200}},
+	}, res.Aggs)
+
+	// Words of match clauses are highlighted in stored text fields.
+	_, res = search(StructuredQuery{
+		Must:      []Clause{match("", "generic", "")},
+		Should:    []Clause{match("author", "pike", "")},
+		Highlight: &Highlight{},
+	})
+	cts.Equal(map[string][]string{
+		"title":  {"<em>Generics</em> in Go"},
+		"author": {"Rob <em>Pike</em>"},
+	}, res.Hits[0].Highlight)
+	cts.Nil(res.Hits[1].Highlight)
+	_, res = search(StructuredQuery{
+		Must:      []Clause{match("", "generic", ""), match("author", "pike", "")},
+		Highlight: &Highlight{Fields: []string{"author"}, PreTag: "[", PostTag: "]"},
+	})
+	cts.Equal(map[string][]string{"author": {"Rob [Pike]"}}, res.Hits[0].Highlight)
+
+	for _, q := range []StructuredQuery{
+		{},
+		{MustNot: []Clause{term("lang", "en")}},
+		{Must: []Clause{{}}},
+		{Must: []Clause{{Match: &MatchClause{Query: "go"}, Term: &TermClause{Field: "lang", Value: "en"}}}},
+		{Must: []Clause{match("", "go", "")}, Aggs: map[string]Aggregation{"a": {}}},
+		{Must: []Clause{match("", "go", "")}, Highlight: &Highlight{Fields: []string{"data"}}},
+	} {
+		_, err := cts.proc.SearchStructured(context.Background(), q)
+		cts.True(errors.Is(err, ErrInvalidQuery), "%+v", q)
+	}
+	for _, q := range []StructuredQuery{
+		{Filter: []Clause{term("author", "pike")}},
+		{Filter: []Clause{{Range: &RangeClause{Field: "lang", Gt: scalar("a")}}}},
+		{Filter: []Clause{term("lang", "en")}, Sort: []SortField{{Name: "lang"}}},
+		{Filter: []Clause{term("lang", "en")}, Aggs: map[string]Aggregation{"a": {Terms: &TermsAggregation{Field: "author"}}}},
+		{Filter: []Clause{term("lang", "en")}, Aggs: map[string]Aggregation{"a": {Stats: &StatsAggregation{Field: "lang"}}}},
+	} {
+		_, err := cts.proc.SearchStructured(context.Background(), q)
+		cts.True(errors.Is(err, ErrInvalidField), "%+v", q)
+	}
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_HighlightText() {
+	proc := cts.proc.(*SimpleProcessor)
+	tokens := map[string]struct{}{"generic": {}, "rust": {}}
+	text, ok := proc.highlightText("Café — Generics, in Rust's world: rust!", tokens, "[", "]")
+	cts.True(ok)
+	cts.Equal("Café — [Generics], in [Rust's] world: [rust]!", text)
+	text, ok = proc.highlightText("no matches - here", tokens, "[", "]")
+	cts.False(ok)
+	cts.Equal("no matches - here", text)
+}
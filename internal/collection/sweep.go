@@ -0,0 +1,117 @@
+package collection
+
+import (
+	"context"
+	"time"
+
+	"github.com/polyse/database/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// Sweep deletes documents expired at the given time and documents older than the retention period of
+// the collection, see Schema, and returns the number of deleted documents. Documents are deleted from postings and sources
+// in batches of the configured chunk size, each batch in its own transaction. If the context is done or
+// deleting fails, the number of documents deleted by the previous batches is returned with the error.
+func (p *SimpleProcessor) Sweep(ctx context.Context, now time.Time) (int, error) {
+	deleted := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		n, more := 0, false
+		if err := p.update(func(tx storage.Tx) (err error) {
+			n, more, err = p.sweepBatch(ctx, p.index(tx), now)
+			return err
+		}); err != nil {
+			return deleted, err
+		}
+		deleted += n
+		if !more || n == 0 {
+			break
+		}
+	}
+	if deleted > 0 {
+		log.Debug().
+			Str("collection", p.colName).
+			Int("deleted", deleted).
+			Msg("expired documents deleted")
+	}
+	return deleted, nil
+}
+
+// sweepBatch deletes at most one chunk of expired documents of each kind and reports whether more documents
+// may be left.
+func (p *SimpleProcessor) sweepBatch(ctx context.Context, idx *index, now time.Time) (int, bool, error) {
+	schema, err := idx.schema()
+	if err != nil {
+		return 0, false, err
+	}
+	ids, err := idx.expired(now, p.chunkSize)
+	if err != nil {
+		return 0, false, err
+	}
+	more := len(ids) == p.chunkSize
+	if retention := schema.retention(); retention > 0 {
+		old, err := idx.olderThan(now.Add(-retention), p.chunkSize)
+		if err != nil {
+			return 0, false, err
+		}
+		more = more || len(old) == p.chunkSize
+		ids = append(ids, old...)
+	}
+
+	w := newBatchWriter(idx)
+	seen := make(map[uint64]struct{}, len(ids))
+	for _, id := range ids {
+		if err = ctx.Err(); err != nil {
+			return 0, false, err
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		url, err := idx.url(id)
+		if err != nil {
+			return 0, false, err
+		}
+		if err = w.remove(id, url); err != nil {
+			return 0, false, err
+		}
+	}
+	return len(seen), more, w.flush()
+}
+
+// expiry tells whether documents are expired at the given time: documents with the passed ttl
+// and documents older than the retention period of the collection. Expired documents are hidden from reads
+// until the sweeper deletes them. Reads check only the documents they return, so the numbers of found
+// documents and aggregations count expired documents until they are deleted.
+type expiry struct {
+	now       time.Time
+	retention time.Duration
+}
+
+func newExpiry(schema *Schema, now time.Time) expiry {
+	return expiry{now: now, retention: schema.retention()}
+}
+
+// expired reports whether the document with the date and the expiration time is expired.
+func (e expiry) expired(date time.Time, expires *time.Time) bool {
+	if expires != nil && !e.now.Before(*expires) {
+		return true
+	}
+	return e.retention > 0 && date.Before(e.now.Add(-e.retention))
+}
+
+// live reports whether the document is not expired without loading its source.
+func (e expiry) live(idx *index, id uint64) (bool, error) {
+	date, expires, err := idx.times(id)
+	if err != nil {
+		return false, err
+	}
+	return !e.expired(date, expires), nil
+}
+
+// expiredSource reports whether the document with the source is expired at the given time, see expiry.
+func expiredSource(schema *Schema, s *Source, now time.Time) bool {
+	return newExpiry(schema, now).expired(s.Date, s.Expires)
+}
@@ -0,0 +1,139 @@
+package collection
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/polyse/database/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func (cts *processorTestSuite) TestSimpleProcessor_SweepExpired() {
+	saveData := []RawData{
+		{Url: "source1", Data: "data1", TTL: 60},
+		{Url: "source2", Data: "data1", TTL: 3600},
+		{Url: "source3", Data: "data1"},
+		{Url: "source4", Data: "data1", TTL: 60},
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+	res, err := cts.proc.Get(context.Background(), "source1")
+	cts.NoError(err)
+	cts.NotNil(res.Expires)
+
+	// Expiration times are kept with dates, so searches skip expired documents without loading sources.
+	cts.NoError(cts.store.View(func(tx storage.Tx) error {
+		idx := cts.proc.(*SimpleProcessor).index(tx)
+		id, _, err := idx.docID("source1")
+		if err != nil {
+			return err
+		}
+		_, expires, err := idx.times(id)
+		cts.Require().NotNil(expires)
+		cts.True(expires.Equal(*res.Expires))
+		return err
+	}))
+
+	// Saving the document without ttl makes it permanent.
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{{Url: "source4", Data: "data1"}}))
+	cts.Nil(cts.source("source4").Expires)
+
+	deleted, err := cts.proc.Sweep(context.Background(), time.Now())
+	cts.NoError(err)
+	cts.Equal(0, deleted)
+
+	deleted, err = cts.proc.Sweep(context.Background(), time.Now().Add(2*time.Minute))
+	cts.NoError(err)
+	cts.Equal(1, deleted)
+	_, err = cts.proc.Get(context.Background(), "source1")
+	cts.Equal(ErrDocumentNotFound, err)
+	cts.Equal(map[string][]int{"source2": {0}, "source3": {0}, "source4": {0}}, cts.postings("data1"))
+
+	deleted, err = cts.proc.Sweep(context.Background(), time.Now().Add(24*time.Hour))
+	cts.NoError(err)
+	cts.Equal(1, deleted)
+	cts.Equal(map[string][]int{"source3": {0}, "source4": {0}}, cts.postings("data1"))
+	cts.Equal(uint64(2), cts.documents(cts.proc.(*SimpleProcessor)))
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_SweepRetention() {
+	now := time.Now()
+	cts.NoError(cts.proc.SetSchema(context.Background(), Schema{Retention: "24h"}))
+	saveData := []RawData{
+		{Url: "source1", Data: "data1", Source: Source{Date: now.Add(-72 * time.Hour)}},
+		{Url: "source2", Data: "data1", Source: Source{Date: now.Add(-48 * time.Hour)}},
+		{Url: "source3", Data: "data1", Source: Source{Date: now.Add(-25 * time.Hour)}},
+		{Url: "source4", Data: "data1", Source: Source{Date: now.Add(-time.Hour)}},
+		{Url: "source5", Data: "data2", Source: Source{Date: now.Add(-48 * time.Hour)}},
+	}
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), saveData))
+
+	// The date of the document is moved into the retention period.
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+		{Url: "source5", Data: "data2", Source: Source{Date: now}},
+	}))
+
+	// Documents older than the retention period are hidden from reads before they are swept.
+	res, err := cts.proc.ProcessAndGet(context.Background(), "data1", 10, 0)
+	cts.NoError(err)
+	cts.Require().Len(res, 1)
+	cts.Equal("source4", res[0].Url)
+	found, err := cts.proc.SearchStructured(context.Background(), StructuredQuery{
+		Must: []Clause{{Match: &MatchClause{Query: "data1"}}},
+	})
+	cts.NoError(err)
+	cts.Require().Len(found.Hits, 1)
+	cts.Equal("source4", found.Hits[0].Url)
+	// Only returned documents are checked, so totals count expired documents until they are swept.
+	cts.Equal(4, found.Total)
+	_, err = cts.proc.Get(context.Background(), "source1")
+	cts.Equal(ErrDocumentNotFound, err)
+	docs, err := cts.proc.MultiGet(context.Background(), []string{"source2", "source4"})
+	cts.NoError(err)
+	cts.False(docs[0].Found)
+	cts.True(docs[1].Found)
+	page, err := cts.proc.List(context.Background(), "", 10)
+	cts.NoError(err)
+	cts.Len(page.Docs, 2)
+	scroll, err := cts.proc.Scroll(context.Background(), "", 10, time.Minute)
+	cts.NoError(err)
+	cts.Len(scroll.Docs, 2)
+	cts.Equal(5, scroll.Total)
+
+	deleted, err := cts.proc.Sweep(context.Background(), now)
+	cts.NoError(err)
+	cts.Equal(3, deleted)
+	cts.Equal(map[string][]int{"source4": {0}}, cts.postings("data1"))
+	cts.Equal(map[string][]int{"source5": {0}}, cts.postings("data2"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = cts.proc.Sweep(ctx, now.Add(24*time.Hour))
+	cts.Equal(context.Canceled, err)
+}
+
+func (cts *processorTestSuite) TestSimpleProcessor_SearchSkipsExpired() {
+	now := time.Now()
+	cts.NoError(cts.proc.SetSchema(context.Background(), Schema{Retention: "24h"}))
+	cts.NoError(cts.proc.ProcessAndInsertString(context.Background(), []RawData{
+		{Url: "source1", Data: "data1 data2", Source: Source{Date: now.Add(-48 * time.Hour)}},
+		{Url: "source2", Data: "data1", Source: Source{Date: now}},
+	}))
+
+	// An expired document matching more words does not hide documents matching fewer of them.
+	res, err := cts.proc.ProcessAndGet(context.Background(), "data1 data2", 10, 0)
+	cts.NoError(err)
+	cts.Require().Len(res, 1)
+	cts.Equal("source2", res[0].Url)
+}
+
+func TestExpiredSource(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Second), now.Add(time.Second)
+	schema := Schema{Retention: "1h"}
+	assert.True(t, expiredSource(&schema, &Source{Date: now, Expires: &past}, now))
+	assert.True(t, expiredSource(&schema, &Source{Date: now, Expires: &now}, now))
+	assert.False(t, expiredSource(&schema, &Source{Date: now, Expires: &future}, now))
+	assert.True(t, expiredSource(&schema, &Source{Date: now.Add(-2 * time.Hour)}, now))
+	assert.False(t, expiredSource(&Schema{}, &Source{Date: now.Add(-2 * time.Hour)}, now))
+}
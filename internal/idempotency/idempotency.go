@@ -0,0 +1,106 @@
+// Package idempotency records responses of requests by client-supplied keys to replay them on retries.
+package idempotency
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/polyse/database/internal/storage"
+)
+
+var (
+	// ErrInProgress error to return if a request with the same key is being processed.
+	ErrInProgress = errors.New("request with the same idempotency key is in progress")
+	// ErrMismatch error to return if the key was used by a different request.
+	ErrMismatch = errors.New("idempotency key is already used by a different request")
+)
+
+// bucket keeps recorded responses by key.
+const bucket = "idempotency"
+
+// Response is the recorded response of a request.
+type Response struct {
+	// Fingerprint identifies the request, a key can not be reused by a request with another fingerprint.
+	Fingerprint []byte
+	Status      int
+	Body        []byte
+}
+
+// Store keeps recorded responses in the storage for the configured window.
+// Requests in progress are tracked in memory, so only one process may serve requests with the same keys.
+type Store struct {
+	store    storage.Storage
+	ttl      uint32
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+}
+
+// NewStore function-constructor of Store, responses are kept for the window rounded up to seconds.
+func NewStore(store storage.Storage, window time.Duration) *Store {
+	ttl := uint32((window + time.Second - 1) / time.Second)
+	if ttl == 0 {
+		ttl = 1
+	}
+	return &Store{
+		store:    store,
+		ttl:      ttl,
+		inFlight: make(map[string]struct{}),
+	}
+}
+
+// Begin starts the request with the key. It returns the recorded response if the request is already done,
+// ErrInProgress if it is being processed now and ErrMismatch if the key is used by a request with another fingerprint.
+// If neither the response nor an error is returned, the caller processes the request and must call End.
+func (s *Store) Begin(key string, fingerprint []byte) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.inFlight[key]; ok {
+		return nil, ErrInProgress
+	}
+	var r *Response
+	if err := s.store.View(func(tx storage.Tx) error {
+		v, err := tx.Get(bucket, []byte(key))
+		if err != nil {
+			if err == storage.ErrNotFound {
+				return nil
+			}
+			return err
+		}
+		r = &Response{}
+		return gob.NewDecoder(bytes.NewReader(v)).Decode(r)
+	}); err != nil {
+		return nil, err
+	}
+	if r != nil {
+		if !bytes.Equal(r.Fingerprint, fingerprint) {
+			return nil, ErrMismatch
+		}
+		return r, nil
+	}
+	s.inFlight[key] = struct{}{}
+	return nil, nil
+}
+
+// End finishes the request with the key. The response is recorded unless it is nil,
+// in that case the request may be retried with the same key.
+func (s *Store) End(key string, r *Response) error {
+	defer func() {
+		s.mu.Lock()
+		delete(s.inFlight, key)
+		s.mu.Unlock()
+	}()
+	if r == nil {
+		return nil
+	}
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(r); err != nil {
+		return err
+	}
+	return s.store.Update(func(tx storage.Tx) error {
+		return tx.Put(bucket, []byte(key), b.Bytes(), s.ttl)
+	})
+}
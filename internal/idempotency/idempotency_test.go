@@ -0,0 +1,44 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/polyse/database/internal/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	s := NewStore(storage.NewMemory(), time.Hour)
+	fp := []byte("fingerprint")
+
+	r, err := s.Begin("key", fp)
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+
+	_, err = s.Begin("key", fp)
+	assert.Equal(t, ErrInProgress, err)
+
+	want := &Response{Fingerprint: fp, Status: 201, Body: []byte(`{"errors":false}`)}
+	assert.NoError(t, s.End("key", want))
+
+	r, err = s.Begin("key", fp)
+	assert.NoError(t, err)
+	assert.Equal(t, want, r)
+
+	_, err = s.Begin("key", []byte("other"))
+	assert.Equal(t, ErrMismatch, err)
+}
+
+func TestStore_NotRecorded(t *testing.T) {
+	s := NewStore(storage.NewMemory(), time.Hour)
+
+	r, err := s.Begin("key", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+	assert.NoError(t, s.End("key", nil))
+
+	r, err = s.Begin("key", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+}
@@ -0,0 +1,429 @@
+// Package jobs provides a durable queue of ingestion jobs, which are indexed in the background.
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/polyse/database/internal/collection"
+	"github.com/polyse/database/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrJobNotFound error to return if the job does not exist.
+	ErrJobNotFound = errors.New("job does not exist")
+)
+
+const (
+	// jobsBucket keeps jobs by id.
+	jobsBucket = "jobs"
+	// docsBucket keeps chunks of documents of unfinished jobs by job id and chunk index, see chunkKey.
+	docsBucket = "job-docs"
+	// queueBucket keeps ids of unfinished jobs, in the order of submitting.
+	queueBucket = "job-queue"
+	// metaBucket keeps the job id sequence.
+	metaBucket = "job-meta"
+
+	// maxChunkBytes limits the size of an encoded chunk of documents, storage entries can not exceed
+	// the nutsdb segment size.
+	maxChunkBytes = 4 << 20
+	// maxErrors limits the number of errors listed in the job, all of them are counted anyway.
+	maxErrors = 100
+	// retryDelay is the delay before looking for jobs again after a storage error.
+	retryDelay = time.Second
+)
+
+var seqKey = []byte("seq")
+
+// Status describes the state of a job.
+type Status string
+
+// Job states.
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// Job describes a batch of documents indexed in the background and its progress.
+type Job struct {
+	ID         uint64    `json:"id"`
+	Collection string    `json:"collection"`
+	BestEffort bool      `json:"best_effort"`
+	Status     Status    `json:"status"`
+	Total      int       `json:"total"`
+	Processed  int       `json:"processed"`
+	Indexed    int       `json:"indexed"`
+	Failed     int       `json:"failed"`
+	Errors     []Error   `json:"errors"`
+	Error      string    `json:"error,omitempty"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+	// Chunks is the number of saved chunks of documents of the job.
+	Chunks int `json:"-"`
+}
+
+// Error describes a document rejected by the job.
+type Error struct {
+	Index int    `json:"index"`
+	Url   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// reject counts the rejected document and lists the error if the limit is not reached yet.
+func (j *Job) reject(i int, url string, err error) {
+	j.Failed++
+	if len(j.Errors) < maxErrors {
+		j.Errors = append(j.Errors, Error{Index: i, Url: url, Error: err.Error()})
+	}
+}
+
+// Processors gives access to processors of collections.
+type Processors interface {
+	GetProcessor(colName string) (collection.Processor, error)
+}
+
+// Queue keeps jobs in the storage and runs them one by one.
+type Queue struct {
+	store     storage.Storage
+	procs     Processors
+	chunkSize int
+	wake      chan struct{}
+}
+
+// NewQueue function-constructor of Queue. Documents of jobs are saved in chunks of up to chunkSize documents,
+// best effort jobs save their progress after every chunk.
+func NewQueue(store storage.Storage, procs Processors, chunkSize int) *Queue {
+	if chunkSize <= 0 {
+		chunkSize = 1000
+	}
+	return &Queue{
+		store:     store,
+		procs:     procs,
+		chunkSize: chunkSize,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Submit saves the documents as a new job of the collection and returns the job.
+// Documents are saved in chunks, a chunk is split further if its encoded size exceeds the storage limit.
+func (q *Queue) Submit(colName string, bestEffort bool, docs []collection.RawData) (Job, error) {
+	chunks, err := encodeChunks(docs, q.chunkSize, maxChunkBytes)
+	if err != nil {
+		return Job{}, err
+	}
+	now := time.Now()
+	job := Job{
+		Collection: colName,
+		BestEffort: bestEffort,
+		Status:     StatusQueued,
+		Total:      len(docs),
+		Errors:     []Error{},
+		Created:    now,
+		Updated:    now,
+		Chunks:     len(chunks),
+	}
+	if err := q.store.Update(func(tx storage.Tx) error {
+		id, err := nextID(tx)
+		if err != nil {
+			return err
+		}
+		job.ID = id
+		for i, chunk := range chunks {
+			if err = tx.Put(docsBucket, chunkKey(id, i), chunk, 0); err != nil {
+				return err
+			}
+		}
+		if err = tx.Put(queueBucket, encodeID(id), encodeID(id), 0); err != nil {
+			return err
+		}
+		return putJob(tx, job)
+	}); err != nil {
+		return Job{}, err
+	}
+	log.Debug().Uint64("job", job.ID).Int("documents", len(docs)).Int("chunks", len(chunks)).Msg("job submitted")
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Get returns the job by id, or ErrJobNotFound.
+func (q *Queue) Get(id uint64) (job Job, err error) {
+	err = q.store.View(func(tx storage.Tx) error {
+		job, err = getJob(tx, id)
+		return err
+	})
+	return job, err
+}
+
+// Run runs queued jobs until the context is done. Unfinished jobs, including jobs interrupted
+// by a previous shutdown, are resumed from the last saved progress. A job stopped by a storage error
+// is retried after a delay.
+func (q *Queue) Run(ctx context.Context) {
+	log.Debug().Msg("starting job queue")
+	for {
+		id, ok, err := q.next()
+		if err != nil {
+			log.Err(err).Msg("can not get next job")
+		}
+		if ok {
+			if err = q.process(ctx, id); err != nil {
+				log.Err(err).Uint64("job", id).Msg("job stopped")
+			}
+		}
+		if ok && err == nil {
+			continue
+		}
+		var retry <-chan time.Time
+		if err != nil {
+			retry = time.After(retryDelay)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-retry:
+		}
+	}
+}
+
+// next returns the id of the oldest unfinished job.
+func (q *Queue) next() (id uint64, ok bool, err error) {
+	err = q.store.View(func(tx storage.Tx) error {
+		es, err := tx.PrefixScan(queueBucket, nil, 1)
+		if err != nil || len(es) == 0 {
+			return err
+		}
+		id, err = decodeID(es[0].Key)
+		ok = err == nil
+		return err
+	})
+	return id, ok, err
+}
+
+// process indexes documents of the job. Best effort jobs are indexed chunk by chunk, only one chunk is loaded
+// at a time and the progress is saved after every chunk, so a chunk may be indexed again if the job is interrupted.
+// Atomic jobs are indexed in a single transaction, so all their chunks are loaded at once.
+// The job stays unfinished if the context is done, otherwise it is finished even if indexing fails.
+func (q *Queue) process(ctx context.Context, id uint64) error {
+	job, err := q.Get(id)
+	if err != nil {
+		return err
+	}
+	log.Debug().Uint64("job", id).Int("processed", job.Processed).Msg("running job")
+
+	proc, err := q.procs.GetProcessor(job.Collection)
+	if err != nil {
+		return q.finish(job, err)
+	}
+	job.Status = StatusRunning
+	if err = q.save(job); err != nil {
+		return err
+	}
+
+	if !job.BestEffort {
+		var docs []collection.RawData
+		for i := 0; i < job.Chunks; i++ {
+			chunk, err := q.loadChunk(id, i)
+			if err != nil {
+				return err
+			}
+			docs = append(docs, chunk...)
+		}
+		err = proc.ProcessAndInsertString(ctx, docs)
+		if batchErr, ok := err.(*collection.BatchError); ok {
+			for _, r := range batchErr.Rejected {
+				job.reject(r.Index, r.Url, r.Err)
+			}
+		} else if err != nil && ctx.Err() != nil {
+			return err
+		}
+		if err == nil {
+			job.Indexed = len(docs)
+		}
+		job.Processed = len(docs)
+		return q.finish(job, err)
+	}
+
+	// offset is the index of the first document of the chunk in the job.
+	offset := 0
+	for i := 0; i < job.Chunks; i++ {
+		docs, err := q.loadChunk(id, i)
+		if err != nil {
+			return err
+		}
+		if offset+len(docs) <= job.Processed {
+			offset += len(docs)
+			continue
+		}
+		rejected, err := proc.ProcessAndInsertBestEffort(ctx, docs[job.Processed-offset:])
+		processed := offset + len(docs) - job.Processed
+		if chunkErr, ok := err.(*collection.ChunkError); ok {
+			processed, err = chunkErr.Processed, chunkErr.Err
+		}
+		for _, r := range rejected {
+			job.reject(job.Processed+r.Index, r.Url, r.Err)
+		}
+		job.Indexed += processed - len(rejected)
+		job.Processed += processed
+		if err != nil {
+			if ctx.Err() != nil {
+				if saveErr := q.save(job); saveErr != nil {
+					return saveErr
+				}
+				return err
+			}
+			return q.finish(job, err)
+		}
+		if err = q.save(job); err != nil {
+			return err
+		}
+		offset += len(docs)
+	}
+	return q.finish(job, nil)
+}
+
+// loadChunk returns documents of the chunk of the job.
+func (q *Queue) loadChunk(id uint64, i int) (docs []collection.RawData, err error) {
+	err = q.store.View(func(tx storage.Tx) error {
+		v, err := tx.Get(docsBucket, chunkKey(id, i))
+		if err != nil {
+			return fmt.Errorf("can not get documents of job %d, error %s", id, err)
+		}
+		return gob.NewDecoder(bytes.NewReader(v)).Decode(&docs)
+	})
+	return docs, err
+}
+
+// save saves the progress of the job.
+func (q *Queue) save(job Job) error {
+	job.Updated = time.Now()
+	return q.store.Update(func(tx storage.Tx) error {
+		return putJob(tx, job)
+	})
+}
+
+// finish removes the job from the queue with its documents, the job is failed if err is not nil.
+func (q *Queue) finish(job Job, err error) error {
+	job.Status = StatusDone
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	}
+	job.Updated = time.Now()
+	log.Debug().Uint64("job", job.ID).Str("status", string(job.Status)).Msg("job finished")
+	return q.store.Update(func(tx storage.Tx) error {
+		for i := 0; i < job.Chunks; i++ {
+			if err := tx.Delete(docsBucket, chunkKey(job.ID, i)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(queueBucket, encodeID(job.ID)); err != nil {
+			return err
+		}
+		return putJob(tx, job)
+	})
+}
+
+func getJob(tx storage.Tx, id uint64) (job Job, err error) {
+	v, err := tx.Get(jobsBucket, encodeID(id))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return job, ErrJobNotFound
+		}
+		return job, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(v)).Decode(&job)
+	if job.Errors == nil {
+		// gob does not keep empty slices.
+		job.Errors = []Error{}
+	}
+	return job, err
+}
+
+func putJob(tx storage.Tx, job Job) error {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(job); err != nil {
+		return err
+	}
+	return tx.Put(jobsBucket, encodeID(job.ID), b.Bytes(), 0)
+}
+
+// nextID returns the next job id, ids start from 1.
+func nextID(tx storage.Tx) (uint64, error) {
+	var id uint64
+	v, err := tx.Get(metaBucket, seqKey)
+	switch {
+	case err == storage.ErrNotFound:
+	case err != nil:
+		return 0, err
+	default:
+		if id, err = decodeID(v); err != nil {
+			return 0, err
+		}
+	}
+	id++
+	return id, tx.Put(metaBucket, seqKey, encodeID(id), 0)
+}
+
+// encodeChunks splits the documents into chunks of up to size documents and encodes them.
+// A chunk larger than maxBytes is split in halves until it fits or has a single document.
+func encodeChunks(docs []collection.RawData, size, maxBytes int) ([][]byte, error) {
+	var chunks [][]byte
+	var encode func(docs []collection.RawData) error
+	encode = func(docs []collection.RawData) error {
+		var b bytes.Buffer
+		if err := gob.NewEncoder(&b).Encode(docs); err != nil {
+			return err
+		}
+		if b.Len() <= maxBytes || len(docs) == 1 {
+			chunks = append(chunks, b.Bytes())
+			return nil
+		}
+		if err := encode(docs[:len(docs)/2]); err != nil {
+			return err
+		}
+		return encode(docs[len(docs)/2:])
+	}
+	for offset := 0; offset < len(docs); offset += size {
+		end := offset + size
+		if end > len(docs) {
+			end = len(docs)
+		}
+		if err := encode(docs[offset:end]); err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// chunkKey returns the key of the chunk of documents of the job.
+func chunkKey(id uint64, i int) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, id)
+	binary.BigEndian.PutUint64(b[8:], uint64(i))
+	return b
+}
+
+func encodeID(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
+
+func decodeID(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("wrong job id length %d", len(b))
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
@@ -0,0 +1,233 @@
+package jobs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/polyse/database/internal/collection"
+	"github.com/polyse/database/internal/storage"
+	"github.com/polyse/database/pkg/filters"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/xujiajun/nutsdb"
+)
+
+type queueTestSuite struct {
+	suite.Suite
+	memory bool
+	store  storage.Storage
+	close  func()
+	proc   collection.Processor
+	q      *Queue
+}
+
+func TestMemoryQueueSuit(t *testing.T) {
+	suite.Run(t, &queueTestSuite{memory: true})
+}
+
+func TestNutsQueueSuit(t *testing.T) {
+	suite.Run(t, new(queueTestSuite))
+}
+
+func (qts *queueTestSuite) SetupTest() {
+	qts.close = func() {}
+	if qts.memory {
+		qts.store = storage.NewMemory()
+	} else {
+		dir, err := ioutil.TempDir("", "nutsdb-jobs-test")
+		if err != nil {
+			panic(err)
+		}
+		opt := nutsdb.DefaultOptions
+		opt.Dir = dir
+		db, err := nutsdb.Open(opt)
+		if err != nil {
+			panic(err)
+		}
+		qts.close = func() {
+			if err := db.Close(); err != nil {
+				panic(err)
+			}
+			if err := os.RemoveAll(dir); err != nil {
+				panic(err)
+			}
+		}
+		qts.store = storage.NewNuts(db)
+	}
+	qts.proc = collection.NewSimpleProcessor(
+		qts.store,
+		collection.ProcessorConfig{},
+		"test",
+		filters.FilterText,
+		filters.StemmAndToLower,
+	)
+	qts.q = NewQueue(qts.store, collection.NewManagerWithProc(qts.proc), 2)
+}
+
+func (qts *queueTestSuite) TearDownTest() {
+	qts.close()
+}
+
+// run runs the queue until all jobs are finished.
+func (qts *queueTestSuite) run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		qts.q.Run(ctx)
+		close(done)
+	}()
+	qts.Eventually(func() bool {
+		_, ok, err := qts.q.next()
+		qts.NoError(err)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func (qts *queueTestSuite) TestQueue_BestEffort() {
+	docs := []collection.RawData{
+		{Url: "source1", Data: "data1"},
+		{Url: "", Data: "data1"},
+		{Url: "source3", Data: "data1"},
+	}
+	job, err := qts.q.Submit("test", true, docs)
+	qts.NoError(err)
+	qts.Equal(uint64(1), job.ID)
+	qts.Equal(StatusQueued, job.Status)
+
+	qts.run()
+
+	job, err = qts.q.Get(job.ID)
+	qts.NoError(err)
+	qts.Equal(StatusDone, job.Status)
+	qts.Equal(3, job.Processed)
+	qts.Equal(2, job.Indexed)
+	qts.Equal(1, job.Failed)
+	qts.Equal([]Error{{Index: 1, Url: "", Error: collection.ErrEmptyURL.Error()}}, job.Errors)
+
+	res, err := qts.proc.ProcessAndGet(context.Background(), "data1", 10, 0)
+	qts.NoError(err)
+	qts.Len(res, 2)
+}
+
+func (qts *queueTestSuite) TestQueue_Atomic() {
+	job, err := qts.q.Submit("test", false, []collection.RawData{{Url: "source1", Data: "data1"}, {Url: "", Data: "data1"}})
+	qts.NoError(err)
+	job2, err := qts.q.Submit("test", false, []collection.RawData{{Url: "source2", Data: "data2"}})
+	qts.NoError(err)
+	qts.Equal(uint64(2), job2.ID)
+
+	qts.run()
+
+	job, err = qts.q.Get(job.ID)
+	qts.NoError(err)
+	qts.Equal(StatusFailed, job.Status)
+	qts.Equal(0, job.Indexed)
+	qts.Equal(1, job.Failed)
+
+	job2, err = qts.q.Get(job2.ID)
+	qts.NoError(err)
+	qts.Equal(StatusDone, job2.Status)
+	qts.Equal(1, job2.Indexed)
+}
+
+func (qts *queueTestSuite) TestQueue_UnknownCollection() {
+	job, err := qts.q.Submit("unknown", true, []collection.RawData{{Url: "source1", Data: "data1"}})
+	qts.NoError(err)
+
+	qts.run()
+
+	job, err = qts.q.Get(job.ID)
+	qts.NoError(err)
+	qts.Equal(StatusFailed, job.Status)
+	qts.Equal(collection.ErrCollectionNotExist.Error(), job.Error)
+}
+
+func (qts *queueTestSuite) TestQueue_Resume() {
+	docs := []collection.RawData{
+		{Url: "source1", Data: "data1"},
+		{Url: "source2", Data: "data1"},
+		{Url: "source3", Data: "data1"},
+	}
+	job, err := qts.q.Submit("test", true, docs)
+	qts.NoError(err)
+
+	// The job was interrupted after the first chunk.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	qts.Error(qts.q.process(ctx, job.ID))
+	job.Status, job.Processed, job.Indexed = StatusRunning, 2, 2
+	qts.NoError(qts.q.save(job))
+
+	qts.run()
+
+	job, err = qts.q.Get(job.ID)
+	qts.NoError(err)
+	qts.Equal(StatusDone, job.Status)
+	qts.Equal(3, job.Indexed)
+	res, err := qts.proc.ProcessAndGet(context.Background(), "data1", 10, 0)
+	qts.NoError(err)
+	qts.Equal([]collection.ResponseData{{Url: "source3", Source: collection.Source{Version: 1}}}, res)
+}
+
+func (qts *queueTestSuite) TestQueue_ResumeInsideChunk() {
+	docs := []collection.RawData{
+		{Url: "source1", Data: "data1"},
+		{Url: "source2", Data: "data1"},
+		{Url: "source3", Data: "data1"},
+	}
+	job, err := qts.q.Submit("test", true, docs)
+	qts.NoError(err)
+	qts.Equal(2, job.Chunks)
+
+	// The job was interrupted after the first document.
+	job.Status, job.Processed, job.Indexed = StatusRunning, 1, 1
+	qts.NoError(qts.q.save(job))
+
+	qts.run()
+
+	job, err = qts.q.Get(job.ID)
+	qts.NoError(err)
+	qts.Equal(StatusDone, job.Status)
+	qts.Equal(3, job.Processed)
+	qts.Equal(3, job.Indexed)
+	res, err := qts.proc.ProcessAndGet(context.Background(), "data1", 10, 0)
+	qts.NoError(err)
+	qts.Len(res, 2)
+
+	// Chunks of finished jobs are removed.
+	qts.NoError(qts.store.View(func(tx storage.Tx) error {
+		es, err := tx.PrefixScan(docsBucket, encodeID(job.ID), -1)
+		qts.Empty(es)
+		return err
+	}))
+}
+
+func (qts *queueTestSuite) TestQueue_GetNotFound() {
+	_, err := qts.q.Get(1)
+	qts.Equal(ErrJobNotFound, err)
+}
+
+func TestEncodeChunks(t *testing.T) {
+	docs := []collection.RawData{
+		{Url: "source1", Data: "data1"},
+		{Url: "source2", Data: "data2"},
+		{Url: "source3", Data: "data3"},
+	}
+	chunks, err := encodeChunks(docs, 2, 1<<20)
+	assert.NoError(t, err)
+	assert.Len(t, chunks, 2)
+
+	// Chunks over the size limit are split down to single documents.
+	chunks, err = encodeChunks(docs, 2, 1)
+	assert.NoError(t, err)
+	assert.Len(t, chunks, 3)
+
+	chunks, err = encodeChunks(nil, 2, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, chunks)
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory is the Storage implementation keeping all data in memory.
+// Data is lost when the process stops, so it is meant for ephemeral indexes and tests.
+type Memory struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string]memoryValue
+}
+
+type memoryValue struct {
+	value   []byte
+	expires time.Time
+}
+
+func (v memoryValue) expired(now time.Time) bool {
+	return !v.expires.IsZero() && !now.Before(v.expires)
+}
+
+// NewMemory function-constructor of empty Memory storage.
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]map[string]memoryValue)}
+}
+
+// View runs the function in a read-only transaction.
+func (m *Memory) View(fn func(tx Tx) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return fn(&memoryTx{m: m, now: time.Now()})
+}
+
+// Update runs the function in a read-write transaction,
+// other transactions are blocked until it is finished.
+func (m *Memory) Update(fn func(tx Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx := &memoryTx{m: m, now: time.Now(), writes: make(writes)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	tx.commit()
+	return nil
+}
+
+type memoryTx struct {
+	m      *Memory
+	now    time.Time
+	writes writes
+}
+
+func (t *memoryTx) Get(bucket string, key []byte) ([]byte, error) {
+	if w, ok := t.writes.get(bucket, key); ok {
+		if w.deleted {
+			return nil, ErrNotFound
+		}
+		return w.value, nil
+	}
+	v, ok := t.m.buckets[bucket][string(key)]
+	if !ok || v.expired(t.now) {
+		return nil, ErrNotFound
+	}
+	return v.value, nil
+}
+
+func (t *memoryTx) Put(bucket string, key, value []byte, ttl uint32) error {
+	if t.writes == nil {
+		return ErrReadOnly
+	}
+	v := make([]byte, len(value))
+	copy(v, value)
+	t.writes.put(bucket, key, &write{value: v, ttl: ttl})
+	return nil
+}
+
+func (t *memoryTx) Delete(bucket string, key []byte) error {
+	if t.writes == nil {
+		return ErrReadOnly
+	}
+	t.writes.put(bucket, key, &write{deleted: true})
+	return nil
+}
+
+func (t *memoryTx) PrefixScan(bucket string, prefix []byte, limit int) ([]Entry, error) {
+	return limitEntries(t.scan(bucket, prefixMatcher(prefix)), limit), nil
+}
+
+func (t *memoryTx) RangeScan(bucket string, start, end []byte) ([]Entry, error) {
+	return t.scan(bucket, rangeMatcher(start, end)), nil
+}
+
+func (t *memoryTx) scan(bucket string, match func(key []byte) bool) []Entry {
+	var committed []Entry
+	for k, v := range t.m.buckets[bucket] {
+		if !v.expired(t.now) && match([]byte(k)) {
+			committed = append(committed, Entry{Key: []byte(k), Value: v.value})
+		}
+	}
+	return t.writes.merge(bucket, committed, match)
+}
+
+func (t *memoryTx) commit() {
+	for bucket, changes := range t.writes {
+		b, ok := t.m.buckets[bucket]
+		if !ok {
+			b = make(map[string]memoryValue)
+			t.m.buckets[bucket] = b
+		}
+		for k, w := range changes {
+			if w.deleted {
+				delete(b, k)
+				continue
+			}
+			v := memoryValue{value: w.value}
+			if w.ttl > 0 {
+				v.expires = t.now.Add(time.Duration(w.ttl) * time.Second)
+			}
+			b[k] = v
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+// Nuts is the Storage implementation keeping data in nutsdb.
+type Nuts struct {
+	db *nutsdb.DB
+}
+
+// NewNuts function-constructor of Nuts over the opened database.
+func NewNuts(db *nutsdb.DB) *Nuts {
+	return &Nuts{db: db}
+}
+
+// DB returns the underlying database.
+func (n *Nuts) DB() *nutsdb.DB {
+	return n.db
+}
+
+// View runs the function in a read-only nutsdb transaction.
+func (n *Nuts) View(fn func(tx Tx) error) error {
+	return n.db.View(func(tx *nutsdb.Tx) error {
+		return fn(NewNutsTx(tx))
+	})
+}
+
+// Update runs the function in a read-write nutsdb transaction.
+func (n *Nuts) Update(fn func(tx Tx) error) error {
+	return n.db.Update(func(tx *nutsdb.Tx) error {
+		return fn(NewNutsTx(tx))
+	})
+}
+
+// NewNutsTx wraps the nutsdb transaction. nutsdb transactions do not see their own writes,
+// so the wrapper keeps them until commit.
+func NewNutsTx(tx *nutsdb.Tx) Tx {
+	return &nutsTx{tx: tx, writes: make(writes)}
+}
+
+type nutsTx struct {
+	tx     *nutsdb.Tx
+	writes writes
+}
+
+func (t *nutsTx) Get(bucket string, key []byte) ([]byte, error) {
+	if w, ok := t.writes.get(bucket, key); ok {
+		if w.deleted {
+			return nil, ErrNotFound
+		}
+		return w.value, nil
+	}
+	e, err := t.tx.Get(bucket, key)
+	if err != nil {
+		if isNutsNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return e.Value, nil
+}
+
+func (t *nutsTx) Put(bucket string, key, value []byte, ttl uint32) error {
+	if err := t.tx.Put(bucket, key, value, ttl); err != nil {
+		return err
+	}
+	t.writes.put(bucket, key, &write{value: value, ttl: ttl})
+	return nil
+}
+
+func (t *nutsTx) Delete(bucket string, key []byte) error {
+	if err := t.tx.Delete(bucket, key); err != nil {
+		return err
+	}
+	t.writes.put(bucket, key, &write{deleted: true})
+	return nil
+}
+
+func (t *nutsTx) PrefixScan(bucket string, prefix []byte, limit int) ([]Entry, error) {
+	// nutsdb applies the limit before skipping deleted keys, so the limit is applied here.
+	es, err := t.tx.PrefixScan(bucket, prefix, nutsdb.ScanNoLimit)
+	if err != nil && err != nutsdb.ErrPrefixScan {
+		return nil, err
+	}
+	return limitEntries(t.writes.merge(bucket, toEntries(es), prefixMatcher(prefix)), limit), nil
+}
+
+func (t *nutsTx) RangeScan(bucket string, start, end []byte) ([]Entry, error) {
+	es, err := t.tx.RangeScan(bucket, start, end)
+	if err != nil && err != nutsdb.ErrRangeScan {
+		return nil, err
+	}
+	return t.writes.merge(bucket, toEntries(es), rangeMatcher(start, end)), nil
+}
+
+func toEntries(es nutsdb.Entries) []Entry {
+	res := make([]Entry, 0, len(es))
+	for _, e := range es {
+		res = append(res, Entry{Key: e.Key, Value: e.Value})
+	}
+	return res
+}
+
+// isNutsNotFound reports whether the nutsdb error means that the key or the bucket does not exist.
+func isNutsNotFound(err error) bool {
+	return err == nutsdb.ErrNotFoundKey ||
+		err == nutsdb.ErrKeyNotFound ||
+		strings.HasPrefix(err.Error(), "not found bucket:")
+}
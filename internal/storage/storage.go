@@ -0,0 +1,44 @@
+// Package storage provides transactional key-value storages used to keep collection indexes.
+package storage
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNotFound error to return if the key does not exist in the bucket.
+	ErrNotFound = errors.New("key not found")
+	// ErrReadOnly error to return on writes in a read-only transaction.
+	ErrReadOnly = errors.New("transaction is read-only")
+)
+
+// Storage is a transactional key-value storage divided into buckets.
+type Storage interface {
+	// View runs the function in a read-only transaction.
+	View(fn func(tx Tx) error) error
+	// Update runs the function in a read-write transaction.
+	// Writes are committed only if the function returns nil.
+	Update(fn func(tx Tx) error) error
+}
+
+// Tx is a storage transaction. Writes made by a transaction are visible to its own reads.
+// Returned values are valid only during the transaction and must not be modified.
+type Tx interface {
+	// Get returns the value of the key, or ErrNotFound.
+	Get(bucket string, key []byte) ([]byte, error)
+	// Put sets the value of the key. The key expires after ttl seconds, 0 means it never expires.
+	Put(bucket string, key, value []byte, ttl uint32) error
+	// Delete removes the key, deleting a missing key is not an error.
+	Delete(bucket string, key []byte) error
+	// PrefixScan returns at most limit entries with keys starting with the prefix in key order,
+	// a negative limit means no limit.
+	PrefixScan(bucket string, prefix []byte, limit int) ([]Entry, error)
+	// RangeScan returns entries with keys between start and end inclusive in key order.
+	RangeScan(bucket string, start, end []byte) ([]Entry, error)
+}
+
+// Entry is a key-value pair returned by scans.
+type Entry struct {
+	Key   []byte
+	Value []byte
+}
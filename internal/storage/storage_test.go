@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xujiajun/nutsdb"
+)
+
+type storageTestSuite struct {
+	suite.Suite
+	newStorage func() Storage
+	close      func()
+	s          Storage
+}
+
+func TestMemoryStorageSuit(t *testing.T) {
+	suite.Run(t, &storageTestSuite{
+		newStorage: func() Storage { return NewMemory() },
+	})
+}
+
+func TestNutsStorageSuit(t *testing.T) {
+	sts := &storageTestSuite{}
+	sts.newStorage = func() Storage {
+		dir, err := ioutil.TempDir("", "nutsdb-storage-test")
+		if err != nil {
+			panic(err)
+		}
+		opt := nutsdb.DefaultOptions
+		opt.Dir = dir
+		db, err := nutsdb.Open(opt)
+		if err != nil {
+			panic(err)
+		}
+		sts.close = func() {
+			if err := db.Close(); err != nil {
+				panic(err)
+			}
+			if err := os.RemoveAll(dir); err != nil {
+				panic(err)
+			}
+		}
+		return NewNuts(db)
+	}
+	suite.Run(t, sts)
+}
+
+func (sts *storageTestSuite) SetupTest() {
+	sts.s = sts.newStorage()
+}
+
+func (sts *storageTestSuite) TearDownTest() {
+	if sts.close != nil {
+		sts.close()
+	}
+}
+
+func (sts *storageTestSuite) put(bucket string, kv ...string) {
+	sts.NoError(sts.s.Update(func(tx Tx) error {
+		for i := 0; i < len(kv); i += 2 {
+			if err := tx.Put(bucket, []byte(kv[i]), []byte(kv[i+1]), 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func (sts *storageTestSuite) TestGetPut() {
+	sts.NoError(sts.s.View(func(tx Tx) error {
+		_, err := tx.Get("b", []byte("k"))
+		sts.Equal(ErrNotFound, err)
+		return nil
+	}))
+	sts.put("b", "k", "v")
+	sts.NoError(sts.s.View(func(tx Tx) error {
+		v, err := tx.Get("b", []byte("k"))
+		sts.NoError(err)
+		sts.Equal([]byte("v"), v)
+		_, err = tx.Get("other", []byte("k"))
+		sts.Equal(ErrNotFound, err)
+		return nil
+	}))
+}
+
+func (sts *storageTestSuite) TestReadOwnWrites() {
+	sts.put("b", "a", "1", "b", "2")
+	sts.NoError(sts.s.Update(func(tx Tx) error {
+		sts.NoError(tx.Put("b", []byte("c"), []byte("3"), 0))
+		sts.NoError(tx.Delete("b", []byte("a")))
+		sts.NoError(tx.Put("b", []byte("b"), []byte("22"), 0))
+
+		v, err := tx.Get("b", []byte("c"))
+		sts.NoError(err)
+		sts.Equal([]byte("3"), v)
+		_, err = tx.Get("b", []byte("a"))
+		sts.Equal(ErrNotFound, err)
+
+		es, err := tx.PrefixScan("b", nil, -1)
+		sts.NoError(err)
+		sts.Equal([]Entry{
+			{Key: []byte("b"), Value: []byte("22")},
+			{Key: []byte("c"), Value: []byte("3")},
+		}, es)
+		return nil
+	}))
+}
+
+func (sts *storageTestSuite) TestRollback() {
+	sts.put("b", "a", "1")
+	errTest := errors.New("test")
+	sts.Equal(errTest, sts.s.Update(func(tx Tx) error {
+		sts.NoError(tx.Put("b", []byte("a"), []byte("2"), 0))
+		sts.NoError(tx.Put("b", []byte("b"), []byte("2"), 0))
+		return errTest
+	}))
+	sts.NoError(sts.s.View(func(tx Tx) error {
+		v, err := tx.Get("b", []byte("a"))
+		sts.NoError(err)
+		sts.Equal([]byte("1"), v)
+		_, err = tx.Get("b", []byte("b"))
+		sts.Equal(ErrNotFound, err)
+		return nil
+	}))
+}
+
+func (sts *storageTestSuite) TestScans() {
+	sts.put("b", "a1", "1", "a2", "2", "a3", "3", "b1", "4")
+	sts.NoError(sts.s.Update(func(tx Tx) error {
+		return tx.Delete("b", []byte("a1"))
+	}))
+	sts.NoError(sts.s.View(func(tx Tx) error {
+		es, err := tx.PrefixScan("b", []byte("a"), 1)
+		sts.NoError(err)
+		sts.Equal([]Entry{{Key: []byte("a2"), Value: []byte("2")}}, es)
+
+		es, err = tx.PrefixScan("b", []byte("c"), -1)
+		sts.NoError(err)
+		sts.Empty(es)
+
+		es, err = tx.RangeScan("b", []byte("a3"), []byte("b1"))
+		sts.NoError(err)
+		sts.Equal([]Entry{
+			{Key: []byte("a3"), Value: []byte("3")},
+			{Key: []byte("b1"), Value: []byte("4")},
+		}, es)
+
+		es, err = tx.RangeScan("missing", []byte("a"), []byte("b"))
+		sts.NoError(err)
+		sts.Empty(es)
+		return nil
+	}))
+}
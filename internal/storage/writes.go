@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+)
+
+// write is an uncommitted change of a key.
+type write struct {
+	value   []byte
+	ttl     uint32
+	deleted bool
+}
+
+// writes keeps uncommitted changes of a transaction by bucket and key,
+// so that the transaction can read its own writes.
+type writes map[string]map[string]*write
+
+func (w writes) get(bucket string, key []byte) (*write, bool) {
+	v, ok := w[bucket][string(key)]
+	return v, ok
+}
+
+func (w writes) put(bucket string, key []byte, v *write) {
+	b, ok := w[bucket]
+	if !ok {
+		b = make(map[string]*write)
+		w[bucket] = b
+	}
+	b[string(key)] = v
+}
+
+// merge applies uncommitted changes of keys matching the function to committed entries,
+// the result is sorted by key.
+func (w writes) merge(bucket string, committed []Entry, match func(key []byte) bool) []Entry {
+	changes := w[bucket]
+	res := make([]Entry, 0, len(committed))
+	for _, e := range committed {
+		if _, ok := changes[string(e.Key)]; !ok {
+			res = append(res, e)
+		}
+	}
+	for k, v := range changes {
+		if !v.deleted && match([]byte(k)) {
+			res = append(res, Entry{Key: []byte(k), Value: v.value})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].Key, res[j].Key) < 0
+	})
+	return res
+}
+
+func prefixMatcher(prefix []byte) func(key []byte) bool {
+	return func(key []byte) bool {
+		return bytes.HasPrefix(key, prefix)
+	}
+}
+
+func rangeMatcher(start, end []byte) func(key []byte) bool {
+	return func(key []byte) bool {
+		return bytes.Compare(key, start) >= 0 && bytes.Compare(key, end) <= 0
+	}
+}
+
+func limitEntries(entries []Entry, limit int) []Entry {
+	if limit >= 0 && len(entries) > limit {
+		return entries[:limit]
+	}
+	return entries
+}
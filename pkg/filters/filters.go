@@ -3,6 +3,8 @@
 package filters
 
 import (
+	"reflect"
+	"runtime"
 	"strings"
 	"unicode"
 
@@ -16,17 +18,45 @@ type Filter func(tokens []string) []string
 // Tokenizer is type to tokenize text and apply filters to tokens.
 type Tokenizer func(text string, filters ...Filter) []string
 
+// Word is a word of a text with its byte offsets in the text.
+type Word struct {
+	Text  string
+	Start int
+	End   int
+}
+
+// Words divide text to words the same way as FilterText and returns them with their offsets.
+func Words(text string) []Word {
+	var words []Word
+	add := func(start, end int) {
+		if w := text[start:end]; w != "'" && w != "-" {
+			words = append(words, Word{Text: w, Start: start, End: end})
+		}
+	}
+	start := -1
+	for i, c := range text {
+		if unicode.IsLetter(c) || unicode.IsNumber(c) || c == '\'' || c == '-' {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			add(start, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		add(start, len(text))
+	}
+	return words
+}
+
 // FilterText divide text to tokens, trim tokens and apply filters to tokens.
 func FilterText(text string, filters ...Filter) []string {
-	tokens := strings.FieldsFunc(text, func(c rune) bool {
-		return !unicode.IsLetter(c) && !unicode.IsNumber(c) && c != '\'' && c != '-'
-	})
-
 	var output []string
-	for _, token := range tokens {
-		if token != "'" && token != "-" {
-			output = append(output, token)
-		}
+	for _, w := range Words(text) {
+		output = append(output, w.Text)
 	}
 
 	for _, filter := range filters {
@@ -56,3 +86,69 @@ func StemmAndToLower(tokens []string) []string {
 	}
 	return output
 }
+
+// Token describes a single token as it passes through the analysis chain.
+type Token struct {
+	// Position is the position of the token in the tokenizer output.
+	Position int `json:"position"`
+	// Forms contains the token after each stage, starting with the tokenizer.
+	// It is shorter than the list of stages if the token was dropped.
+	Forms []string `json:"forms"`
+	// DroppedBy is the name of the filter that removed the token.
+	DroppedBy string `json:"dropped_by,omitempty"`
+	// IndexPosition is the position the token gets in the index, -1 if it was dropped.
+	IndexPosition int `json:"index_position"`
+}
+
+// Analysis is the result of running text through a tokenizer and filters stage by stage.
+type Analysis struct {
+	Stages []string `json:"stages"`
+	Tokens []Token  `json:"tokens"`
+}
+
+// Analyze splits text with the tokenizer and passes every token through the filters one by one,
+// recording its intermediate form after each stage.
+// Filters are applied to each token separately, so it only gives meaningful results
+// for filters that work token by token.
+func Analyze(text string, tokenizer Tokenizer, filters ...Filter) Analysis {
+	a := Analysis{Stages: make([]string, 0, len(filters)+1)}
+	a.Stages = append(a.Stages, "tokenizer")
+	for _, filter := range filters {
+		a.Stages = append(a.Stages, Name(filter))
+	}
+
+	tokens := tokenizer(text)
+	a.Tokens = make([]Token, 0, len(tokens))
+	indexPos := 0
+	for i, token := range tokens {
+		t := Token{Position: i, Forms: []string{token}, IndexPosition: -1}
+		current := []string{token}
+		for j, filter := range filters {
+			current = filter(current)
+			if len(current) == 0 {
+				t.DroppedBy = a.Stages[j+1]
+				break
+			}
+			t.Forms = append(t.Forms, current[0])
+		}
+		if t.DroppedBy == "" {
+			t.IndexPosition = indexPos
+			indexPos++
+		}
+		a.Tokens = append(a.Tokens, t)
+	}
+	return a
+}
+
+// Name returns the name of the function implementing the filter.
+func Name(filter Filter) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(filter).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}